@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/lorendsnow/updater/internal/updater"
+	"github.com/spf13/cobra"
+)
+
+// countCmd represents a command to check that every source can be downloaded and parsed.
+var countCmd = &cobra.Command{
+	Use:   "count",
+	Short: "Count the records and parse errors of each CSV url",
+	Long: `Download and parse every configured CSV url, printing the number of records and
+parse errors of each without keeping the records in memory or writing anything to
+the database. Exits with a non-zero status if any url fails to download or parse,
+or holds no records, making it a lightweight check that upstream data is present
+and parseable.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+
+		format, err := resolveReadFormat()
+		if err != nil {
+			return fail("invalid_format", "invalid --format", err, nil)
+		}
+
+		if err := validateConfig(); err != nil {
+			return fail("invalid_config", "configuration is invalid", err, nil)
+		}
+
+		urls, err := config.AllCSVUrls()
+		if err != nil {
+			return fail("invalid_config", "configuration is invalid", err, nil)
+		}
+
+		service := updater.NewUpdateService(&config, logger)
+
+		var rows [][]any
+		var failed []string
+		for _, url := range urls {
+			result, err := service.CountURL(context.Background(), url)
+			if err != nil {
+				rows = append(rows, []any{url, nil, nil, err.Error()})
+				failed = append(failed, url)
+				continue
+			}
+
+			rows = append(rows, []any{url, result.Parsed, result.Stats.Errors(), nil})
+			if result.Parsed == 0 {
+				failed = append(failed, url)
+			}
+		}
+
+		err = renderRows(format, []string{"url", "records", "errors", "error"}, rows)
+		if err != nil {
+			return fail("output_failed", "unable to write counts", err, nil)
+		}
+
+		if len(failed) > 0 {
+			return fail("count_failed", "some urls failed or held no records", nil, map[string]any{
+				"urls": failed,
+			})
+		}
+
+		return nil
+	},
+}
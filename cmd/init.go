@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	cfg "github.com/lorendsnow/updater/internal/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var initForce bool
+
+// DEFAULT_INIT_HTTP_TIMEOUT is the http timeout written by the init command.
+const DEFAULT_INIT_HTTP_TIMEOUT = "30s"
+
+// CONFIG_TEMPLATE is the config file written by the init command. Settings left out fall back to
+// their defaults; see the example config.yaml for the full set.
+var CONFIG_TEMPLATE = template.Must(template.New("config").Funcs(template.FuncMap{
+	"q": strconv.Quote,
+}).Parse(`database:
+  host: {{q .Database.Host}}
+  port: {{.Database.Port}}
+  username: {{q .Database.Username}}
+  password: {{q .Database.Password}}
+  name: {{q .Database.Name}}
+service:
+  check-interval: {{q .Service.CheckInterval}}
+  csv-urls:
+{{- range .Service.CSVUrls}}
+    - {{q .}}
+{{- end}}
+  blue-table: {{q .Service.BlueTable}}
+  green-table: {{q .Service.GreenTable}}
+http:
+  timeout: {{q .HTTP.Timeout}}
+logger:
+  level: {{q .Logger.Level}}
+  format: {{q .Logger.Format}}
+`))
+
+// initSetting is a setting the init command asks for, unless it was given as a flag.
+type initSetting struct {
+	flag     string
+	label    string
+	def      string
+	validate func(string) error
+	set      func(*cfg.Config, string)
+}
+
+// initSettings are the settings written by the init command, in the order they are asked for.
+var initSettings = []initSetting{
+	{
+		flag:     "host",
+		label:    "MySQL host",
+		def:      "localhost",
+		validate: required,
+		set:      func(c *cfg.Config, v string) { c.Database.Host = v },
+	},
+	{
+		flag:     "port",
+		label:    "MySQL port",
+		def:      "3306",
+		validate: positiveInt,
+		set:      func(c *cfg.Config, v string) { c.Database.Port, _ = strconv.Atoi(v) },
+	},
+	{
+		flag:     "user",
+		label:    "MySQL user",
+		validate: required,
+		set:      func(c *cfg.Config, v string) { c.Database.Username = v },
+	},
+	{
+		flag:  "pass",
+		label: "MySQL password",
+		set:   func(c *cfg.Config, v string) { c.Database.Password = v },
+	},
+	{
+		flag:     "name",
+		label:    "MySQL database name",
+		validate: required,
+		set:      func(c *cfg.Config, v string) { c.Database.Name = v },
+	},
+	{
+		flag:     "interval",
+		label:    "Check interval",
+		def:      cfg.DEFAULT_CHECK_INTERVAL,
+		validate: duration,
+		set:      func(c *cfg.Config, v string) { c.Service.CheckInterval = v },
+	},
+	{
+		flag:     "csv",
+		label:    "CSV urls (comma-separated)",
+		validate: urlList,
+		set:      func(c *cfg.Config, v string) { c.Service.CSVUrls = splitList(v) },
+	},
+	{
+		flag:     "blue-table",
+		label:    "Blue table name",
+		def:      "updates_blue",
+		validate: required,
+		set:      func(c *cfg.Config, v string) { c.Service.BlueTable = v },
+	},
+	{
+		flag:     "green-table",
+		label:    "Green table name",
+		def:      "updates_green",
+		validate: required,
+		set:      func(c *cfg.Config, v string) { c.Service.GreenTable = v },
+	},
+}
+
+// initCmd represents a command to write a new config file.
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a config file",
+	Long: `Write a new config file to the --config path, asking for the database and service
+settings not given as flags. Each answer is checked as it is entered, and the
+finished configuration is validated before it is written. An existing file is
+only overwritten with --force.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := os.Stat(cfgFile); err == nil && !initForce {
+			return fail("config_exists", "config file already exists", nil, map[string]any{
+				"file": cfgFile,
+				"hint": "use --force to overwrite it",
+			})
+		}
+
+		cfg.BindAllFlags(cmd)
+		if err := viper.Unmarshal(&config); err != nil {
+			return fail("config_decode_failed", "unable to decode into struct", err, nil)
+		}
+
+		in := bufio.NewReader(os.Stdin)
+		for _, setting := range initSettings {
+			value, err := settingValue(cmd, in, setting)
+			if err != nil {
+				return fail("invalid_setting", "invalid "+setting.label, err, map[string]any{
+					"flag": setting.flag,
+				})
+			}
+			setting.set(&config, value)
+		}
+
+		if config.HTTP.Timeout == "" {
+			config.HTTP.Timeout = DEFAULT_INIT_HTTP_TIMEOUT
+		}
+		config.Logger.Level = "info"
+		config.Logger.Format = "text"
+
+		if err := validateConfig(); err != nil {
+			return fail("invalid_config", "configuration is invalid", err, nil)
+		}
+
+		var out strings.Builder
+		if err := CONFIG_TEMPLATE.Execute(&out, &config); err != nil {
+			return fail("write_failed", "unable to render config file", err, nil)
+		}
+
+		// The file holds the database password, so only the owner may read it.
+		if err := os.WriteFile(cfgFile, []byte(out.String()), 0o600); err != nil {
+			return fail("write_failed", "unable to write config file", err, map[string]any{
+				"file": cfgFile,
+			})
+		}
+
+		succeed("wrote config file", map[string]any{"file": cfgFile})
+
+		return nil
+	},
+}
+
+// settingValue returns the value of setting from its flag if it was given, and otherwise asks for
+// it on stdout, repeating the question until a valid answer is read from in. Answers are read until
+// in is exhausted, after which the default is used.
+func settingValue(cmd *cobra.Command, in *bufio.Reader, setting initSetting) (string, error) {
+	validate := setting.validate
+	if validate == nil {
+		validate = func(string) error { return nil }
+	}
+
+	if flag := cmd.Flags().Lookup(setting.flag); flag != nil && flag.Changed {
+		value := flag.Value.String()
+		if setting.flag == "csv" {
+			values, _ := cmd.Flags().GetStringArray("csv")
+			value = strings.Join(values, ",")
+		}
+		return value, validate(value)
+	}
+
+	for {
+		if setting.def != "" {
+			fmt.Printf("%s [%s]: ", setting.label, setting.def)
+		} else {
+			fmt.Printf("%s: ", setting.label)
+		}
+
+		answer, err := in.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+
+		value := strings.TrimSpace(answer)
+		if value == "" {
+			value = setting.def
+		}
+
+		validationErr := validate(value)
+		if validationErr == nil {
+			return value, nil
+		}
+		if errors.Is(err, io.EOF) {
+			fmt.Println()
+			return "", validationErr
+		}
+
+		fmt.Printf("  %v\n", validationErr)
+	}
+}
+
+// required rejects empty values.
+func required(value string) error {
+	if value == "" {
+		return errors.New("a value is required")
+	}
+
+	return nil
+}
+
+// positiveInt rejects values that aren't whole numbers greater than 0.
+func positiveInt(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+		return errors.New("must be a whole number greater than 0")
+	}
+
+	return nil
+}
+
+// duration rejects values that aren't Go durations, such as 1h or 30m.
+func duration(value string) error {
+	if _, err := time.ParseDuration(value); err != nil {
+		return errors.New("must be a duration, such as 1h or 30m")
+	}
+
+	return nil
+}
+
+// urlList rejects values that aren't a comma-separated list of at least one absolute url.
+func urlList(value string) error {
+	urls := splitList(value)
+	if len(urls) == 0 {
+		return errors.New("at least one url is required")
+	}
+
+	for _, u := range urls {
+		if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("%q is not a valid url", u)
+		}
+	}
+
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lorendsnow/updater/internal/updater"
+	"github.com/spf13/cobra"
+)
+
+var inspectRows int
+
+// csvInspection describes the layout of a CSV file and how its columns map to Record fields.
+type csvInspection struct {
+	URL     string          `json:"url"`
+	Header  []string        `json:"header"`
+	Columns int             `json:"columns"`
+	Mapping []columnMapping `json:"mapping"`
+	Sample  [][]string      `json:"sample"`
+}
+
+// columnMapping pairs a CSV column with the Record field it would be parsed into, if any.
+type columnMapping struct {
+	Position int    `json:"position"`
+	Header   string `json:"header"`
+	Field    string `json:"field,omitempty"`
+}
+
+// inspectCSVCmd represents a command to describe a CSV file without writing anything.
+var inspectCSVCmd = &cobra.Command{
+	Use:   "inspect-csv <url>",
+	Short: "Describe a CSV file and how it maps to Record fields",
+	Long: `Download a CSV file and print its header, column count, a few sample rows, and
+which columns would be parsed into which Record fields. Useful for checking a new
+data source before adding it to the configuration. Nothing is written to the
+database.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+
+		url := args[0]
+		service := updater.NewUpdateService(&config, logger)
+
+		body, err := service.DownloadCSV(context.Background(), url)
+		if err != nil {
+			details := map[string]any{"url": url}
+			return fail("download_failed", "unable to download csv", err, details)
+		}
+		defer body.Close()
+
+		inspection, err := inspectCSV(url, body, inspectRows)
+		if err != nil {
+			return fail("invalid_csv", "unable to read csv", err, map[string]any{"url": url})
+		}
+
+		if err := printInspection(inspection); err != nil {
+			return fail("output_failed", "unable to write inspection", err, nil)
+		}
+
+		return nil
+	},
+}
+
+// inspectCSV reads the header and up to rows data rows from r.
+func inspectCSV(url string, r io.Reader, rows int) (csvInspection, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return csvInspection{}, fmt.Errorf("reading header: %w", err)
+	}
+
+	inspection := csvInspection{URL: url, Header: header, Columns: len(header)}
+	for i, name := range header {
+		mapping := columnMapping{Position: i, Header: name}
+		if i < len(updater.CSV_FIELDS) {
+			mapping.Field = updater.CSV_FIELDS[i]
+		}
+		inspection.Mapping = append(inspection.Mapping, mapping)
+	}
+
+	for len(inspection.Sample) < rows {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return csvInspection{}, fmt.Errorf("reading row: %w", err)
+		}
+		inspection.Sample = append(inspection.Sample, row)
+	}
+
+	return inspection, nil
+}
+
+// printInspection writes inspection to stdout, as JSON with json output and as text otherwise.
+func printInspection(inspection csvInspection) error {
+	if jsonOutput() {
+		return json.NewEncoder(os.Stdout).Encode(inspection)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "url:\t%s\n", inspection.URL)
+	fmt.Fprintf(w, "columns:\t%d (expected %d)\n", inspection.Columns, len(updater.CSV_FIELDS))
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "POSITION\tHEADER\tFIELD")
+	for _, m := range inspection.Mapping {
+		field := m.Field
+		if field == "" {
+			field = "(unused)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Position, m.Header, field)
+	}
+	for i := len(inspection.Mapping); i < len(updater.CSV_FIELDS); i++ {
+		fmt.Fprintf(w, "%d\t(missing)\t%s\n", i, updater.CSV_FIELDS[i])
+	}
+
+	if len(inspection.Sample) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, strings.Join(inspection.Header, "\t"))
+		for _, row := range inspection.Sample {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+	}
+
+	return w.Flush()
+}
@@ -1,11 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	cfg "github.com/lorendsnow/updater/internal/config"
+	"github.com/lorendsnow/updater/internal/server"
+	"github.com/lorendsnow/updater/internal/updater"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // launchCmd represents a command to launch the updater service, periodically downloading CSV files
@@ -16,29 +23,192 @@ var launchCmd = &cobra.Command{
 	Long: `Launch the updater service which periodically downloads CSV files from a website,
 and updates a MySQL database with those values. The service uses a blue/green
 deployment strategy using alternating tables to update the database.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		cfg.BindAllFlags(cmd)
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+
+		if err := validateConfig(); err != nil {
+			return fail("invalid_config", "configuration is invalid", err, nil)
+		}
+
+		// Reloads replace config from other goroutines once the config file is watched, so
+		// the service is started from a copy.
+		current := currentConfig()
+
+		logger.Info("starting updater service", startupBanner(current)...)
+		logger.Debug("effective configuration", "config", current)
 
-		if err := viper.Unmarshal(&config); err != nil {
-			logger.Error("unable to decode into struct", "error", err)
-			os.Exit(1)
+		if current.Profile.Enabled {
+			startProfiler(current.Profile.Address)
 		}
 
-		appLogger, err := config.MakeLogger()
+		service := updater.NewUpdateService(&current, logger)
+		if err := service.ConnectToDatabase(&current); err != nil {
+			return fail("connection_failed", "unable to connect to database", err, nil)
+		}
+
+		cfg.Watch(logger, func(updated cfg.Config) {
+			applyConfig(service, updated)
+		})
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		ctx, cancel, err := withMaxRuntime(ctx, current.Service.MaxRuntime)
 		if err != nil {
-			config.Logger.Level = "info"
-			config.Logger.Format = "text"
-			logger.Error(
-				"unable to create application logger, using default logging configuration",
-				"error",
+			return fail(
+				"invalid_config",
+				"invalid max runtime",
 				err,
+				map[string]any{"max-runtime": current.Service.MaxRuntime},
+			)
+		}
+		defer cancel()
+
+		if current.Server.Address != "" {
+			srv := server.NewServer(
+				current.Server.Address,
+				current.Server.ReloadSecret,
+				service,
+				logger,
 			)
+			srv.Start()
+
+			defer func() {
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				if err := srv.Shutdown(shutdownCtx); err != nil {
+					logger.Error("failed to shut down http server", "error", err)
+				}
+			}()
+		}
+
+		go reloadOnHangup(ctx, service)
+
+		if err := service.Run(ctx); err != nil {
+			return fail("service_failed", "updater service stopped with an error", err, nil)
 		}
 
-		if appLogger != nil {
-			logger = appLogger
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			logger.Info("max runtime reached, exiting")
 		}
 
-		logger.Info("starting updater service", "config", config)
+		return nil
 	},
 }
+
+// withMaxRuntime returns a copy of ctx that is cancelled once maxRuntime has elapsed, so the
+// service exits cleanly after a fixed duration. A blank or zero maxRuntime runs without a limit.
+func withMaxRuntime(
+	ctx context.Context,
+	maxRuntime string,
+) (context.Context, context.CancelFunc, error) {
+	var duration time.Duration
+	if maxRuntime != "" {
+		parsed, err := time.ParseDuration(maxRuntime)
+		if err != nil {
+			return nil, nil, err
+		}
+		duration = parsed
+	}
+
+	if duration <= 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+
+	logger.Info("service will exit after max runtime", "max-runtime", duration)
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	return ctx, cancel, nil
+}
+
+// startupBanner returns the log attributes summarizing current, the effective configuration at
+// launch, kept to one line for grepping. Secrets are left out, and the database target doesn't
+// include the password.
+func startupBanner(current cfg.Config) []any {
+	urls, err := current.AllCSVUrls()
+	if err != nil {
+		urls = current.Service.CSVUrls
+	}
+
+	return []any{
+		"interval", current.Service.CheckInterval,
+		"cron", current.Service.Cron,
+		"cron-timezone", current.Service.CronTimezone,
+		"urls", len(urls),
+		"blue-table", current.Service.BlueTable,
+		"green-table", current.Service.GreenTable,
+		"metadata-table", current.Service.MetadataTable,
+		"write-mode", current.Service.WriteMode,
+		"database", fmt.Sprintf(
+			"%s@%s:%d/%s",
+			current.Database.Username,
+			current.Database.Host,
+			current.Database.Port,
+			current.Database.Name,
+		),
+		"log-level", current.LogLevel().String(),
+		"log-format", current.Logger.Format,
+		"server", current.Server.Address,
+	}
+}
+
+// reloadOnHangup re-reads the config file and applies it to the service each time the process
+// receives SIGHUP, until ctx is cancelled.
+func reloadOnHangup(ctx context.Context, service *updater.UpdateService) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			logger.Info("received SIGHUP, reloading config")
+
+			reloaded, err := cfg.Reload()
+			if err != nil {
+				logger.Error("unable to reload config, keeping current configuration", "error", err)
+				continue
+			}
+
+			applyConfig(service, reloaded)
+		}
+	}
+}
+
+// currentConfig returns a copy of the current config, which applyConfig replaces when the config
+// file changes or the process receives SIGHUP.
+func currentConfig() cfg.Config {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	return config
+}
+
+// applyConfig replaces the current config with updated and applies any changes to the log level
+// and the running service. Updates in progress are unaffected; the service picks up changes on its
+// next cycle.
+func applyConfig(service *updater.UpdateService, updated cfg.Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if updated.LogLevel() != config.LogLevel() {
+		logger.Info(
+			"applied log level change",
+			"old",
+			config.LogLevel(),
+			"new",
+			updated.LogLevel(),
+		)
+		logLevel.Set(updated.LogLevel())
+	}
+
+	config = updated
+	service.ApplyConfig(&updated)
+}
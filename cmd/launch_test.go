@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	cfg "github.com/lorendsnow/updater/internal/config"
+	"github.com/lorendsnow/updater/internal/updater"
+	"github.com/spf13/viper"
+)
+
+func TestWithMaxRuntime(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRuntime   string
+		wantDeadline bool
+		wantErr      bool
+	}{
+		{name: "blank runs without a limit", maxRuntime: ""},
+		{name: "zero runs without a limit", maxRuntime: "0s"},
+		{name: "short max runtime", maxRuntime: "20ms", wantDeadline: true},
+		{name: "invalid", maxRuntime: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel, err := withMaxRuntime(context.Background(), tt.maxRuntime)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("withMaxRuntime returned no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("withMaxRuntime: %v", err)
+			}
+			defer cancel()
+
+			if _, ok := ctx.Deadline(); ok != tt.wantDeadline {
+				t.Fatalf("has deadline = %v, want %v", ok, tt.wantDeadline)
+			}
+			if !tt.wantDeadline {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+				t.Fatal("context not cancelled after max runtime")
+			}
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				t.Errorf("ctx.Err() = %v, want DeadlineExceeded", ctx.Err())
+			}
+		})
+	}
+}
+
+func TestLaunchConfigFromFlagsOnly(t *testing.T) {
+	// An empty directory, so there is neither a config file nor an env file to read.
+	t.Chdir(t.TempDir())
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	args := []string{
+		"--host=localhost",
+		"--port=3306",
+		"--user=updater",
+		"--name=crime",
+		"--interval=1h",
+		"--csv=https://example.com/offenses.csv",
+		"--blue-table=crime_blue",
+		"--green-table=crime_green",
+	}
+	if err := launchCmd.ParseFlags(args); err != nil {
+		t.Fatalf("parsing flags: %v", err)
+	}
+
+	if err := initViper(); err != nil {
+		t.Fatalf("initViper: %v", err)
+	}
+	if err := loadConfig(launchCmd); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if err := validateConfig(); err != nil {
+		t.Fatalf("validateConfig: %v", err)
+	}
+
+	if config.Database.Host != "localhost" || config.Database.Port != 3306 {
+		t.Errorf(
+			"database = %s:%d, want localhost:3306",
+			config.Database.Host,
+			config.Database.Port,
+		)
+	}
+	if config.Service.BlueTable != "crime_blue" || config.Service.GreenTable != "crime_green" {
+		t.Errorf(
+			"tables = %s and %s, want crime_blue and crime_green",
+			config.Service.BlueTable,
+			config.Service.GreenTable,
+		)
+	}
+	if config.HTTP.Timeout == "" || config.Logger.Format == "" {
+		t.Errorf("http.timeout and logger.format were not defaulted: %+v", config)
+	}
+}
+
+func TestApplyConfigConcurrentReads(t *testing.T) {
+	previous, previousLevel := currentConfig(), logLevel.Level()
+	t.Cleanup(func() {
+		config = previous
+		logLevel.Set(previousLevel)
+	})
+
+	var initial cfg.Config
+	initial.HTTP.Timeout = "30s"
+	initial.Service.CheckInterval = "1h"
+	initial.Logger.Level = "info"
+	config = initial
+	service := updater.NewUpdateService(&initial, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name     string
+		interval string
+		level    string
+	}{
+		{name: "interval change", interval: "2h", level: "info"},
+		{name: "log level change", interval: "2h", level: "debug"},
+		{name: "both", interval: "30m", level: "warn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := initial
+			updated.Service.CheckInterval = tt.interval
+			updated.Logger.Level = tt.level
+
+			// Readers see the config before or after the change, never a partial one.
+			var wg sync.WaitGroup
+			for range 4 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range 100 {
+						currentConfig()
+					}
+				}()
+			}
+			applyConfig(service, updated)
+			wg.Wait()
+
+			got := currentConfig()
+			if got.Service.CheckInterval != tt.interval || got.Logger.Level != tt.level {
+				t.Errorf(
+					"current config has interval %s and log level %s, want %s and %s",
+					got.Service.CheckInterval,
+					got.Logger.Level,
+					tt.interval,
+					tt.level,
+				)
+			}
+			if logLevel.Level() != updated.LogLevel() {
+				t.Errorf("log level = %s, want %s", logLevel.Level(), updated.LogLevel())
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+ *==================================================================================================
+ * Read Formats
+ *==================================================================================================
+ */
+
+// FORMAT_TABLE, FORMAT_JSON and FORMAT_CSV are the --format values of the read commands: aligned
+// columns, one JSON object per row, and CSV with a header row.
+const FORMAT_TABLE = "table"
+const FORMAT_JSON = "json"
+const FORMAT_CSV = "csv"
+
+// READ_FORMATS lists the valid --format values.
+var READ_FORMATS = []string{FORMAT_TABLE, FORMAT_JSON, FORMAT_CSV}
+
+/*
+ *==================================================================================================
+ * Output Payloads
+ *==================================================================================================
+ */
+
+// errorPayload is the structured error written to stderr on failure when --output is json.
+type errorPayload struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// commandError is a command failure, returned by a command for Execute to report.
+type commandError struct {
+	Code    string
+	Message string
+	Err     error
+	Details map[string]any
+}
+
+func (e *commandError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *commandError) Unwrap() error {
+	return e.Err
+}
+
+// resultPayload is the structured result written to stdout on success when --output is json.
+type resultPayload struct {
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// jsonOutput reports whether the --output flag requests json output.
+func jsonOutput() bool {
+	return strings.ToLower(outputFormat) == "json"
+}
+
+// resolveReadFormat returns the output format of a read command: --format if it was given, json if
+// --output is json, and otherwise table when stdout is a terminal and json when it isn't, so piped
+// output is scriptable by default.
+func resolveReadFormat() (string, error) {
+	if readFormat != "" {
+		format := strings.ToLower(readFormat)
+		if !slices.Contains(READ_FORMATS, format) {
+			return "", fmt.Errorf(
+				"unknown format %q (must be one of %s)",
+				readFormat,
+				strings.Join(READ_FORMATS, ", "),
+			)
+		}
+		return format, nil
+	}
+
+	if jsonOutput() || !stdoutIsTerminal() {
+		return FORMAT_JSON, nil
+	}
+
+	return FORMAT_TABLE, nil
+}
+
+// stdoutIsTerminal reports whether stdout is a terminal rather than a file or pipe.
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// renderRows writes rows to stdout in format, each row holding a value for each of columns. Table
+// and CSV output start with a header row of the column names and format missing values as blank
+// fields, while JSON output writes each row as an object keyed by column name.
+func renderRows(format string, columns []string, rows [][]any) error {
+	switch format {
+	case FORMAT_JSON:
+		encoder := json.NewEncoder(os.Stdout)
+		for _, values := range rows {
+			row := make(map[string]any, len(columns))
+			for i, column := range columns {
+				row[column] = values[i]
+			}
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FORMAT_CSV:
+		w := csv.NewWriter(os.Stdout)
+		if err := w.Write(columns); err != nil {
+			return err
+		}
+		for _, values := range rows {
+			if err := w.Write(formatValues(values)); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(columns, "\t"))
+		for _, values := range rows {
+			fmt.Fprintln(w, strings.Join(formatValues(values), "\t"))
+		}
+		return w.Flush()
+	}
+}
+
+// formatValues formats each of values with formatValue.
+func formatValues(values []any) []string {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		fields[i] = formatValue(value)
+	}
+
+	return fields
+}
+
+// formatValue formats a value for table or CSV output, leaving missing values blank.
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.DateTime)
+	case *float64:
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(*v)
+	case *int:
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(*v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// fail returns a command failure for the command to return. Execute reports it and main exits
+// with a non-zero status.
+func fail(code string, message string, err error, details map[string]any) error {
+	return &commandError{Code: code, Message: message, Err: err, Details: details}
+}
+
+// report reports the error a command failed with. With json output the failure is written to
+// stderr as an errorPayload, otherwise it is logged. Errors that didn't come from fail, such as
+// cobra's errors for unknown flags, are reported as usage errors.
+func report(err error) {
+	var failure *commandError
+	if !errors.As(err, &failure) {
+		failure = &commandError{Code: "invalid_usage", Message: "invalid command usage", Err: err}
+	}
+
+	details := maps.Clone(failure.Details)
+	if details == nil {
+		details = map[string]any{}
+	}
+	if failure.Err != nil {
+		details["error"] = errorMessages(failure.Err)
+	}
+
+	if jsonOutput() {
+		json.NewEncoder(os.Stderr).Encode(errorPayload{
+			Code:    failure.Code,
+			Message: failure.Message,
+			Details: details,
+		})
+		return
+	}
+
+	logger.Error(failure.Message, append([]any{"code", failure.Code}, detailAttrs(details)...)...)
+}
+
+// succeed reports a successful command result. With json output the result is written to stdout
+// as a resultPayload, otherwise it is logged.
+func succeed(message string, details map[string]any) {
+	if jsonOutput() {
+		json.NewEncoder(os.Stdout).Encode(resultPayload{Message: message, Details: details})
+		return
+	}
+
+	logger.Info(message, detailAttrs(details)...)
+}
+
+// detailAttrs flattens details into key/value pairs for logging, sorted by key.
+func detailAttrs(details map[string]any) []any {
+	var attrs []any
+	for _, key := range slices.Sorted(maps.Keys(details)) {
+		attrs = append(attrs, key, details[key])
+	}
+
+	return attrs
+}
+
+// errorMessages returns the message of err, or a list of messages if err joins multiple errors,
+// flattening any joined errors nested inside it.
+func errorMessages(err error) any {
+	if _, ok := err.(interface{ Unwrap() []error }); !ok {
+		return err.Error()
+	}
+
+	return joinedMessages(err)
+}
+
+// joinedMessages returns the messages of the errors joined in err, recursively.
+func joinedMessages(err error) []string {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []string{err.Error()}
+	}
+
+	var messages []string
+	for _, e := range joined.Unwrap() {
+		messages = append(messages, joinedMessages(e)...)
+	}
+
+	return messages
+}
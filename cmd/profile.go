@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// startProfiler serves the standard /debug/pprof endpoints on addr in the background. The address
+// defaults to localhost, so a warning is logged if it has been overridden to listen elsewhere.
+func startProfiler(addr string) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		logger.Error("invalid profile address, profiler disabled", "address", addr, "error", err)
+		return
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		logger.Warn("profiler is listening on a non-loopback address", "address", addr)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		logger.Info("starting profiler", "address", addr)
+
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error("profiler stopped", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/lorendsnow/updater/internal/updater"
+	"github.com/spf13/cobra"
+)
+
+var promoteTable string
+
+// promoteCmd represents a command to make the table staged by a --no-swap update, or a named
+// table, active.
+var promoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Make the table staged by a --no-swap update, or a named table, active",
+	Long: `Make the blue/green table written by the last --no-swap update cycle active, once
+its data has been reviewed, archiving the previous dataset as a regular update
+would. Refuses to promote a table that isn't staged, including one written again
+since it was staged.
+
+With --table, make the named blue/green table active whether or not it was
+staged, as long as it isn't empty, passes validation, and wasn't written by an
+update that didn't finish.
+
+A running service picks up the change, and publishes it to its /events
+subscribers, the next time it loads the table metadata, at the latest at the
+start of its next update cycle.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+
+		if err := validateConfig(); err != nil {
+			return fail("invalid_config", "configuration is invalid", err, nil)
+		}
+
+		ctx := context.Background()
+
+		db, err := updater.OpenDatabase(ctx, &config, logger)
+		if err != nil {
+			return fail("connection_failed", "unable to connect to database", err, nil)
+		}
+		defer db.Close()
+
+		service := updater.NewUpdateService(&config, logger)
+		service.UseDatabase(db)
+
+		if promoteTable != "" {
+			if err := service.PromoteTable(ctx, promoteTable); err != nil {
+				return fail("promote_failed", "unable to promote table", err, map[string]any{
+					"table": promoteTable,
+				})
+			}
+
+			succeed("promoted table", map[string]any{"table": promoteTable})
+			return nil
+		}
+
+		table, err := service.Promote(ctx)
+		if err != nil {
+			return fail("promote_failed", "unable to promote staged table", err, nil)
+		}
+
+		succeed("promoted staged table", map[string]any{"table": table})
+
+		return nil
+	},
+}
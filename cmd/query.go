@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lorendsnow/updater/internal/repository"
+	"github.com/lorendsnow/updater/internal/updater"
+	"github.com/spf13/cobra"
+)
+
+var (
+	queryLimit         int
+	queryColumns       []string
+	querySince         string
+	queryUntil         string
+	queryNeighborhoods []string
+	queryCategories    []string
+	queryBothTables    bool
+)
+
+// QUERY_TIME_LAYOUTS are the layouts accepted by --since and --until, tried in order. Times
+// without a zone are in UTC, as the records are stored.
+var QUERY_TIME_LAYOUTS = []string{time.DateOnly, time.DateTime, time.RFC3339}
+
+// queryCmd represents a command to print records from the active table.
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Print records from the active table",
+	Long: `Print records from whichever of the blue/green tables is currently active, as a
+table, as one JSON object per record, or as CSV, chosen with --format. Use
+--columns to restrict the query and output to the named Record fields, and
+--since, --until, --neighborhood and --category to only print matching records.
+The filters are applied by the database, not after reading the records.
+
+With --both-tables, records are read from the blue and green tables combined,
+each record present in both printed once as the active table holds it. This
+needs database.union-reads, since it is much slower than reading one table.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+
+		format, err := resolveReadFormat()
+		if err != nil {
+			return fail("invalid_format", "invalid --format", err, nil)
+		}
+
+		if err := validateConfig(); err != nil {
+			return fail("invalid_config", "configuration is invalid", err, nil)
+		}
+
+		filter, err := queryFilter()
+		if err != nil {
+			return fail("invalid_filter", "invalid query filter", err, nil)
+		}
+
+		ctx := context.Background()
+
+		db, err := updater.OpenDatabase(ctx, &config, logger)
+		if err != nil {
+			return fail("connection_failed", "unable to connect to database", err, nil)
+		}
+		defer db.Close()
+
+		repo, err := repository.Open(ctx, db, &config, logger)
+		if err != nil {
+			return fail("query_failed", "unable to find the active table", err, nil)
+		}
+		defer repo.Close()
+
+		repo, err = repo.Where(filter)
+		if err != nil {
+			return fail("invalid_filter", "invalid query filter", err, nil)
+		}
+		if len(queryColumns) > 0 {
+			repo, err = repo.SelectFields(queryColumns)
+			if err != nil {
+				return fail("invalid_columns", "invalid --columns", err, nil)
+			}
+		}
+
+		var records []updater.Record
+		if queryBothTables {
+			records, err = repo.RecordsUnion(ctx, queryLimit)
+		} else {
+			records, err = repo.Records(ctx, queryLimit)
+		}
+		if err != nil {
+			return fail("query_failed", "unable to query records", err, nil)
+		}
+
+		if err := printRecords(format, repo.Columns, records); err != nil {
+			return fail("output_failed", "unable to write records", err, nil)
+		}
+
+		return nil
+	},
+}
+
+// queryFilter returns the repository.Filter set by the query command's filter flags.
+func queryFilter() (repository.Filter, error) {
+	filter := repository.Filter{
+		Neighborhoods: queryNeighborhoods,
+		Categories:    queryCategories,
+	}
+
+	var err error
+	if querySince != "" {
+		if filter.Since, err = parseQueryTime(querySince); err != nil {
+			return repository.Filter{}, fmt.Errorf("--since: %w", err)
+		}
+	}
+	if queryUntil != "" {
+		if filter.Until, err = parseQueryTime(queryUntil); err != nil {
+			return repository.Filter{}, fmt.Errorf("--until: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// parseQueryTime parses value in the first of QUERY_TIME_LAYOUTS it matches.
+func parseQueryTime(value string) (time.Time, error) {
+	for _, layout := range QUERY_TIME_LAYOUTS {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf(
+		"invalid time %q (use YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or RFC 3339)",
+		value,
+	)
+}
+
+// printRecords writes the given columns of records to stdout in format, keyed by field name.
+func printRecords(format string, columns []updater.Column, records []updater.Record) error {
+	fields := make([]string, len(columns))
+	for i, c := range columns {
+		fields[i] = c.Field
+	}
+
+	rows := make([][]any, len(records))
+	for i := range records {
+		rows[i] = make([]any, len(columns))
+		for j, c := range columns {
+			rows[i][j] = c.Value(&records[i])
+
+			// Dates the repository read as absent are null rather than the zero time.
+			if date, ok := rows[i][j].(time.Time); ok && date.IsZero() {
+				rows[i][j] = nil
+			}
+		}
+	}
+
+	return renderRows(format, fields, rows)
+}
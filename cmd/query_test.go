@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQueryTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:  "date",
+			value: "2024-03-01",
+			want:  time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "date and time",
+			value: "2024-03-01 13:45:00",
+			want:  time.Date(2024, time.March, 1, 13, 45, 0, 0, time.UTC),
+		},
+		{
+			name:  "rfc 3339",
+			value: "2024-03-01T13:45:00-05:00",
+			want:  time.Date(2024, time.March, 1, 18, 45, 0, 0, time.UTC),
+		},
+		{name: "invalid", value: "March 1st", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQueryTime(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseQueryTime(%q) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseQueryTime: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseQueryTime(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
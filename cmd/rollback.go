@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/lorendsnow/updater/internal/updater"
+	"github.com/spf13/cobra"
+)
+
+// rollbackCmd represents a command to make the previously active table active again.
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Switch back to the previously active table",
+	Long: `Make the previously active blue/green table active again without downloading
+anything, so the previous dataset serves if the latest update promoted bad data.
+Refuses to switch to a table that has never been populated or is empty.
+
+A running service picks up the change at the start of its next update cycle.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+
+		if err := validateConfig(); err != nil {
+			return fail("invalid_config", "configuration is invalid", err, nil)
+		}
+
+		ctx := context.Background()
+
+		db, err := updater.OpenDatabase(ctx, &config, logger)
+		if err != nil {
+			return fail("connection_failed", "unable to connect to database", err, nil)
+		}
+		defer db.Close()
+
+		service := updater.NewUpdateService(&config, logger)
+		service.UseDatabase(db)
+
+		table, err := service.Rollback(ctx)
+		if err != nil {
+			return fail("rollback_failed", "unable to roll back", err, nil)
+		}
+
+		succeed("rolled back active table", map[string]any{"table": table})
+
+		return nil
+	},
+}
@@ -3,11 +3,18 @@
 package cmd
 
 import (
+	"errors"
+	"io/fs"
 	"log/slog"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/joho/godotenv"
 	cfg "github.com/lorendsnow/updater/internal/config"
+	"github.com/lorendsnow/updater/internal/updater"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 /*
@@ -17,9 +24,15 @@ import (
  */
 
 var (
-	cfgFile string
-	config  cfg.Config
-	logger  = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	cfgFile      string
+	envFile      string
+	strictConfig bool
+	outputFormat string
+	readFormat   string
+	config       cfg.Config
+	configMu     sync.Mutex // guards config against concurrent reloads
+	logLevel     = new(slog.LevelVar)
+	logger       = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelDebug,
 	}))
 	rootCmd = &cobra.Command{
@@ -27,6 +40,14 @@ var (
 		Short: "A database updater service",
 		Long: `Updater is a service that periodically downloads CSV files from a website, and
 		updates a MySQL database with those values.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return initViper()
+		},
+
+		// Execute reports errors itself, in the --output format, and prints the usage only for
+		// usage errors rather than for every failed command.
+		SilenceErrors: true,
+		SilenceUsage:  true,
 	}
 )
 
@@ -36,9 +57,23 @@ var (
  *==================================================================================================
  */
 
-// Execute executes the root command.
+// Execute executes the root command, reporting the error it fails with, if any. The error is
+// returned for main to exit with a non-zero status. Usage errors, such as unknown flags or missing
+// arguments, are followed by the command's usage unless the output is json.
 func Execute() error {
-	return rootCmd.Execute()
+	cmd, err := rootCmd.ExecuteC()
+	if err == nil {
+		return nil
+	}
+
+	report(err)
+
+	var failure *commandError
+	if !errors.As(err, &failure) && !jsonOutput() {
+		cmd.PrintErr(cmd.UsageString())
+	}
+
+	return err
 }
 
 /*
@@ -49,22 +84,309 @@ func Execute() error {
 
 // init sets up the Cobra CLI interface, and a Viper configuration
 func init() {
-	cobra.OnInitialize(initViper)
-
 	rootCmd.AddCommand(launchCmd)
+	rootCmd.AddCommand(validateConfigCmd)
+	rootCmd.AddCommand(testConnectionCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(queryCmd)
+	rootCmd.AddCommand(inspectCSVCmd)
+	rootCmd.AddCommand(countCmd)
+	rootCmd.AddCommand(promoteCmd)
+	rootCmd.AddCommand(initCmd)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "config.yaml", "path to config file")
+	rootCmd.PersistentFlags().StringVar(
+		&envFile,
+		"env-file",
+		".env",
+		"file of UPDATER_* environment variables to load, if it exists",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&strictConfig,
+		"strict-config",
+		false,
+		"fail if the configuration contains unknown keys",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&outputFormat,
+		"output",
+		"text",
+		"command output format (one of text or json)",
+	)
 	rootCmd.PersistentFlags().String("host", "", "MySQL host")
 	rootCmd.PersistentFlags().Int("port", 0, "MySQL port")
 	rootCmd.PersistentFlags().String("user", "", "MySQL user")
 	rootCmd.PersistentFlags().String("pass", "", "MySQL password")
+	rootCmd.PersistentFlags().String(
+		"pass-file",
+		"",
+		"file holding the MySQL password, overriding --pass",
+	)
 	rootCmd.PersistentFlags().String("name", "", "MySQL database name")
+	rootCmd.PersistentFlags().Int(
+		"connect-retries",
+		0,
+		"times to retry connecting to the database at startup",
+	)
+	rootCmd.PersistentFlags().String(
+		"connect-max-wait",
+		"",
+		"maximum time to spend retrying the database connection at startup (0 for no limit)",
+	)
+	rootCmd.PersistentFlags().String("query-timeout", "", "timeout for database reads")
+	rootCmd.PersistentFlags().String(
+		"slow-query-threshold",
+		"",
+		"log database reads slower than this duration",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"read-only-reads",
+		false,
+		"run repository reads in read-only transactions",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"null-sentinel-dates",
+		false,
+		"read dates stored as the 01/01/1900 placeholder as missing",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"log-queries",
+		false,
+		"log every SQL statement and its number of arguments, not their values, at debug level",
+	)
+	rootCmd.PersistentFlags().String(
+		"empty-offense-count",
+		"",
+		"how empty offense counts are written (one of null or zero)",
+	)
+	rootCmd.PersistentFlags().String(
+		"empty-strings",
+		"",
+		"how empty text fields are written (one of keep or null)",
+	)
+	rootCmd.PersistentFlags().String("charset", "", "connection and table charset")
+	rootCmd.PersistentFlags().String(
+		"collation",
+		"",
+		"connection and table collation (defaults to the charset's default collation)",
+	)
+	rootCmd.PersistentFlags().String(
+		"replica-dsn",
+		"",
+		"MySQL DSN of a read replica for queries (reads from the primary if empty)",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"union-reads",
+		false,
+		"allow queries of both blue/green tables combined with query --both-tables",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"source-url-column",
+		false,
+		"add a column recording the url each record was downloaded from",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"ingested-at-column",
+		false,
+		"add a column recording when each record was written",
+	)
+	rootCmd.PersistentFlags().String(
+		"ingested-at-clock",
+		"service",
+		"clock setting the ingested-at column (one of service or database)",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"recreate-missing-tables",
+		false,
+		"recreate a blue/green table dropped while the service runs instead of failing the update",
+	)
+	rootCmd.PersistentFlags().String(
+		"isolation-level",
+		"",
+		"write transaction isolation level (e.g. read-committed or repeatable-read)",
+	)
 	rootCmd.PersistentFlags().String("interval", "", "check interval")
+	rootCmd.PersistentFlags().String(
+		"cron",
+		"",
+		"cron expression scheduling updates instead of --interval, e.g. \"0 2 * * *\"",
+	)
+	rootCmd.PersistentFlags().String(
+		"cron-timezone",
+		"",
+		"time zone the --cron expression is evaluated in (defaults to the local time zone)",
+	)
 	rootCmd.PersistentFlags().StringArray("csv", []string{}, "CSV URLs")
+	rootCmd.PersistentFlags().String("csv-file", "", "file of newline-delimited CSV URLs")
 	rootCmd.PersistentFlags().String("blue-table", "", "blue table name")
 	rootCmd.PersistentFlags().String("green-table", "", "green table name")
+	rootCmd.PersistentFlags().String("metadata-table", "", "metadata table name")
+	rootCmd.PersistentFlags().Int(
+		"archive-retention",
+		0,
+		"number of previous datasets to keep in dated archive tables (0 disables archival)",
+	)
+	rootCmd.PersistentFlags().String("archive-prefix", "", "name prefix for archive tables")
+	rootCmd.PersistentFlags().String(
+		"snapshot-sink",
+		"",
+		"directory or s3://bucket/prefix to export archived datasets to as gzipped CSV files",
+	)
+	rootCmd.PersistentFlags().String(
+		"max-runtime",
+		"",
+		"maximum time to run before exiting (0 runs forever)",
+	)
+	rootCmd.PersistentFlags().String("initial-delay", "", "time to wait before the first update")
+	rootCmd.PersistentFlags().String(
+		"shutdown-timeout",
+		"",
+		"time an update in progress at shutdown may take to finish before it is cancelled",
+	)
+	rootCmd.PersistentFlags().String(
+		"max-age",
+		"",
+		"warn at startup if the active table is older than this (0 disables the check)",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"update-when-stale",
+		false,
+		"update immediately at startup if the active table is older than --max-age",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"update-on-start",
+		true,
+		"update immediately on start instead of after the first interval",
+	)
+	rootCmd.PersistentFlags().String(
+		"write-mode",
+		"",
+		"how records are written (one of insert or load-data)",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"intern-strings",
+		false,
+		"share memory between repeated string values while parsing",
+	)
+	rootCmd.PersistentFlags().Int(
+		"parse-workers",
+		0,
+		"goroutines parsing each csv (0 or 1 parses serially)",
+	)
+	rootCmd.PersistentFlags().Int("sample", 0, "only process the first N rows of each csv")
+	rootCmd.PersistentFlags().Bool(
+		"no-swap",
+		false,
+		"write the inactive table without making it active, for review before 'updater promote'",
+	)
+	rootCmd.PersistentFlags().String(
+		"swap-delay",
+		"",
+		"time to wait between writing the new table and making it active",
+	)
+	rootCmd.PersistentFlags().Int(
+		"limit-urls",
+		0,
+		"only process the first N csv urls, leaving the previous table active",
+	)
+	rootCmd.PersistentFlags().String(
+		"url-failure",
+		"",
+		"what a cycle does when a csv url fails to download (one of abort or skip)",
+	)
+	rootCmd.PersistentFlags().String(
+		"url-max-failure-age",
+		"",
+		"leave out a csv url that has been failing this long instead of aborting (0 never does)",
+	)
+	rootCmd.PersistentFlags().Int(
+		"log-sample-rows",
+		0,
+		"log the first N parsed records of each cycle at debug level",
+	)
+	rootCmd.PersistentFlags().StringArray(
+		"required-field",
+		[]string{},
+		"record field that must not be empty (repeatable)",
+	)
+	rootCmd.PersistentFlags().StringArray(
+		"neighborhood-filter",
+		[]string{},
+		"only store records in this neighborhood, ignoring case (repeatable)",
+	)
+	rootCmd.PersistentFlags().String(
+		"min-occur-date",
+		"",
+		"only store records that occurred on or after this date (YYYY-MM-DD) or age (e.g. 3y, 90d)",
+	)
+	rootCmd.PersistentFlags().Float64(
+		"max-parse-error-rate",
+		0,
+		"fail a cycle if more than this fraction of rows are parse errors (0 to 1)",
+	)
+	rootCmd.PersistentFlags().String(
+		"column-tolerance",
+		"",
+		"how rows with the wrong number of columns are handled (one of strict or tolerant)",
+	)
+	rootCmd.PersistentFlags().String("delimiter", "", "CSV field delimiter")
+	rootCmd.PersistentFlags().Bool("header", true, "whether CSV files start with a header row")
+	rootCmd.PersistentFlags().String(
+		"encoding",
+		"",
+		"CSV character encoding (one of utf-8, latin1 or windows-1252)",
+	)
+	rootCmd.PersistentFlags().Int(
+		"min-expected-rows",
+		0,
+		"minimum rows a new table must hold before it is made active",
+	)
 	rootCmd.PersistentFlags().String("timeout", "", "HTTP timeout")
 	rootCmd.PersistentFlags().Int("retries", 0, "HTTP retries")
+	rootCmd.PersistentFlags().Int(
+		"network-retries",
+		0,
+		"HTTP retries for DNS failures and refused connections",
+	)
+	rootCmd.PersistentFlags().Int(
+		"retry-budget",
+		0,
+		"maximum HTTP retries across all downloads in an update cycle (0 for no limit)",
+	)
+	rootCmd.PersistentFlags().Int("max-idle-conns", 0, "maximum idle HTTP connections kept open")
+	rootCmd.PersistentFlags().Int(
+		"max-idle-conns-per-host",
+		0,
+		"maximum idle HTTP connections kept open per host",
+	)
+	rootCmd.PersistentFlags().String(
+		"idle-conn-timeout",
+		"",
+		"how long an idle HTTP connection is kept open",
+	)
+	rootCmd.PersistentFlags().String("keep-alive", "", "TCP keep-alive period for HTTP connections")
+	rootCmd.PersistentFlags().Bool(
+		"disable-keep-alives",
+		false,
+		"open a new HTTP connection for every request",
+	)
+	rootCmd.PersistentFlags().String(
+		"max-retry-after",
+		"",
+		"longest wait honored from a Retry-After header on a 429 or 503 response (0 ignores it)",
+	)
+	rootCmd.PersistentFlags().String(
+		"min-tls-version",
+		"",
+		"lowest TLS version accepted by downloads (one of 1.2 or 1.3)",
+	)
+	rootCmd.PersistentFlags().Bool(
+		"http-trace",
+		false,
+		"log DNS, connect, TLS, first byte and transfer times of each download at debug level",
+	)
 	rootCmd.PersistentFlags().String(
 		"log-level",
 		"",
@@ -75,9 +397,149 @@ func init() {
 		"",
 		"log format (one of json or text)",
 	)
+	rootCmd.PersistentFlags().String(
+		"server-addr",
+		"",
+		"address for the http server exposing /healthz, /metrics and /events (disabled if empty)",
+	)
+	rootCmd.PersistentFlags().String(
+		"reload-secret",
+		"",
+		"shared secret required by POST /reload (disabled if empty)",
+	)
+	rootCmd.PersistentFlags().Bool("profile", false, "serve pprof profiling endpoints")
+	rootCmd.PersistentFlags().String(
+		"profile-addr",
+		"",
+		"address for the pprof server (defaults to localhost:6060)",
+	)
+
+	testConnectionCmd.Flags().DurationVar(
+		&connectTimeout,
+		"connect-timeout",
+		10*time.Second,
+		"time allowed to connect to the database",
+	)
+	queryCmd.Flags().IntVar(&queryLimit, "limit", 100, "maximum number of records to print")
+	queryCmd.Flags().StringSliceVar(
+		&queryColumns,
+		"columns",
+		nil,
+		"comma-separated Record fields to select (defaults to every column)",
+	)
+	queryCmd.Flags().StringVar(
+		&querySince,
+		"since",
+		"",
+		"only print records that occurred at or after this date or time",
+	)
+	queryCmd.Flags().StringVar(
+		&queryUntil,
+		"until",
+		"",
+		"only print records that occurred before this date or time",
+	)
+	queryCmd.Flags().StringArrayVar(
+		&queryNeighborhoods,
+		"neighborhood",
+		nil,
+		"only print records in this neighborhood (repeat for several)",
+	)
+	queryCmd.Flags().StringArrayVar(
+		&queryCategories,
+		"category",
+		nil,
+		"only print records of this offense category (repeat for several)",
+	)
+	queryCmd.Flags().BoolVar(
+		&queryBothTables,
+		"both-tables",
+		false,
+		"print records from both tables, without duplicates (requires --union-reads)",
+	)
+	for _, readCmd := range []*cobra.Command{queryCmd, statusCmd, countCmd} {
+		readCmd.Flags().StringVar(
+			&readFormat,
+			"format",
+			"",
+			"output format (one of table, json or csv; default table on a terminal, else json)",
+		)
+	}
+	inspectCSVCmd.Flags().IntVar(&inspectRows, "rows", 5, "number of sample rows to print")
+	promoteCmd.Flags().StringVar(
+		&promoteTable,
+		"table",
+		"",
+		"blue/green table to make active instead of the staged one",
+	)
+	initCmd.Flags().BoolVar(&initForce, "force", false, "overwrite an existing config file")
+}
+
+// loadConfig binds the command's flags to Viper, decodes the configuration, and replaces the
+// default logger with one built from the logging configuration. With --strict-config, unknown
+// configuration keys are a fatal error.
+func loadConfig(cmd *cobra.Command) error {
+	cfg.BindAllFlags(cmd)
+
+	if strictConfig {
+		if unknown := cfg.UnknownKeys(); len(unknown) > 0 {
+			return fail(
+				"unknown_config_keys",
+				"configuration contains unknown keys",
+				nil,
+				map[string]any{"keys": unknown},
+			)
+		}
+	}
+
+	if err := viper.Unmarshal(&config); err != nil {
+		return fail("config_decode_failed", "unable to decode into struct", err, nil)
+	}
+
+	appLogger, err := config.MakeLogger(logLevel)
+	if err != nil {
+		config.Logger.Level = "info"
+		config.Logger.Format = "text"
+		logger.Error(
+			"unable to create application logger, using default logging configuration",
+			"error",
+			err,
+		)
+	}
+
+	if appLogger != nil {
+		logger = appLogger
+	}
+
+	return nil
+}
+
+// validateConfig validates the decoded configuration, including the settings that refer to Record
+// fields.
+func validateConfig() error {
+	return errors.Join(config.Validate(), updater.ValidateConfig(&config))
 }
 
-// initViper runs the Viper initialization function from the config package.
-func initViper() {
-	cfg.InitConfig(cfgFile, logger)
+// initViper loads the env file, if there is one, and then runs the Viper initialization function
+// from the config package.
+//
+// Variables from the env file are set in the process environment, so they take precedence over the
+// config file but not over flags. Variables already set in the environment are not overwritten.
+func initViper() error {
+	if err := godotenv.Load(envFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fail("env_file_read_failed", "error reading env file", err, map[string]any{
+			"file": envFile,
+		})
+	}
+
+	if err := cfg.InitConfig(cfgFile); err != nil {
+		return fail(
+			"config_read_failed",
+			"error reading config file",
+			err,
+			map[string]any{"file": cfgFile},
+		)
+	}
+
+	return nil
 }
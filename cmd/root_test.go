@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestExecuteUsage(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		wantCode  string
+		wantUsage bool
+	}{
+		{
+			name:      "unexpected argument",
+			args:      []string{"launch", "now"},
+			wantUsage: true,
+		},
+		{
+			name:      "unknown flag",
+			args:      []string{"launch", "--no-such-flag"},
+			wantUsage: true,
+		},
+		{
+			name: "usage error with json output",
+			args: []string{"--output=json", "launch", "now"},
+		},
+		{
+			name:     "invalid config",
+			args:     []string{"launch", "--interval=soon"},
+			wantCode: "invalid_config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// An empty directory, so there is neither a config file nor an env file to read.
+			t.Chdir(t.TempDir())
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			t.Cleanup(func() { outputFormat = "text" })
+
+			var stderr bytes.Buffer
+			rootCmd.SetErr(&stderr)
+			rootCmd.SetArgs(tt.args)
+			t.Cleanup(func() {
+				rootCmd.SetErr(nil)
+				rootCmd.SetArgs(nil)
+			})
+
+			err := Execute()
+			if err == nil {
+				t.Fatal("Execute returned no error")
+			}
+
+			var failure *commandError
+			if errors.As(err, &failure) != (tt.wantCode != "") {
+				t.Fatalf("Execute() = %v, want a command error: %t", err, tt.wantCode != "")
+			}
+			if failure != nil && failure.Code != tt.wantCode {
+				t.Errorf("error code = %s, want %s", failure.Code, tt.wantCode)
+			}
+
+			if usage := strings.Contains(stderr.String(), "Usage:"); usage != tt.wantUsage {
+				t.Errorf("usage printed = %t, want %t:\n%s", usage, tt.wantUsage, stderr.String())
+			}
+		})
+	}
+}
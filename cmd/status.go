@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/lorendsnow/updater/internal/server"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents a command to report the health of a running updater service.
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status of the running updater service",
+	Long: `Query the /healthz endpoint of a running updater service, reporting the active
+table, when it was last updated, and the error from the most recent update cycle
+if it failed, in the --format of the query command. Exits with a non-zero status
+if the service is unreachable or its last update failed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+
+		format, err := resolveReadFormat()
+		if err != nil {
+			return fail("invalid_format", "invalid --format", err, nil)
+		}
+
+		if config.Server.Address == "" {
+			return fail("invalid_config", "server.address is not configured", nil, nil)
+		}
+
+		url := "http://" + dialAddress(config.Server.Address) + "/healthz"
+		client := &http.Client{Timeout: 10 * time.Second}
+
+		resp, err := client.Get(url)
+		if err != nil {
+			details := map[string]any{"url": url}
+			return fail("service_unreachable", "unable to reach updater service", err, details)
+		}
+		defer resp.Body.Close()
+
+		var health server.Health
+		if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+			return fail("invalid_response", "unable to decode health response", err, map[string]any{
+				"url": url,
+			})
+		}
+
+		var lastError any
+		if health.LastError != nil {
+			lastError = health.LastError.Message
+		}
+
+		err = renderRows(
+			format,
+			[]string{"status", "active_table", "last_updated", "last_error"},
+			[][]any{{health.Status, health.ActiveTable, health.LastUpdated, lastError}},
+		)
+		if err != nil {
+			return fail("output_failed", "unable to write status", err, nil)
+		}
+
+		if health.LastError != nil {
+			return fail("last_update_failed", "last update cycle failed", nil, nil)
+		}
+
+		return nil
+	},
+}
+
+// dialAddress returns addr with an empty host replaced by localhost, so a server listening on all
+// interfaces (e.g. ":8080") can be reached locally.
+func dialAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+
+	return net.JoinHostPort("localhost", port)
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/lorendsnow/updater/internal/updater"
+	"github.com/spf13/cobra"
+)
+
+// connectTimeout is the time allowed for test-connection to connect and query the server version.
+var connectTimeout time.Duration
+
+// testConnectionCmd represents a command to check that the database is reachable with the
+// configured credentials, without running any updates.
+var testConnectionCmd = &cobra.Command{
+	Use:   "test-connection",
+	Short: "Test the database connection",
+	Long: `Connect to the configured MySQL database and report the server version, without
+running any updates. Exits with a non-zero status if the connection fails.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+
+		details := map[string]any{
+			"host": config.Database.Host,
+			"port": config.Database.Port,
+			"name": config.Database.Name,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		defer cancel()
+
+		db, err := updater.OpenDatabase(ctx, &config, logger)
+		if err != nil {
+			return fail("connection_failed", "unable to connect to database", err, details)
+		}
+		defer db.Close()
+
+		var version string
+		if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+			message := "connected to database, but version query failed"
+			return fail("query_failed", message, err, details)
+		}
+
+		details["version"] = version
+		succeed("successfully connected to database", details)
+
+		return nil
+	},
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// validateConfigCmd represents a command to check the configuration without launching the service.
+var validateConfigCmd = &cobra.Command{
+	Use:   "validate-config",
+	Short: "Validate the updater configuration",
+	Long: `Validate the updater configuration from the config file, environment and flags,
+reporting every problem found without connecting to the database or launching
+the service.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+
+		if err := validateConfig(); err != nil {
+			return fail("invalid_config", "configuration is invalid", err, nil)
+		}
+
+		succeed("configuration is valid", nil)
+
+		return nil
+	},
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lorendsnow/updater/internal/updater"
+	"github.com/spf13/cobra"
+)
+
+// watchCmd represents a command to print the events of a running updater service as they happen.
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Print the events of the running updater service as they happen",
+	Long: `Connect to the /events endpoint of a running updater service and print each
+active table change and update cycle outcome as it happens, starting with the
+most recent table change, for live monitoring during a deployment. Events are
+printed one per line, as JSON objects when --output is json or stdout isn't a
+terminal.
+
+Runs until interrupted with Ctrl-C, or until the service stops.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+
+		if config.Server.Address == "" {
+			return fail("invalid_config", "server.address is not configured", nil, nil)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		url := "http://" + dialAddress(config.Server.Address) + "/events"
+		details := map[string]any{"url": url}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fail("invalid_config", "invalid server address", err, details)
+		}
+
+		// No timeout, since the stream stays open for as long as the command runs.
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fail("service_unreachable", "unable to reach updater service", err, details)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			err := fmt.Errorf("unexpected status: %s", resp.Status)
+			return fail("service_unreachable", "unable to watch updater service", err, details)
+		}
+
+		asJSON := jsonOutput() || !stdoutIsTerminal()
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var event updater.TableChangeEvent
+			err := decoder.Decode(&event)
+			switch {
+			case ctx.Err() != nil:
+				return nil
+			case errors.Is(err, io.EOF):
+				logger.Info("updater service closed the event stream")
+				return nil
+			case err != nil:
+				return fail("invalid_response", "unable to decode event", err, details)
+			}
+
+			if err := printEvent(event, asJSON); err != nil {
+				return fail("output_failed", "unable to write event", err, nil)
+			}
+		}
+	},
+}
+
+// printEvent writes event to stdout on a line of its own, as a JSON object or as the time it was
+// received, its type, and the details of that type of event.
+func printEvent(event updater.TableChangeEvent, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(os.Stdout).Encode(event)
+	}
+
+	var summary string
+	switch {
+	case event.Type == updater.EVENT_TABLE_CHANGED:
+		summary = fmt.Sprintf(
+			"table=%s records=%d updated_at=%s",
+			event.Table,
+			event.Records,
+			formatValue(event.UpdatedAt),
+		)
+	case event.Stats != nil:
+		summary = fmt.Sprintf(
+			"table=%s records=%d skipped=%d partial=%t staged=%t",
+			event.Stats.Table,
+			event.Stats.Records,
+			event.Stats.Skipped,
+			event.Stats.Partial,
+			event.Stats.Staged,
+		)
+	case event.Error != "":
+		summary = "error=" + strings.ReplaceAll(event.Error, "\n", "; ")
+	}
+
+	_, err := fmt.Printf("%s  %-26s %s\n", time.Now().Format(time.DateTime), event.Type, summary)
+
+	return err
+}
@@ -0,0 +1,33 @@
+// Package backoff provides the exponential backoff used when retrying failed downloads and
+// database connections.
+package backoff
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Delay returns how long to wait before retry number attempt (counting from zero), using
+// exponential backoff with full jitter: a random duration between zero and base * 2^attempt,
+// capped at max.
+//
+// Full jitter spreads retries from many clients evenly over the window instead of having them all
+// retry at the same moments.
+func Delay(attempt int, base time.Duration, max time.Duration) time.Duration {
+	if base <= 0 || max <= 0 {
+		return 0
+	}
+
+	ceiling := max
+	if attempt < 0 {
+		attempt = 0
+	}
+	if attempt < 63 {
+		// Stop doubling once the ceiling passes max, which also avoids overflow.
+		if exp := base << attempt; exp>>attempt == base && exp < max {
+			ceiling = exp
+		}
+	}
+
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
@@ -0,0 +1,64 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+// samples is how many delays are drawn for each case. The largest of them falls in the top half
+// of the window with near certainty, so the tests also catch a window that is too small.
+const samples = 1000
+
+func TestDelay(t *testing.T) {
+	const second, minute = time.Second, time.Minute
+
+	tests := []struct {
+		name    string
+		attempt int
+		base    time.Duration
+		max     time.Duration
+		// ceiling is the largest delay allowed.
+		ceiling time.Duration
+	}{
+		{name: "first attempt", attempt: 0, base: second, max: minute, ceiling: second},
+		{name: "doubles", attempt: 1, base: second, max: minute, ceiling: 2 * second},
+		{name: "grows", attempt: 4, base: second, max: minute, ceiling: 16 * second},
+		{name: "capped", attempt: 6, base: second, max: minute, ceiling: minute},
+		{name: "overflow", attempt: 62, base: second, max: minute, ceiling: minute},
+		{name: "huge attempt", attempt: 1000, base: second, max: minute, ceiling: minute},
+		{name: "negative attempt", attempt: -1, base: second, max: minute, ceiling: second},
+		{name: "base over max", attempt: 0, base: 60 * minute, max: minute, ceiling: minute},
+		{name: "zero base", attempt: 3, base: 0, max: minute, ceiling: 0},
+		{name: "zero max", attempt: 3, base: second, max: 0, ceiling: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var largest time.Duration
+			for range samples {
+				delay := Delay(tt.attempt, tt.base, tt.max)
+				if delay < 0 || delay > tt.ceiling {
+					t.Fatalf("Delay = %v, want between 0 and %v", delay, tt.ceiling)
+				}
+				largest = max(largest, delay)
+			}
+
+			if largest < tt.ceiling/2 {
+				t.Errorf("largest of %d delays = %v, want near %v", samples, largest, tt.ceiling)
+			}
+		})
+	}
+}
+
+func TestDelayNeverExceedsMax(t *testing.T) {
+	base := 100 * time.Millisecond
+	maxDelay := 30 * time.Second
+
+	for attempt := range 100 {
+		for range 100 {
+			if delay := Delay(attempt, base, maxDelay); delay > maxDelay {
+				t.Fatalf("Delay(%d) = %v, more than max %v", attempt, delay, maxDelay)
+			}
+		}
+	}
+}
@@ -2,11 +2,25 @@
 package config
 
 import (
+	"crypto/tls"
+	"database/sql"
 	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
+	"net/url"
 	"os"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lorendsnow/updater/internal/s3"
+	"github.com/robfig/cron/v3"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -18,6 +32,23 @@ import (
  *==================================================================================================
  */
 
+// PASSWORD_ENV sets the database password from the environment, taking precedence over both
+// database.password-file and database.password.
+const PASSWORD_ENV = "UPDATER_DATABASE_PASSWORD"
+
+// DEFAULT_CHECK_INTERVAL is used, with a warning, when service.check-interval is blank, so a
+// deployment missing the setting still updates daily rather than never.
+const DEFAULT_CHECK_INTERVAL = "24h"
+
+// MAX_TABLE_NAME_LENGTH is the longest table name MySQL accepts.
+const MAX_TABLE_NAME_LENGTH = 64
+
+// REDACTED replaces secrets in logged values.
+const REDACTED = "****"
+
+// SECRET_KEYS lists the settings whose values are secrets, redacted by LogValue.
+var SECRET_KEYS = []string{"database.password", "database.replica-dsn", "server.reload-secret"}
+
 // Config holds configuration values for the updater service.
 type Config struct {
 	Database struct {
@@ -26,53 +57,709 @@ type Config struct {
 		Username string `mapstructure:"username"`
 		Password string `mapstructure:"password"`
 		Name     string `mapstructure:"name"`
+
+		// PasswordFile holds the password, for example a mounted secret, and takes precedence over
+		// Password. See DatabasePassword.
+		PasswordFile string `mapstructure:"password-file"`
+
+		ConnectRetries int    `mapstructure:"connect-retries"`
+		ConnectMaxWait string `mapstructure:"connect-max-wait"`
+
+		QueryTimeout       string `mapstructure:"query-timeout"`
+		SlowQueryThreshold string `mapstructure:"slow-query-threshold"`
+		ReadOnlyReads      bool   `mapstructure:"read-only-reads"`
+		NullSentinelDates  bool   `mapstructure:"null-sentinel-dates"`
+
+		// LogQueries logs every SQL statement with its number of arguments at debug level.
+		LogQueries bool `mapstructure:"log-queries"`
+
+		Indexes        []string       `mapstructure:"indexes"`
+		IsolationLevel string         `mapstructure:"isolation-level"`
+		Columns        []ColumnConfig `mapstructure:"columns"`
+
+		// SourceURLColumn adds a column recording the url each record was downloaded from.
+		SourceURLColumn bool `mapstructure:"source-url-column"`
+
+		// IngestedAtColumn adds a column recording when each record was written, by the service's
+		// clock, or by the database's with IngestedAtClock "database".
+		IngestedAtColumn bool   `mapstructure:"ingested-at-column"`
+		IngestedAtClock  string `mapstructure:"ingested-at-clock"`
+
+		RecreateMissingTables bool `mapstructure:"recreate-missing-tables"`
+
+		EmptyOffenseCount string `mapstructure:"empty-offense-count"`
+		EmptyStrings      string `mapstructure:"empty-strings"`
+
+		// Charset and Collation are used for the connection and for the tables the service
+		// creates. An empty Collation uses the charset's default collation.
+		Charset   string `mapstructure:"charset"`
+		Collation string `mapstructure:"collation"`
+
+		// ReplicaDSN is the MySQL DSN of a read replica for the Repository to read from, while
+		// the service keeps writing to the primary. Blank reads from the primary.
+		ReplicaDSN string `mapstructure:"replica-dsn"`
+
+		// UnionReads allows reading the blue and green tables combined, for when a swap is in
+		// doubt. It's off by default, since those reads cost far more than reading the active
+		// table.
+		UnionReads bool `mapstructure:"union-reads"`
 	} `mapstructure:"database"`
 
 	Service struct {
-		CheckInterval string   `mapstructure:"check-interval"`
+		CheckInterval string `mapstructure:"check-interval"`
+
+		// Cron schedules updates with a standard five-field cron expression, such as "0 2 * * *",
+		// instead of every CheckInterval. The two are mutually exclusive.
+		Cron string `mapstructure:"cron"`
+
+		// CronTimezone is the IANA time zone Cron is evaluated in, such as "America/Los_Angeles".
+		// Blank uses the service's local time zone. A time skipped when clocks spring forward
+		// doesn't run that day.
+		CronTimezone string `mapstructure:"cron-timezone"`
+
 		CSVUrls       []string `mapstructure:"csv-urls"`
+		CSVUrlsFile   string   `mapstructure:"csv-urls-file"`
 		BlueTable     string   `mapstructure:"blue-table"`
 		GreenTable    string   `mapstructure:"green-table"`
+		MetadataTable string   `mapstructure:"metadata-table"`
+
+		ArchiveRetention int    `mapstructure:"archive-retention"`
+		ArchivePrefix    string `mapstructure:"archive-prefix"`
+		SnapshotSink     string `mapstructure:"snapshot-sink"`
+
+		MaxRuntime      string `mapstructure:"max-runtime"`
+		InitialDelay    string `mapstructure:"initial-delay"`
+		ShutdownTimeout string `mapstructure:"shutdown-timeout"`
+		UpdateOnStart   bool   `mapstructure:"update-on-start"`
+
+		MaxAge          string `mapstructure:"max-age"`
+		UpdateWhenStale bool   `mapstructure:"update-when-stale"`
+
+		WriteMode     string `mapstructure:"write-mode"`
+		InternStrings bool   `mapstructure:"intern-strings"`
+		ParseWorkers  int    `mapstructure:"parse-workers"`
+		SampleRows    int    `mapstructure:"sample-rows"`
+		LogSampleRows int    `mapstructure:"log-sample-rows"`
+		LimitURLs     int    `mapstructure:"limit-urls"`
+		NoSwap        bool   `mapstructure:"no-swap"`
+		SwapDelay     string `mapstructure:"swap-delay"`
+
+		// URLMaxFailureAge is how long a csv url may keep failing before cycles with URLFailure
+		// "abort" leave it out instead. Blank or zero never leaves it out.
+		URLFailure       string `mapstructure:"url-failure"`
+		URLMaxFailureAge string `mapstructure:"url-max-failure-age"`
+
+		RequiredFields    []string `mapstructure:"required-fields"`
+		MaxParseErrorRate float64  `mapstructure:"max-parse-error-rate"`
+		ColumnTolerance   string   `mapstructure:"column-tolerance"`
+
+		// NeighborhoodFilter lists the neighborhoods to keep, matched ignoring case. Records in
+		// any other neighborhood are dropped while parsing. Empty keeps every neighborhood.
+		NeighborhoodFilter []string `mapstructure:"neighborhood-filter"`
+
+		// MinOccurDate drops records that occurred before it while parsing: either a date such
+		// as 2022-01-01, or an age such as 90d or 3y counted back from each update. Blank keeps
+		// records of every date.
+		MinOccurDate string `mapstructure:"min-occur-date"`
+
+		// The CSV format of every source, unless a source overrides it.
+		Delimiter string `mapstructure:"delimiter"`
+		Header    bool   `mapstructure:"header"`
+		Encoding  string `mapstructure:"encoding"`
+
+		MinExpectedRows int           `mapstructure:"min-expected-rows"`
+		Checks          []CheckConfig `mapstructure:"checks"`
+
+		Sources []SourceConfig `mapstructure:"sources"`
 	} `mapstructure:"service"`
 
 	HTTP struct {
 		Timeout string `mapstructure:"timeout"`
 		Retries int    `mapstructure:"retries"`
+
+		NetworkRetries int `mapstructure:"network-retries"`
+
+		// RetryBudget caps the retries of all downloads in a cycle combined. Zero is unlimited.
+		RetryBudget int `mapstructure:"retry-budget"`
+
+		// MaxRetryAfter caps how long a retry waits for a Retry-After header on a 429 or 503
+		// response. Zero ignores the header.
+		MaxRetryAfter string `mapstructure:"max-retry-after"`
+
+		// Connection reuse. Zero values fall back to the defaults set in InitConfig.
+		MaxIdleConns        int    `mapstructure:"max-idle-conns"`
+		MaxIdleConnsPerHost int    `mapstructure:"max-idle-conns-per-host"`
+		IdleConnTimeout     string `mapstructure:"idle-conn-timeout"`
+		KeepAlive           string `mapstructure:"keep-alive"`
+		DisableKeepAlives   bool   `mapstructure:"disable-keep-alives"`
+
+		// MinTLSVersion is the lowest TLS version downloads accept, "1.2" or "1.3".
+		MinTLSVersion string `mapstructure:"min-tls-version"`
+
+		// Trace logs the timing of each phase of every download at debug level.
+		Trace bool `mapstructure:"trace"`
 	} `mapstructure:"http"`
 
 	Logger struct {
 		Level  string `mapstructure:"level"`
 		Format string `mapstructure:"format"`
 	} `mapstructure:"logger"`
+
+	Server struct {
+		Address      string `mapstructure:"address"`
+		ReloadSecret string `mapstructure:"reload-secret"`
+	} `mapstructure:"server"`
+
+	Profile struct {
+		Enabled bool   `mapstructure:"enabled"`
+		Address string `mapstructure:"address"`
+	} `mapstructure:"profile"`
+}
+
+// ColumnConfig overrides the definition of one blue/green table column. Field names the Record
+// field stored in the column; the other settings default to the field's standard definition.
+type ColumnConfig struct {
+	Name     string `mapstructure:"name"`
+	Field    string `mapstructure:"field"`
+	Type     string `mapstructure:"type"`
+	Nullable *bool  `mapstructure:"nullable"`
+}
+
+// SourceConfig is a CSV source with settings overriding the global ones for that url. Sources are
+// downloaded along with the csv-urls; settings left empty fall back to the global values.
+//
+// Type is "csv", the default, for a single file, or "paginated" for an API serving the data in
+// pages of PageSize rows. The url of a paginated source is a template in which {page}, {offset}
+// and {limit} are replaced by the zero-based page number, the index of its first row, and PageSize.
+//
+// Delimiter, Header and Encoding override the global CSV format for feeds published differently.
+// Header is a pointer so a source can turn off the header row the global setting expects.
+type SourceConfig struct {
+	URL      string `mapstructure:"url"`
+	Timeout  string `mapstructure:"timeout"`
+	Type     string `mapstructure:"type"`
+	PageSize int    `mapstructure:"page-size"`
+
+	Delimiter string `mapstructure:"delimiter"`
+	Header    *bool  `mapstructure:"header"`
+	Encoding  string `mapstructure:"encoding"`
+
+	// Format is "csv", the default, or "jsonl" for newline-delimited JSON with one object per
+	// record, keyed by the CSV header names.
+	Format string `mapstructure:"format"`
+}
+
+// ENCODINGS lists the supported values of service.encoding.
+var ENCODINGS = []string{"utf-8", "latin1", "windows-1252"}
+
+// CheckConfig is a SQL assertion run against a freshly written table before it is made active.
+// Query must return a single number, and may refer to the table as {table}. A nil Min or Max
+// leaves that side of the check unbounded.
+type CheckConfig struct {
+	Name  string   `mapstructure:"name"`
+	Query string   `mapstructure:"query"`
+	Min   *float64 `mapstructure:"min"`
+	Max   *float64 `mapstructure:"max"`
+}
+
+// isSQLName reports whether name is a non-empty string of letters, digits and underscores, as
+// charset and collation names are, so it can be used in SQL statements unquoted.
+func isSQLName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		if r != '_' && (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateDelimiter checks the delimiter setting of the section named by prefix, which must be a
+// single character that can separate CSV fields.
+func validateDelimiter(prefix, delimiter string) error {
+	r, size := utf8.DecodeRuneInString(delimiter)
+	if size == 0 || size != len(delimiter) || r == utf8.RuneError ||
+		r == '"' || r == '\r' || r == '\n' {
+		return fmt.Errorf(
+			"%s.delimiter must be a single character other than a quote or line break",
+			prefix,
+		)
+	}
+
+	return nil
+}
+
+// validateEncoding checks the encoding setting of the section named by prefix.
+func validateEncoding(prefix, encoding string) error {
+	if !slices.Contains(ENCODINGS, strings.ToLower(encoding)) {
+		return fmt.Errorf("%s.encoding must be one of %s", prefix, strings.Join(ENCODINGS, ", "))
+	}
+
+	return nil
 }
 
 // MakeLogger creates a new slog logger based on the set configuration.
-func (c *Config) MakeLogger() (*slog.Logger, error) {
-	var slogLevel slog.Level
+//
+// The logger's level is read from level, which is set to the configured level, so the level can
+// be changed later without rebuilding the logger.
+func (c *Config) MakeLogger(level *slog.LevelVar) (*slog.Logger, error) {
+	level.Set(c.LogLevel())
+
+	var handler slog.Handler
+	switch strings.ToLower(c.Logger.Format) {
+	case "text":
+		handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	default:
+		return nil, errors.New("invalid log format, must be 'text' or 'json'")
+	}
+
+	return slog.New(handler), nil
+}
+
+// LogLevel returns the slog level for the configured log level, defaulting to info.
+func (c *Config) LogLevel() slog.Level {
 	switch strings.ToLower(c.Logger.Level) {
 	case "debug":
-		slogLevel = slog.LevelDebug
+		return slog.LevelDebug
 	case "info":
-		slogLevel = slog.LevelInfo
+		return slog.LevelInfo
 	case "warn":
-		slogLevel = slog.LevelWarn
+		return slog.LevelWarn
 	case "error":
-		slogLevel = slog.LevelError
+		return slog.LevelError
 	default:
-		slogLevel = slog.LevelInfo
+		return slog.LevelInfo
+	}
+}
+
+// LogValue logs every setting, grouped by section as in the config file and keyed by setting name,
+// so the effective configuration can be attached to a bug report. The settings in SECRET_KEYS are
+// replaced with REDACTED, and any password in a url is masked.
+func (c Config) LogValue() slog.Value {
+	return structLogValue(reflect.ValueOf(c), "")
+}
+
+// DatabasePassword returns the database password. PASSWORD_ENV takes precedence if it is set,
+// followed by the contents of PasswordFile with surrounding whitespace trimmed, and then the inline
+// Password.
+func (c *Config) DatabasePassword() (string, error) {
+	if password, ok := os.LookupEnv(PASSWORD_ENV); ok {
+		return password, nil
+	}
+
+	if c.Database.PasswordFile != "" {
+		data, err := os.ReadFile(c.Database.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("database.password-file could not be read: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	return c.Database.Password, nil
+}
+
+// TLSVersion returns the crypto/tls version constant for the configured minimum TLS version of
+// downloads. An empty setting is TLS 1.2.
+func (c *Config) TLSVersion() (uint16, error) {
+	switch strings.TrimSpace(c.HTTP.MinTLSVersion) {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q, must be 1.2 or 1.3", c.HTTP.MinTLSVersion)
+	}
+}
+
+// IsolationLevel returns the sql.IsolationLevel for the configured write transaction isolation
+// level. An empty setting uses the server's default isolation level.
+func (c *Config) IsolationLevel() (sql.IsolationLevel, error) {
+	normalized := strings.NewReplacer(" ", "-", "_", "-").Replace(
+		strings.ToLower(strings.TrimSpace(c.Database.IsolationLevel)),
+	)
+
+	switch normalized {
+	case "":
+		return sql.LevelDefault, nil
+	case "read-uncommitted":
+		return sql.LevelReadUncommitted, nil
+	case "read-committed":
+		return sql.LevelReadCommitted, nil
+	case "repeatable-read":
+		return sql.LevelRepeatableRead, nil
+	case "serializable":
+		return sql.LevelSerializable, nil
+	default:
+		return sql.LevelDefault, fmt.Errorf(
+			"unknown isolation level %q, must be one of read-uncommitted, read-committed, "+
+				"repeatable-read or serializable",
+			c.Database.IsolationLevel,
+		)
+	}
+}
+
+// AllCSVUrls returns the inline CSV urls and source urls followed by any urls listed in
+// CSVUrlsFile, with duplicates removed. The file holds one url per line; blank lines and lines
+// starting with '#' are ignored. Every url is validated, and the file is re-read on each call so
+// reloads pick up edits.
+func (c *Config) AllCSVUrls() ([]string, error) {
+	urls := slices.Clone(c.Service.CSVUrls)
+	for _, source := range c.Service.Sources {
+		urls = append(urls, source.URL)
+	}
+
+	if c.Service.CSVUrlsFile != "" {
+		data, err := os.ReadFile(c.Service.CSVUrlsFile)
+		if err != nil {
+			return nil, fmt.Errorf("service.csv-urls-file could not be read: %w", err)
+		}
+
+		for line := range strings.Lines(string(data)) {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			urls = append(urls, line)
+		}
+	}
+
+	var unique []string
+	for _, u := range urls {
+		if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("service.csv-urls contains an invalid url: %q", u)
+		}
+		if !slices.Contains(unique, u) {
+			unique = append(unique, u)
+		}
+	}
+
+	return unique, nil
+}
+
+// Validate checks that the configuration has everything the updater service needs to run,
+// returning every problem found joined into a single error.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Database.Host == "" {
+		errs = append(errs, errors.New("database.host is required"))
+	}
+	if c.Database.Port <= 0 {
+		errs = append(errs, errors.New("database.port must be greater than 0"))
+	}
+	if c.Database.Username == "" {
+		errs = append(errs, errors.New("database.username is required"))
+	}
+	if c.Database.Name == "" {
+		errs = append(errs, errors.New("database.name is required"))
+	}
+	if _, err := c.IsolationLevel(); err != nil {
+		errs = append(errs, fmt.Errorf("database.isolation-level is invalid: %w", err))
+	}
+	if c.Database.ReplicaDSN != "" {
+		if _, err := mysql.ParseDSN(c.Database.ReplicaDSN); err != nil {
+			errs = append(errs, fmt.Errorf("database.replica-dsn is invalid: %w", err))
+		}
+	}
+	if !isSQLName(c.Database.Charset) {
+		errs = append(errs, errors.New("database.charset must be a charset name such as utf8mb4"))
+	}
+	if c.Database.Collation != "" {
+		if !isSQLName(c.Database.Collation) {
+			errs = append(
+				errs,
+				errors.New("database.collation must be a collation name such as utf8mb4_bin"),
+			)
+		} else if !strings.HasPrefix(c.Database.Collation, c.Database.Charset+"_") {
+			errs = append(
+				errs,
+				fmt.Errorf("database.collation must be a collation of %s", c.Database.Charset),
+			)
+		}
+	}
+	if _, err := c.DatabasePassword(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Database.ConnectRetries < 0 {
+		errs = append(errs, errors.New("database.connect-retries must not be negative"))
+	}
+	if c.Database.ConnectMaxWait != "" {
+		if _, err := time.ParseDuration(c.Database.ConnectMaxWait); err != nil {
+			errs = append(errs, fmt.Errorf("database.connect-max-wait is invalid: %w", err))
+		}
+	}
+	switch c.Database.EmptyOffenseCount {
+	case "null", "zero":
+	default:
+		errs = append(errs, errors.New("database.empty-offense-count must be 'null' or 'zero'"))
+	}
+	switch c.Database.EmptyStrings {
+	case "keep", "null":
+	default:
+		errs = append(errs, errors.New("database.empty-strings must be 'keep' or 'null'"))
+	}
+	switch c.Database.IngestedAtClock {
+	case "service", "database":
+	default:
+		errs = append(
+			errs,
+			errors.New("database.ingested-at-clock must be 'service' or 'database'"),
+		)
+	}
+	if c.Database.QueryTimeout != "" {
+		if _, err := time.ParseDuration(c.Database.QueryTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("database.query-timeout is invalid: %w", err))
+		}
+	}
+	if c.Database.SlowQueryThreshold != "" {
+		if _, err := time.ParseDuration(c.Database.SlowQueryThreshold); err != nil {
+			errs = append(errs, fmt.Errorf("database.slow-query-threshold is invalid: %w", err))
+		}
+	}
+
+	if c.Service.CheckInterval != "" {
+		if _, err := time.ParseDuration(c.Service.CheckInterval); err != nil {
+			errs = append(errs, fmt.Errorf("service.check-interval is invalid: %w", err))
+		}
+	}
+	if c.Service.Cron != "" {
+		if _, err := cron.ParseStandard(c.Service.Cron); err != nil {
+			errs = append(errs, fmt.Errorf("service.cron is invalid: %w", err))
+		}
+		if c.Service.CheckInterval != "" {
+			errs = append(
+				errs,
+				errors.New("service.cron and service.check-interval are mutually exclusive"),
+			)
+		}
+	}
+	if c.Service.CronTimezone != "" {
+		if _, err := time.LoadLocation(c.Service.CronTimezone); err != nil {
+			errs = append(errs, fmt.Errorf("service.cron-timezone is invalid: %w", err))
+		}
+	}
+	if urls, err := c.AllCSVUrls(); err != nil {
+		errs = append(errs, err)
+	} else if len(urls) == 0 {
+		errs = append(errs, errors.New("service.csv-urls must contain at least one url"))
+	}
+	for i, source := range c.Service.Sources {
+		if source.URL == "" {
+			errs = append(errs, fmt.Errorf("service.sources[%d].url is required", i))
+		}
+		switch source.Type {
+		case "", "csv":
+		case "paginated":
+			if source.PageSize <= 0 {
+				errs = append(
+					errs,
+					fmt.Errorf("service.sources[%d].page-size must be greater than 0", i),
+				)
+			}
+			if !strings.Contains(source.URL, "{page}") &&
+				!strings.Contains(source.URL, "{offset}") {
+				errs = append(
+					errs,
+					fmt.Errorf("service.sources[%d].url must contain {page} or {offset}", i),
+				)
+			}
+		default:
+			errs = append(
+				errs,
+				fmt.Errorf("service.sources[%d].type must be 'csv' or 'paginated'", i),
+			)
+		}
+		if source.Timeout != "" {
+			if _, err := time.ParseDuration(source.Timeout); err != nil {
+				errs = append(
+					errs,
+					fmt.Errorf("service.sources[%d].timeout is invalid: %w", i, err),
+				)
+			}
+		}
+		prefix := fmt.Sprintf("service.sources[%d]", i)
+		switch source.Format {
+		case "", "csv", "jsonl":
+		default:
+			errs = append(errs, fmt.Errorf("%s.format must be 'csv' or 'jsonl'", prefix))
+		}
+		if source.Delimiter != "" {
+			errs = append(errs, validateDelimiter(prefix, source.Delimiter))
+		}
+		if source.Encoding != "" {
+			errs = append(errs, validateEncoding(prefix, source.Encoding))
+		}
+	}
+	errs = append(errs, validateDelimiter("service", c.Service.Delimiter))
+	errs = append(errs, validateEncoding("service", c.Service.Encoding))
+	if c.Service.BlueTable == "" || c.Service.GreenTable == "" {
+		errs = append(errs, errors.New("service.blue-table and service.green-table are required"))
+	} else if c.Service.BlueTable == c.Service.GreenTable {
+		errs = append(errs, errors.New("service.blue-table and service.green-table must differ"))
+	}
+	for _, table := range []struct{ setting, name string }{
+		{"blue-table", c.Service.BlueTable},
+		{"green-table", c.Service.GreenTable},
+		{"metadata-table", c.Service.MetadataTable},
+	} {
+		if len(table.name) > MAX_TABLE_NAME_LENGTH {
+			errs = append(errs, fmt.Errorf(
+				"service.%s must be at most %d characters",
+				table.setting,
+				MAX_TABLE_NAME_LENGTH,
+			))
+		}
+	}
+	if c.Service.MetadataTable == "" {
+		errs = append(errs, errors.New("service.metadata-table is required"))
+	} else if c.Service.MetadataTable == c.Service.BlueTable ||
+		c.Service.MetadataTable == c.Service.GreenTable {
+		errs = append(errs, errors.New("service.metadata-table must differ from the data tables"))
+	}
+	if c.Service.ArchiveRetention < 0 {
+		errs = append(errs, errors.New("service.archive-retention must not be negative"))
+	}
+	if c.Service.ArchiveRetention > 0 && c.Service.ArchivePrefix == "" {
+		errs = append(errs, errors.New("service.archive-prefix is required for archival"))
+	}
+	// Archive names append a 18 character timestamp, and MySQL table names are limited to 64.
+	if len(c.Service.ArchivePrefix) > 46 {
+		errs = append(errs, errors.New("service.archive-prefix must be at most 46 characters"))
+	}
+	if strings.HasPrefix(c.Service.SnapshotSink, s3.SCHEME+"://") {
+		if _, _, err := s3.ParseURL(c.Service.SnapshotSink); err != nil {
+			errs = append(errs, fmt.Errorf("service.snapshot-sink is invalid: %w", err))
+		}
+	}
+	if c.Service.MaxRuntime != "" {
+		if _, err := time.ParseDuration(c.Service.MaxRuntime); err != nil {
+			errs = append(errs, fmt.Errorf("service.max-runtime is invalid: %w", err))
+		}
+	}
+	if c.Service.InitialDelay != "" {
+		if _, err := time.ParseDuration(c.Service.InitialDelay); err != nil {
+			errs = append(errs, fmt.Errorf("service.initial-delay is invalid: %w", err))
+		}
+	}
+	if c.Service.ShutdownTimeout != "" {
+		if _, err := time.ParseDuration(c.Service.ShutdownTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("service.shutdown-timeout is invalid: %w", err))
+		}
+	}
+	if c.Service.URLMaxFailureAge != "" {
+		if _, err := time.ParseDuration(c.Service.URLMaxFailureAge); err != nil {
+			errs = append(errs, fmt.Errorf("service.url-max-failure-age is invalid: %w", err))
+		}
+	}
+	if c.Service.SwapDelay != "" {
+		if _, err := time.ParseDuration(c.Service.SwapDelay); err != nil {
+			errs = append(errs, fmt.Errorf("service.swap-delay is invalid: %w", err))
+		}
+	}
+	if c.Service.MaxAge != "" {
+		if _, err := time.ParseDuration(c.Service.MaxAge); err != nil {
+			errs = append(errs, fmt.Errorf("service.max-age is invalid: %w", err))
+		}
+	}
+	if c.Service.MaxParseErrorRate < 0 || c.Service.MaxParseErrorRate > 1 {
+		errs = append(errs, errors.New("service.max-parse-error-rate must be between 0 and 1"))
+	}
+	if c.Service.LogSampleRows < 0 {
+		errs = append(errs, errors.New("service.log-sample-rows must not be negative"))
+	}
+	if c.Service.MinExpectedRows < 0 {
+		errs = append(errs, errors.New("service.min-expected-rows must not be negative"))
+	}
+	for i, check := range c.Service.Checks {
+		if check.Name == "" || check.Query == "" {
+			errs = append(errs, fmt.Errorf("service.checks[%d] requires a name and a query", i))
+		}
+	}
+	if c.Service.ParseWorkers < 0 {
+		errs = append(errs, errors.New("service.parse-workers must not be negative"))
+	}
+	if c.Service.SampleRows < 0 {
+		errs = append(errs, errors.New("service.sample-rows must not be negative"))
+	}
+	if c.Service.LimitURLs < 0 {
+		errs = append(errs, errors.New("service.limit-urls must not be negative"))
+	}
+	switch c.Service.URLFailure {
+	case "abort", "skip":
+	default:
+		errs = append(errs, errors.New("service.url-failure must be 'abort' or 'skip'"))
+	}
+	switch c.Service.WriteMode {
+	case "insert", "load-data":
+	default:
+		errs = append(errs, errors.New("service.write-mode must be 'insert' or 'load-data'"))
+	}
+	switch c.Service.ColumnTolerance {
+	case "strict", "tolerant":
+	default:
+		errs = append(errs, errors.New("service.column-tolerance must be 'strict' or 'tolerant'"))
+	}
+	if slices.ContainsFunc(c.Service.NeighborhoodFilter, func(n string) bool {
+		return strings.TrimSpace(n) == ""
+	}) {
+		errs = append(errs, errors.New("service.neighborhood-filter must not list a blank name"))
+	}
+	if _, err := ParseMinOccurDate(c.Service.MinOccurDate, time.Now()); err != nil {
+		errs = append(errs, fmt.Errorf("service.min-occur-date is invalid: %w", err))
+	}
+
+	if _, err := time.ParseDuration(c.HTTP.Timeout); err != nil {
+		errs = append(errs, fmt.Errorf("http.timeout is invalid: %w", err))
+	}
+	if _, err := c.TLSVersion(); err != nil {
+		errs = append(errs, fmt.Errorf("http.min-tls-version is invalid: %w", err))
+	}
+	if c.HTTP.Retries < 0 {
+		errs = append(errs, errors.New("http.retries must not be negative"))
+	}
+	if c.HTTP.NetworkRetries < 0 {
+		errs = append(errs, errors.New("http.network-retries must not be negative"))
+	}
+	if c.HTTP.RetryBudget < 0 {
+		errs = append(errs, errors.New("http.retry-budget must not be negative"))
+	}
+	if c.HTTP.MaxRetryAfter != "" {
+		if d, err := time.ParseDuration(c.HTTP.MaxRetryAfter); err != nil {
+			errs = append(errs, fmt.Errorf("http.max-retry-after is invalid: %w", err))
+		} else if d < 0 {
+			errs = append(errs, errors.New("http.max-retry-after must not be negative"))
+		}
+	}
+	if c.HTTP.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("http.max-idle-conns must not be negative"))
+	}
+	if c.HTTP.MaxIdleConnsPerHost < 0 {
+		errs = append(errs, errors.New("http.max-idle-conns-per-host must not be negative"))
+	}
+	if c.HTTP.IdleConnTimeout != "" {
+		if _, err := time.ParseDuration(c.HTTP.IdleConnTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("http.idle-conn-timeout is invalid: %w", err))
+		}
+	}
+	if c.HTTP.KeepAlive != "" {
+		if _, err := time.ParseDuration(c.HTTP.KeepAlive); err != nil {
+			errs = append(errs, fmt.Errorf("http.keep-alive is invalid: %w", err))
+		}
 	}
 
-	var handler slog.Handler
 	switch strings.ToLower(c.Logger.Format) {
-	case "text":
-		handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slogLevel})
-	case "json":
-		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slogLevel})
+	case "text", "json":
 	default:
-		return nil, errors.New("invalid log format, must be 'text' or 'json'")
+		errs = append(errs, errors.New("logger.format must be 'text' or 'json'"))
 	}
 
-	return slog.New(handler), nil
+	return errors.Join(errs...)
 }
 
 /*
@@ -90,14 +777,79 @@ const (
 	User
 	Pass
 	Name
+	QueryTimeout
+	SlowQueryThreshold
+	ReadOnlyReads
+	NullSentinelDates
+	LogQueries
+	IsolationLevel
+	EmptyOffenseCount
+	EmptyStrings
+	Charset
+	Collation
+	ReplicaDSN
+	UnionReads
+	SourceURLColumn
+	IngestedAtColumn
+	IngestedAtClock
+	RecreateMissingTables
+	PassFile
+	ConnectRetries
+	ConnectMaxWait
 	Interval
+	Cron
+	CronTimezone
 	CSV
+	CSVFile
 	BlueTable
 	GreenTable
+	MetadataTable
+	ArchiveRetention
+	ArchivePrefix
+	SnapshotSink
+	MaxRuntime
+	InitialDelay
+	ShutdownTimeout
+	MaxAge
+	UpdateWhenStale
+	NoSwap
+	SwapDelay
+	UpdateOnStart
+	WriteMode
+	InternStrings
+	ParseWorkers
+	Sample
+	LogSampleRows
+	LimitURLs
+	URLFailure
+	URLMaxFailureAge
+	RequiredFields
+	MaxParseErrorRate
+	ColumnTolerance
+	NeighborhoodFilter
+	MinOccurDate
+	Delimiter
+	Header
+	Encoding
+	MinExpectedRows
 	Timeout
 	Retries
+	NetworkRetries
+	RetryBudget
+	MaxRetryAfter
+	MaxIdleConns
+	MaxIdleConnsPerHost
+	IdleConnTimeout
+	KeepAlive
+	DisableKeepAlives
+	HTTPTrace
+	MinTLSVersion
 	LogLevel
 	LogFormat
+	ServerAddr
+	ReloadSecret
+	Profile
+	ProfileAddr
 )
 
 // String returns the string representation of the FlagName.
@@ -113,22 +865,152 @@ func (f FlagName) String() string {
 		return "pass"
 	case Name:
 		return "name"
+	case QueryTimeout:
+		return "query-timeout"
+	case SlowQueryThreshold:
+		return "slow-query-threshold"
+	case ReadOnlyReads:
+		return "read-only-reads"
+	case NullSentinelDates:
+		return "null-sentinel-dates"
+	case LogQueries:
+		return "log-queries"
+	case IsolationLevel:
+		return "isolation-level"
+	case EmptyOffenseCount:
+		return "empty-offense-count"
+	case EmptyStrings:
+		return "empty-strings"
+	case Charset:
+		return "charset"
+	case Collation:
+		return "collation"
+	case ReplicaDSN:
+		return "replica-dsn"
+	case UnionReads:
+		return "union-reads"
+	case SourceURLColumn:
+		return "source-url-column"
+	case IngestedAtColumn:
+		return "ingested-at-column"
+	case IngestedAtClock:
+		return "ingested-at-clock"
+	case RecreateMissingTables:
+		return "recreate-missing-tables"
+	case PassFile:
+		return "pass-file"
+	case ConnectRetries:
+		return "connect-retries"
+	case ConnectMaxWait:
+		return "connect-max-wait"
 	case Interval:
 		return "interval"
+	case Cron:
+		return "cron"
+	case CronTimezone:
+		return "cron-timezone"
 	case CSV:
 		return "csv"
+	case CSVFile:
+		return "csv-file"
 	case BlueTable:
 		return "blue-table"
 	case GreenTable:
 		return "green-table"
+	case MetadataTable:
+		return "metadata-table"
+	case ArchiveRetention:
+		return "archive-retention"
+	case ArchivePrefix:
+		return "archive-prefix"
+	case SnapshotSink:
+		return "snapshot-sink"
+	case MaxRuntime:
+		return "max-runtime"
+	case InitialDelay:
+		return "initial-delay"
+	case ShutdownTimeout:
+		return "shutdown-timeout"
+	case MaxAge:
+		return "max-age"
+	case UpdateWhenStale:
+		return "update-when-stale"
+	case NoSwap:
+		return "no-swap"
+	case SwapDelay:
+		return "swap-delay"
+	case UpdateOnStart:
+		return "update-on-start"
+	case WriteMode:
+		return "write-mode"
+	case InternStrings:
+		return "intern-strings"
+	case ParseWorkers:
+		return "parse-workers"
+	case Sample:
+		return "sample"
+	case LogSampleRows:
+		return "log-sample-rows"
+	case LimitURLs:
+		return "limit-urls"
+	case URLFailure:
+		return "url-failure"
+	case URLMaxFailureAge:
+		return "url-max-failure-age"
+	case RequiredFields:
+		return "required-field"
+	case MaxParseErrorRate:
+		return "max-parse-error-rate"
+	case ColumnTolerance:
+		return "column-tolerance"
+	case NeighborhoodFilter:
+		return "neighborhood-filter"
+	case MinOccurDate:
+		return "min-occur-date"
+	case Delimiter:
+		return "delimiter"
+	case Header:
+		return "header"
+	case Encoding:
+		return "encoding"
+	case MinExpectedRows:
+		return "min-expected-rows"
 	case Timeout:
 		return "timeout"
 	case Retries:
 		return "retries"
+	case NetworkRetries:
+		return "network-retries"
+	case RetryBudget:
+		return "retry-budget"
+	case MaxRetryAfter:
+		return "max-retry-after"
+	case MaxIdleConns:
+		return "max-idle-conns"
+	case MaxIdleConnsPerHost:
+		return "max-idle-conns-per-host"
+	case IdleConnTimeout:
+		return "idle-conn-timeout"
+	case KeepAlive:
+		return "keep-alive"
+	case DisableKeepAlives:
+		return "disable-keep-alives"
+	case HTTPTrace:
+		return "http-trace"
+	case MinTLSVersion:
+		return "min-tls-version"
 	case LogLevel:
 		return "log-level"
 	case LogFormat:
 		return "log-format"
+	case ServerAddr:
+		return "server-addr"
+	case ReloadSecret:
+		return "reload-secret"
+	case Profile:
+		return "profile"
+	case ProfileAddr:
+		return "profile-addr"
 	default:
 		return ""
 	}
@@ -141,8 +1023,9 @@ func (f FlagName) String() string {
  */
 
 // InitConfig initializes the Viper configuration by reading from a config file
-// or environment variables.
-func InitConfig(cfgPath string, logger *slog.Logger) {
+// or environment variables. A missing config file is not an error: every setting without a
+// default here can be given by flag or environment variable instead.
+func InitConfig(cfgPath string) error {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 
@@ -153,16 +1036,53 @@ func InitConfig(cfgPath string, logger *slog.Logger) {
 		viper.AddConfigPath("./config")
 	}
 
+	viper.SetDefault("service.metadata-table", "updater_metadata")
+	viper.SetDefault("service.archive-prefix", "updates_archive")
+	viper.SetDefault("service.update-on-start", true)
+	viper.SetDefault("service.shutdown-timeout", "30s")
+	viper.SetDefault("service.write-mode", "insert")
+	viper.SetDefault("service.max-parse-error-rate", 1.0)
+	viper.SetDefault("service.column-tolerance", "strict")
+	viper.SetDefault("service.url-failure", "abort")
+	viper.SetDefault("http.min-tls-version", "1.2")
+	viper.SetDefault("http.max-retry-after", "5m")
+	viper.SetDefault("service.delimiter", ",")
+	viper.SetDefault("service.header", true)
+	viper.SetDefault("service.encoding", "utf-8")
+	viper.SetDefault("http.timeout", "30s")
+	viper.SetDefault("http.retries", 3)
+	viper.SetDefault("http.max-idle-conns", 100)
+	viper.SetDefault("http.max-idle-conns-per-host", 10)
+	viper.SetDefault("http.idle-conn-timeout", "90s")
+	viper.SetDefault("http.keep-alive", "30s")
+	viper.SetDefault("logger.level", "info")
+	viper.SetDefault("logger.format", "text")
+	viper.SetDefault("profile.address", "localhost:6060")
+	viper.SetDefault("database.empty-offense-count", "null")
+	viper.SetDefault("database.empty-strings", "keep")
+	viper.SetDefault("database.ingested-at-clock", "service")
+	viper.SetDefault("database.charset", "utf8mb4")
+	viper.SetDefault("database.connect-retries", 10)
+	viper.SetDefault("database.connect-max-wait", "2m")
+	viper.SetDefault(
+		"database.indexes",
+		[]string{"Neighborhood", "OffenseCategory", "OccurDateTime"},
+	)
+
+	// Keys map to variables like UPDATER_SERVICE_CHECK_INTERVAL.
 	viper.SetEnvPrefix("UPDATER")
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			logger.Error("error reading config file", "error", err)
-			os.Exit(1)
+		// An explicit config file that doesn't exist reports a plain not-exist error.
+		_, notFound := err.(viper.ConfigFileNotFoundError)
+		if !notFound && !errors.Is(err, fs.ErrNotExist) {
+			return err
 		}
 	}
+
+	return nil
 }
 
 // BindAllFlags binds all user-changed flags in a Cobra FlagSet to Viper configuration keys
@@ -181,22 +1101,152 @@ func BindAllFlags(cmd *cobra.Command) {
 			viperName = "database.password"
 		case Name.String():
 			viperName = "database.name"
+		case QueryTimeout.String():
+			viperName = "database.query-timeout"
+		case SlowQueryThreshold.String():
+			viperName = "database.slow-query-threshold"
+		case ReadOnlyReads.String():
+			viperName = "database.read-only-reads"
+		case NullSentinelDates.String():
+			viperName = "database.null-sentinel-dates"
+		case LogQueries.String():
+			viperName = "database.log-queries"
+		case IsolationLevel.String():
+			viperName = "database.isolation-level"
+		case EmptyOffenseCount.String():
+			viperName = "database.empty-offense-count"
+		case EmptyStrings.String():
+			viperName = "database.empty-strings"
+		case Charset.String():
+			viperName = "database.charset"
+		case Collation.String():
+			viperName = "database.collation"
+		case ReplicaDSN.String():
+			viperName = "database.replica-dsn"
+		case UnionReads.String():
+			viperName = "database.union-reads"
+		case SourceURLColumn.String():
+			viperName = "database.source-url-column"
+		case IngestedAtColumn.String():
+			viperName = "database.ingested-at-column"
+		case IngestedAtClock.String():
+			viperName = "database.ingested-at-clock"
+		case RecreateMissingTables.String():
+			viperName = "database.recreate-missing-tables"
+		case PassFile.String():
+			viperName = "database.password-file"
+		case ConnectRetries.String():
+			viperName = "database.connect-retries"
+		case ConnectMaxWait.String():
+			viperName = "database.connect-max-wait"
 		case Interval.String():
 			viperName = "service.check-interval"
+		case Cron.String():
+			viperName = "service.cron"
+		case CronTimezone.String():
+			viperName = "service.cron-timezone"
 		case CSV.String():
 			viperName = "service.csv-urls"
+		case CSVFile.String():
+			viperName = "service.csv-urls-file"
 		case BlueTable.String():
 			viperName = "service.blue-table"
 		case GreenTable.String():
 			viperName = "service.green-table"
+		case MetadataTable.String():
+			viperName = "service.metadata-table"
+		case ArchiveRetention.String():
+			viperName = "service.archive-retention"
+		case ArchivePrefix.String():
+			viperName = "service.archive-prefix"
+		case SnapshotSink.String():
+			viperName = "service.snapshot-sink"
+		case MaxRuntime.String():
+			viperName = "service.max-runtime"
+		case InitialDelay.String():
+			viperName = "service.initial-delay"
+		case ShutdownTimeout.String():
+			viperName = "service.shutdown-timeout"
+		case MaxAge.String():
+			viperName = "service.max-age"
+		case UpdateWhenStale.String():
+			viperName = "service.update-when-stale"
+		case NoSwap.String():
+			viperName = "service.no-swap"
+		case SwapDelay.String():
+			viperName = "service.swap-delay"
+		case UpdateOnStart.String():
+			viperName = "service.update-on-start"
+		case WriteMode.String():
+			viperName = "service.write-mode"
+		case InternStrings.String():
+			viperName = "service.intern-strings"
+		case ParseWorkers.String():
+			viperName = "service.parse-workers"
+		case Sample.String():
+			viperName = "service.sample-rows"
+		case RequiredFields.String():
+			viperName = "service.required-fields"
+		case LogSampleRows.String():
+			viperName = "service.log-sample-rows"
+		case LimitURLs.String():
+			viperName = "service.limit-urls"
+		case URLFailure.String():
+			viperName = "service.url-failure"
+		case URLMaxFailureAge.String():
+			viperName = "service.url-max-failure-age"
+		case MaxParseErrorRate.String():
+			viperName = "service.max-parse-error-rate"
+		case ColumnTolerance.String():
+			viperName = "service.column-tolerance"
+		case NeighborhoodFilter.String():
+			viperName = "service.neighborhood-filter"
+		case MinOccurDate.String():
+			viperName = "service.min-occur-date"
+		case Delimiter.String():
+			viperName = "service.delimiter"
+		case Header.String():
+			viperName = "service.header"
+		case Encoding.String():
+			viperName = "service.encoding"
+		case MinExpectedRows.String():
+			viperName = "service.min-expected-rows"
 		case Timeout.String():
 			viperName = "http.timeout"
 		case Retries.String():
 			viperName = "http.retries"
+		case NetworkRetries.String():
+			viperName = "http.network-retries"
+		case RetryBudget.String():
+			viperName = "http.retry-budget"
+		case MaxRetryAfter.String():
+			viperName = "http.max-retry-after"
+		case MaxIdleConns.String():
+			viperName = "http.max-idle-conns"
+		case MaxIdleConnsPerHost.String():
+			viperName = "http.max-idle-conns-per-host"
+		case IdleConnTimeout.String():
+			viperName = "http.idle-conn-timeout"
+		case KeepAlive.String():
+			viperName = "http.keep-alive"
+		case DisableKeepAlives.String():
+			viperName = "http.disable-keep-alives"
+		case HTTPTrace.String():
+			viperName = "http.trace"
+		case MinTLSVersion.String():
+			viperName = "http.min-tls-version"
 		case LogLevel.String():
 			viperName = "logger.level"
 		case LogFormat.String():
 			viperName = "logger.format"
+		case ServerAddr.String():
+			viperName = "server.address"
+		case ReloadSecret.String():
+			viperName = "server.reload-secret"
+		case Profile.String():
+			viperName = "profile.enabled"
+		case ProfileAddr.String():
+			viperName = "profile.address"
 		default:
 			return
 		}
@@ -206,3 +1256,216 @@ func BindAllFlags(cmd *cobra.Command) {
 		}
 	})
 }
+
+// Reload re-reads the config file and decodes it into a new Config, returning an error if it
+// fails validation. As in InitConfig, a missing config file is not an error; the settings last
+// read from it are kept.
+func Reload() (Config, error) {
+	var reloaded Config
+
+	if err := viper.ReadInConfig(); err != nil {
+		_, notFound := err.(viper.ConfigFileNotFoundError)
+		if !notFound && !errors.Is(err, fs.ErrNotExist) {
+			return reloaded, err
+		}
+	}
+
+	if err := viper.Unmarshal(&reloaded); err != nil {
+		return reloaded, err
+	}
+
+	if err := reloaded.Validate(); err != nil {
+		return reloaded, err
+	}
+
+	return reloaded, nil
+}
+
+// UnknownKeys returns the configuration keys set in Viper, whether from the config file, flags or
+// defaults, that don't correspond to any field of Config, sorted. These are usually typos, which
+// are otherwise silently ignored.
+func UnknownKeys() []string {
+	known := make(map[string]bool)
+	collectKeys(reflect.TypeOf(Config{}), "", known)
+
+	var unknown []string
+	for _, key := range viper.AllKeys() {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	slices.Sort(unknown)
+
+	return unknown
+}
+
+// ParseMinOccurDate returns the earliest occur date a service.min-occur-date value keeps as of now.
+// A date such as 2022-01-01 is returned as is, in UTC like the records' dates, while an age of a
+// number of days or years such as 90d or 3y counts back from the start of now's day in UTC. A blank
+// value returns the zero time, which keeps every record.
+func ParseMinOccurDate(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+
+	var years, days int
+	count, unit := value[:len(value)-1], value[len(value)-1]
+	n, err := strconv.Atoi(count)
+	switch {
+	case err != nil || n < 0:
+		return time.Time{}, fmt.Errorf(
+			"%q must be a YYYY-MM-DD date or an age such as 90d or 3y",
+			value,
+		)
+	case unit == 'd':
+		days = n
+	case unit == 'y':
+		years = n
+	default:
+		return time.Time{}, fmt.Errorf("unknown unit in age %q, must be d or y", value)
+	}
+
+	year, month, day := now.UTC().Date()
+
+	return time.Date(year-years, month, day-days, 0, 0, 0, 0, time.UTC), nil
+}
+
+// Watch watches the config file for changes, re-decoding the configuration each time the file is
+// written and passing the result to onChange. Changes that fail to decode or validate are logged
+// and ignored, leaving the current configuration in place. Nothing is watched when the service was
+// started without a config file.
+func Watch(logger *slog.Logger, onChange func(Config)) {
+	if _, err := os.Stat(viper.ConfigFileUsed()); err != nil {
+		logger.Info("no config file to watch", "file", viper.ConfigFileUsed())
+		return
+	}
+
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		var updated Config
+		if err := viper.Unmarshal(&updated); err != nil {
+			logger.Error(
+				"unable to decode changed config file, keeping current configuration",
+				"file",
+				e.Name,
+				"error",
+				err,
+			)
+			return
+		}
+
+		if err := updated.Validate(); err != nil {
+			logger.Error(
+				"changed config file is invalid, keeping current configuration",
+				"file",
+				e.Name,
+				"error",
+				err,
+			)
+			return
+		}
+
+		logger.Info("config file changed", "file", e.Name)
+		onChange(updated)
+	})
+	viper.WatchConfig()
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// structLogValue returns a group holding an attribute for each field of the struct v, keyed by
+// setting name. prefix is the Viper key of v, for matching fields against SECRET_KEYS.
+func structLogValue(v reflect.Value, prefix string) slog.Value {
+	attrs := make([]slog.Attr, 0, v.NumField())
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		value := v.Field(i)
+		if slices.Contains(SECRET_KEYS, prefix+name) && !value.IsZero() {
+			attrs = append(attrs, slog.String(name, REDACTED))
+			continue
+		}
+
+		attrs = append(attrs, slog.Attr{Key: name, Value: settingLogValue(value, prefix+name)})
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// settingLogValue returns the log value of the setting v, whose Viper key is key. Structs and lists
+// of structs become groups, lists of structs keyed by index, and pointers log what they point to.
+func settingLogValue(v reflect.Value, key string) slog.Value {
+	switch v.Kind() {
+	case reflect.Struct:
+		return structLogValue(v, key+".")
+	case reflect.Pointer:
+		if v.IsNil() {
+			return slog.AnyValue(nil)
+		}
+		return settingLogValue(v.Elem(), key)
+	case reflect.String:
+		return slog.StringValue(redactURL(v.String()))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			values := make([]string, v.Len())
+			for i := range v.Len() {
+				values[i] = redactURL(v.Index(i).String())
+			}
+			return slog.AnyValue(values)
+		}
+
+		attrs := make([]slog.Attr, v.Len())
+		for i := range v.Len() {
+			attrs[i] = slog.Attr{Key: fmt.Sprint(i), Value: settingLogValue(v.Index(i), key)}
+		}
+		return slog.GroupValue(attrs...)
+	default:
+		return slog.AnyValue(v.Interface())
+	}
+}
+
+// redactURL returns s with its password masked if it is a url with a password, and otherwise
+// unchanged.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+
+	return u.Redacted()
+}
+
+// collectKeys adds the Viper key of every field of the struct type t to keys, prefixing each with
+// prefix. Nested structs contribute their fields' keys rather than their own, matching
+// viper.AllKeys, while lists are single keys.
+func collectKeys(t reflect.Type, prefix string, keys map[string]bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			collectKeys(field.Type, prefix+name+".", keys)
+			continue
+		}
+
+		keys[prefix+name] = true
+	}
+}
@@ -0,0 +1,366 @@
+package config
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// validConfig returns a configuration that passes Validate, for tests to modify.
+func validConfig() Config {
+	var c Config
+	c.Database.Host = "localhost"
+	c.Database.Port = 3306
+	c.Database.Username = "updater"
+	c.Database.Name = "crime"
+	c.Database.EmptyOffenseCount = "null"
+	c.Database.EmptyStrings = "keep"
+	c.Database.Charset = "utf8mb4"
+	c.Database.IngestedAtClock = "service"
+	c.Service.CheckInterval = "24h"
+	c.Service.CSVUrls = []string{"https://example.com/data.csv"}
+	c.Service.BlueTable = "crime_blue"
+	c.Service.GreenTable = "crime_green"
+	c.Service.MetadataTable = "updater_metadata"
+	c.Service.MaxParseErrorRate = 1
+	c.Service.WriteMode = "insert"
+	c.Service.ColumnTolerance = "strict"
+	c.Service.URLFailure = "abort"
+	c.Service.Delimiter = ","
+	c.Service.Header = true
+	c.Service.Encoding = "utf-8"
+	c.HTTP.Timeout = "30s"
+	c.Logger.Format = "json"
+	return c
+}
+
+func TestValidateTableNameLength(t *testing.T) {
+	longest := strings.Repeat("t", MAX_TABLE_NAME_LENGTH)
+	tooLong := longest + "t"
+
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			modify: func(c *Config) {},
+		},
+		{
+			name:   "longest allowed",
+			modify: func(c *Config) { c.Service.BlueTable = longest },
+		},
+		{
+			name:    "blue table too long",
+			modify:  func(c *Config) { c.Service.BlueTable = tooLong },
+			wantErr: "service.blue-table must be at most 64 characters",
+		},
+		{
+			name:    "green table too long",
+			modify:  func(c *Config) { c.Service.GreenTable = tooLong },
+			wantErr: "service.green-table must be at most 64 characters",
+		},
+		{
+			name:    "metadata table too long",
+			modify:  func(c *Config) { c.Service.MetadataTable = tooLong },
+			wantErr: "service.metadata-table must be at most 64 characters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(&c)
+
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []SourceConfig
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "url only", sources: []SourceConfig{{URL: "https://example.com/all.csv"}}},
+		{
+			name:    "with timeout",
+			sources: []SourceConfig{{URL: "https://example.com/all.csv", Timeout: "5m"}},
+		},
+		{
+			name:    "missing url",
+			sources: []SourceConfig{{Timeout: "5m"}},
+			wantErr: "service.sources[0].url is required",
+		},
+		{
+			name:    "invalid timeout",
+			sources: []SourceConfig{{URL: "https://example.com/all.csv", Timeout: "5 minutes"}},
+			wantErr: "service.sources[0].timeout is invalid",
+		},
+		{
+			name: "format overrides",
+			sources: []SourceConfig{
+				{URL: "https://example.com/all.csv", Delimiter: ";", Encoding: "latin1"},
+			},
+		},
+		{
+			name:    "invalid delimiter",
+			sources: []SourceConfig{{URL: "https://example.com/all.csv", Delimiter: "\""}},
+			wantErr: "service.sources[0].delimiter must be a single character",
+		},
+		{
+			name:    "invalid encoding",
+			sources: []SourceConfig{{URL: "https://example.com/all.csv", Encoding: "utf-16"}},
+			wantErr: "service.sources[0].encoding must be one of",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			c.Service.Sources = tt.sources
+
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCharset(t *testing.T) {
+	tests := []struct {
+		name      string
+		charset   string
+		collation string
+		wantErr   string
+	}{
+		{name: "charset only", charset: "utf8mb4"},
+		{name: "with collation", charset: "utf8mb4", collation: "utf8mb4_unicode_ci"},
+		{name: "other charset", charset: "latin1", collation: "latin1_swedish_ci"},
+		{
+			name:    "missing charset",
+			wantErr: "database.charset must be a charset name",
+		},
+		{
+			name:    "unsafe charset",
+			charset: "utf8mb4; DROP TABLE x",
+			wantErr: "database.charset must be a charset name",
+		},
+		{
+			name:      "unsafe collation",
+			charset:   "utf8mb4",
+			collation: "utf8mb4_bin'",
+			wantErr:   "database.collation must be a collation name",
+		},
+		{
+			name:      "collation of another charset",
+			charset:   "utf8mb4",
+			collation: "latin1_swedish_ci",
+			wantErr:   "database.collation must be a collation of utf8mb4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			c.Database.Charset = tt.charset
+			c.Database.Collation = tt.collation
+
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{name: "default", version: "", want: tls.VersionTLS12},
+		{name: "tls 1.2", version: "1.2", want: tls.VersionTLS12},
+		{name: "tls 1.3", version: " 1.3 ", want: tls.VersionTLS13},
+		{name: "too old", version: "1.1", wantErr: true},
+		{name: "not a version", version: "latest", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			c.HTTP.MinTLSVersion = tt.version
+
+			got, err := c.TLSVersion()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("TLSVersion() = %d, want an error", got)
+				}
+				if err := c.Validate(); err == nil {
+					t.Error("Validate() = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TLSVersion: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("TLSVersion() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCheckInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		wantErr  bool
+	}{
+		{name: "blank uses the default", interval: ""},
+		{name: "duration", interval: "1h30m"},
+		{name: "invalid", interval: "daily", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			c.Service.CheckInterval = tt.interval
+
+			err := c.Validate()
+			if tt.wantErr {
+				want := "service.check-interval is invalid"
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Fatalf("Validate() = %v, want error containing %q", err, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	const valid = `database:
+  host: localhost
+  port: 3306
+  username: updater
+  name: crime
+service:
+  check-interval: 24h
+  csv-urls:
+    - https://example.com/data.csv
+  blue-table: crime_blue
+  green-table: crime_green
+`
+
+	tests := []struct {
+		name string
+		// change rewrites or removes the config file, at path, between reading and reloading it.
+		change   func(t *testing.T, path string)
+		wantHost string
+		wantErr  string
+	}{
+		{
+			name: "valid change",
+			change: func(t *testing.T, path string) {
+				changed := strings.Replace(valid, "localhost", "db.internal", 1)
+				if err := os.WriteFile(path, []byte(changed), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantHost: "db.internal",
+		},
+		{
+			name: "file removed",
+			change: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantHost: "localhost",
+		},
+		{
+			name: "invalid change",
+			change: func(t *testing.T, path string) {
+				changed := strings.Replace(valid, "24h", "daily", 1)
+				if err := os.WriteFile(path, []byte(changed), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "service.check-interval is invalid",
+		},
+		{
+			name: "malformed file",
+			change: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("database: [\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "yaml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(valid), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := InitConfig(path); err != nil {
+				t.Fatalf("InitConfig: %v", err)
+			}
+			tt.change(t, path)
+
+			reloaded, err := Reload()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Reload() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Reload: %v", err)
+			}
+			if reloaded.Database.Host != tt.wantHost {
+				t.Errorf("reloaded host = %q, want %q", reloaded.Database.Host, tt.wantHost)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lorendsnow/updater/internal/updater"
+)
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// CountByNeighborhood returns the number of records in the active table in each neighborhood,
+// counted by the database with a GROUP BY. Only records that occurred on or after from and before
+// to are counted; a zero from or to leaves that end of the range open. Records without a
+// neighborhood are counted under the empty string, and an empty table yields an empty map.
+func (r *Repository) CountByNeighborhood(
+	ctx context.Context,
+	from time.Time,
+	to time.Time,
+) (map[string]int, error) {
+	return r.countBy(ctx, "Neighborhood", from, to)
+}
+
+// CountByCategory returns the number of records in the active table in each offense category,
+// counted as CountByNeighborhood counts neighborhoods.
+func (r *Repository) CountByCategory(
+	ctx context.Context,
+	from time.Time,
+	to time.Time,
+) (map[string]int, error) {
+	return r.countBy(ctx, "OffenseCategory", from, to)
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// countBy returns the number of records in the active table for each value of the column storing
+// field, counting only records in the range from to to and matching the Filter set by Where.
+func (r *Repository) countBy(
+	ctx context.Context,
+	field string,
+	from time.Time,
+	to time.Time,
+) (map[string]int, error) {
+	i := slices.IndexFunc(r.Columns, func(c updater.Column) bool { return c.Field == field })
+	if i < 0 {
+		return nil, fmt.Errorf("cannot count by %s: the field is not stored", field)
+	}
+	column := r.Columns[i].Name
+
+	where, args, err := r.whereWith(Filter{Since: from, Until: to})
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(
+		"SELECT `%s`, COUNT(*) FROM `%s`%s GROUP BY `%s`",
+		column,
+		r.ActiveTable(),
+		where,
+		column,
+	)
+
+	counts := make(map[string]int)
+	err = r.withQuery(ctx, query, func(ctx context.Context, q querier) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var value sql.NullString
+			var count int
+			if err := rows.Scan(&value, &count); err != nil {
+				return err
+			}
+			counts[value.String] += count
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("counting records by %s: %w", field, err)
+	}
+
+	return counts, nil
+}
+
+// whereWith returns the WHERE clause and parameters matching both the Filter set by Where and
+// filter.
+func (r *Repository) whereWith(filter Filter) (string, []any, error) {
+	where, args, err := whereClause(filter, r.Columns)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch {
+	case r.where == "":
+		return where, args, nil
+	case where == "":
+		return r.where, r.whereArgs, nil
+	}
+
+	combined := r.where + " AND " + strings.TrimPrefix(where, " WHERE ")
+
+	return combined, slices.Concat(r.whereArgs, args), nil
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lorendsnow/updater/internal/updater"
+)
+
+/*
+ *==================================================================================================
+ * Filter Struct
+ *==================================================================================================
+ */
+
+// Filter restricts the records a Repository reads. Each set field adds a condition, and a record
+// must meet all of them: an OccurDateTime on or after Since and before Until, a Neighborhood in
+// Neighborhoods, and an OffenseCategory in Categories. The zero Filter matches every record.
+type Filter struct {
+	Since         time.Time
+	Until         time.Time
+	Neighborhoods []string
+	Categories    []string
+}
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// Where returns a copy of the Repository whose reads only return records matching filter. The
+// filter is translated into the queries' WHERE clause, with every value passed as a parameter, so
+// the database does the filtering and can use its indexes. It returns an error if filter refers to
+// a field the repository's columns don't store, so call it before SelectFields.
+func (r *Repository) Where(filter Filter) (*Repository, error) {
+	where, args, err := whereClause(filter, r.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := *r
+	filtered.where = where
+	filtered.whereArgs = args
+
+	return &filtered, nil
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// whereClause returns the WHERE clause, starting with a space, and its parameters for filter,
+// naming the columns that store each field. Both are empty if filter matches every record.
+func whereClause(filter Filter, columns []updater.Column) (string, []any, error) {
+	var conditions []string
+	var args []any
+
+	add := func(field, condition string, values ...any) error {
+		i := slices.IndexFunc(columns, func(c updater.Column) bool { return c.Field == field })
+		if i < 0 {
+			return fmt.Errorf("cannot filter on %s: the field is not stored", field)
+		}
+
+		conditions = append(conditions, fmt.Sprintf("`%s` %s", columns[i].Name, condition))
+		args = append(args, values...)
+
+		return nil
+	}
+
+	if !filter.Since.IsZero() {
+		if err := add("OccurDateTime", ">= ?", filter.Since); err != nil {
+			return "", nil, err
+		}
+	}
+	if !filter.Until.IsZero() {
+		if err := add("OccurDateTime", "< ?", filter.Until); err != nil {
+			return "", nil, err
+		}
+	}
+	if len(filter.Neighborhoods) > 0 {
+		condition, values := inCondition(filter.Neighborhoods)
+		if err := add("Neighborhood", condition, values...); err != nil {
+			return "", nil, err
+		}
+	}
+	if len(filter.Categories) > 0 {
+		condition, values := inCondition(filter.Categories)
+		if err := add("OffenseCategory", condition, values...); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", nil, nil
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// inCondition returns an IN condition with a placeholder for each of values, and the values as
+// parameters.
+func inCondition(values []string) (string, []any) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+
+	return "IN (" + placeholders + ")", args
+}
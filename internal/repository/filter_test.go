@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lorendsnow/updater/internal/updater"
+)
+
+func TestWhereClause(t *testing.T) {
+	since := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	// Only CaseNumber and Neighborhood are stored.
+	var partial []updater.Column
+	for _, c := range updater.DEFAULT_COLUMNS {
+		if c.Field == "CaseNumber" || c.Field == "Neighborhood" {
+			partial = append(partial, c)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		filter    Filter
+		columns   []updater.Column
+		wantWhere string
+		wantArgs  []any
+		wantErr   bool
+	}{
+		{name: "no filter", columns: updater.DEFAULT_COLUMNS},
+		{
+			name:      "since",
+			filter:    Filter{Since: since},
+			columns:   updater.DEFAULT_COLUMNS,
+			wantWhere: " WHERE `OccurDateTime` >= ?",
+			wantArgs:  []any{since},
+		},
+		{
+			name:      "time range",
+			filter:    Filter{Since: since, Until: until},
+			columns:   updater.DEFAULT_COLUMNS,
+			wantWhere: " WHERE `OccurDateTime` >= ? AND `OccurDateTime` < ?",
+			wantArgs:  []any{since, until},
+		},
+		{
+			name:      "neighborhoods",
+			filter:    Filter{Neighborhoods: []string{"Downtown", "Lakeside"}},
+			columns:   updater.DEFAULT_COLUMNS,
+			wantWhere: " WHERE `Neighborhood` IN (?, ?)",
+			wantArgs:  []any{"Downtown", "Lakeside"},
+		},
+		{
+			name: "everything",
+			filter: Filter{
+				Until:         until,
+				Neighborhoods: []string{"Downtown"},
+				Categories:    []string{"LARCENY", "ASSAULT", "FRAUD"},
+			},
+			columns: updater.DEFAULT_COLUMNS,
+			wantWhere: " WHERE `OccurDateTime` < ? AND `Neighborhood` IN (?) " +
+				"AND `OffenseCategory` IN (?, ?, ?)",
+			wantArgs: []any{until, "Downtown", "LARCENY", "ASSAULT", "FRAUD"},
+		},
+		{
+			name:      "stored field",
+			filter:    Filter{Neighborhoods: []string{"Downtown"}},
+			columns:   partial,
+			wantWhere: " WHERE `Neighborhood` IN (?)",
+			wantArgs:  []any{"Downtown"},
+		},
+		{
+			name:    "field not stored",
+			filter:  Filter{Categories: []string{"LARCENY"}},
+			columns: partial,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args, err := whereClause(tt.filter, tt.columns)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("whereClause() = %q, want an error", where)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("whereClause: %v", err)
+			}
+
+			if where != tt.wantWhere {
+				t.Errorf("where = %q, want %q", where, tt.wantWhere)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
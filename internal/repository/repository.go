@@ -0,0 +1,358 @@
+// Package repository provides read access to the crime records stored by the updater service,
+// always querying whichever of the blue/green tables is currently active.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	cfg "github.com/lorendsnow/updater/internal/config"
+	"github.com/lorendsnow/updater/internal/updater"
+)
+
+/*
+ *==================================================================================================
+ * Query Constants
+ *==================================================================================================
+ */
+
+// MAX_LOGGED_QUERY_LENGTH is the length past which queries are truncated in slow query logs.
+const MAX_LOGGED_QUERY_LENGTH = 200
+
+// METADATA_TIMEOUT bounds each read of the active table from the metadata table by a Repository
+// returned by Open.
+const METADATA_TIMEOUT = 5 * time.Second
+
+/*
+ *==================================================================================================
+ * Repository Struct
+ *==================================================================================================
+ */
+
+// Repository reads records from the active blue/green table.
+//
+// Every query is bounded by QueryTimeout, and queries taking longer than SlowQueryThreshold are
+// logged at warn level, which usually points to a missing index on the active table. A zero value
+// disables either behaviour.
+//
+// When ReadOnlyTx is set each query runs in a read-only transaction, which some setups use to route
+// reads to a replica.
+//
+// When NullSentinelDates is set, dates holding updater.DEFAULT_DATE, which older versions wrote in
+// place of missing or unparseable dates, are read as the zero time.Time so they don't show up as
+// real dates.
+//
+// When UnionReads is set, RecordsUnion reads from both Tables at once.
+type Repository struct {
+	Db                 *sql.DB
+	ActiveTable        func() string
+	QueryTimeout       time.Duration
+	SlowQueryThreshold time.Duration
+	ReadOnlyTx         bool
+	NullSentinelDates  bool
+	UnionReads         bool
+	Tables             [2]string
+	Columns            []updater.Column
+	Logger             *slog.Logger
+
+	// allColumns are every configured column, whichever ones SelectFields selected.
+	allColumns []updater.Column
+
+	// where and whereArgs are the WHERE clause and parameters of the Filter set by Where.
+	where     string
+	whereArgs []any
+
+	// replica is the read replica connection opened by Open, closed by Close.
+	replica *sql.DB
+}
+
+// querier is the subset of *sql.DB and *sql.Tx used for reads. Queries only ever receive a
+// querier, so a read-only transaction can never be used to run a write.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// NewRepository creates a new Repository reading from db, using activeTable to look up the table
+// to query before each read.
+func NewRepository(
+	db *sql.DB,
+	activeTable func() string,
+	config *cfg.Config,
+	logger *slog.Logger,
+) *Repository {
+	logger = logger.WithGroup("repository")
+
+	columns, err := updater.ColumnsFromConfig(config)
+	if err != nil {
+		logger.Error("invalid column configuration, using default columns", "error", err)
+		columns = updater.DEFAULT_COLUMNS
+	}
+
+	return &Repository{
+		Db:           db,
+		ActiveTable:  activeTable,
+		QueryTimeout: parseDuration(config.Database.QueryTimeout, "query-timeout", logger),
+		SlowQueryThreshold: parseDuration(
+			config.Database.SlowQueryThreshold,
+			"slow-query-threshold",
+			logger,
+		),
+		ReadOnlyTx:        config.Database.ReadOnlyReads,
+		NullSentinelDates: config.Database.NullSentinelDates,
+		UnionReads:        config.Database.UnionReads,
+		Tables:            [2]string{config.Service.BlueTable, config.Service.GreenTable},
+		Columns:           columns,
+		Logger:            logger,
+		allColumns:        columns,
+	}
+}
+
+// Open returns a Repository for config that reads from the read replica set by
+// database.replica-dsn, or from primary if there is none. If the replica can't be reached, a
+// warning is logged and the Repository reads from primary instead. Close the Repository once done
+// to close the replica connection.
+//
+// Before each read, the active table is looked up in the metadata table of the database being
+// read, rather than the primary's. A replica lagging behind the primary then keeps reading the
+// table its own metadata says is active, whose data it has already replicated, and doesn't switch
+// to a table whose new data hasn't arrived yet.
+func Open(
+	ctx context.Context,
+	primary *sql.DB,
+	config *cfg.Config,
+	logger *slog.Logger,
+) (*Repository, error) {
+	db := primary
+
+	var replica *sql.DB
+	if config.Database.ReplicaDSN != "" {
+		var err error
+		replica, err = updater.OpenReplica(ctx, config, logger)
+		if err != nil {
+			logger.Warn("unable to connect to read replica, reading from primary", "error", err)
+		} else {
+			db = replica
+		}
+	}
+
+	metadata := updater.NewUpdateService(config, logger)
+	metadata.UseDatabase(db)
+	if err := metadata.LoadMetadata(ctx); err != nil {
+		if replica != nil {
+			replica.Close()
+		}
+		return nil, fmt.Errorf("finding the active table: %w", err)
+	}
+
+	repo := NewRepository(db, metadataActiveTable(metadata, logger), config, logger)
+	repo.replica = replica
+
+	return repo, nil
+}
+
+// Close closes the read replica connection opened by Open, if any. The primary connection is left
+// for its owner to close.
+func (r *Repository) Close() error {
+	if r.replica == nil {
+		return nil
+	}
+
+	return r.replica.Close()
+}
+
+// ReadOnly returns a copy of the Repository that runs every query in a read-only transaction,
+// regardless of the configured ReadOnlyTx setting.
+func (r *Repository) ReadOnly() *Repository {
+	readOnly := *r
+	readOnly.ReadOnlyTx = true
+
+	return &readOnly
+}
+
+// SelectFields returns a copy of the Repository that only reads the columns holding the named
+// Record fields, in the order given, leaving every other field of the returned records empty. It
+// returns an error naming the unknown fields if any aren't stored by the repository's columns.
+func (r *Repository) SelectFields(fields []string) (*Repository, error) {
+	var columns []updater.Column
+	var unknown []string
+	for _, field := range fields {
+		i := slices.IndexFunc(r.Columns, func(c updater.Column) bool { return c.Field == field })
+		if i < 0 {
+			unknown = append(unknown, field)
+			continue
+		}
+		columns = append(columns, r.Columns[i])
+	}
+
+	if len(unknown) > 0 {
+		known := make([]string, len(r.Columns))
+		for i, c := range r.Columns {
+			known[i] = c.Field
+		}
+		return nil, fmt.Errorf(
+			"unknown fields %s (known fields are %s)",
+			strings.Join(unknown, ", "),
+			strings.Join(known, ", "),
+		)
+	}
+
+	selected := *r
+	selected.Columns = columns
+
+	return &selected, nil
+}
+
+// Records returns up to limit records from the active table, matching the Filter set by Where.
+func (r *Repository) Records(ctx context.Context, limit int) ([]updater.Record, error) {
+	query := fmt.Sprintf(
+		"SELECT %s FROM `%s`%s LIMIT ?",
+		updater.ColumnNames(r.Columns),
+		r.ActiveTable(),
+		r.where,
+	)
+	args := append(slices.Clip(r.whereArgs), limit)
+
+	var records []updater.Record
+	err := r.withQuery(ctx, query, func(ctx context.Context, q querier) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var record updater.Record
+			if err := r.scanRecord(rows, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("querying records: %w", err)
+	}
+
+	return records, nil
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// withQuery runs fn with a context bounded by QueryTimeout, logging query if fn takes longer than
+// SlowQueryThreshold. If ReadOnlyTx is set, fn receives a read-only transaction, otherwise it
+// receives the database itself.
+func (r *Repository) withQuery(
+	ctx context.Context,
+	query string,
+	fn func(ctx context.Context, q querier) error,
+) error {
+	if r.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.QueryTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := r.run(ctx, fn)
+	elapsed := time.Since(start)
+
+	if r.SlowQueryThreshold > 0 && elapsed > r.SlowQueryThreshold {
+		r.Logger.Warn("slow query", "elapsed", elapsed, "query", sanitizeQuery(query))
+	}
+
+	return err
+}
+
+// run calls fn with either the database or a read-only transaction, depending on ReadOnlyTx.
+func (r *Repository) run(ctx context.Context, fn func(ctx context.Context, q querier) error) error {
+	if !r.ReadOnlyTx {
+		return fn(ctx, r.Db)
+	}
+
+	tx, err := r.Db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("beginning read-only transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// scanRecord scans the current row, selected with the repository's columns, into record, clearing
+// sentinel dates if NullSentinelDates is set.
+func (r *Repository) scanRecord(rows *sql.Rows, record *updater.Record) error {
+	dests := make([]any, len(r.Columns))
+	for i, c := range r.Columns {
+		dests[i] = c.Dest(record)
+	}
+
+	if err := rows.Scan(dests...); err != nil {
+		return err
+	}
+
+	if r.NullSentinelDates {
+		for _, dest := range dests {
+			if date, ok := dest.(*time.Time); ok && date.Equal(updater.DEFAULT_DATE) {
+				*date = time.Time{}
+			}
+		}
+	}
+
+	return nil
+}
+
+// metadataActiveTable returns an ActiveTable function reloading service's metadata before
+// returning its active table. If the metadata can't be read, a warning is logged and the last
+// active table read is returned.
+func metadataActiveTable(service *updater.UpdateService, logger *slog.Logger) func() string {
+	return func() string {
+		ctx, cancel := context.WithTimeout(context.Background(), METADATA_TIMEOUT)
+		defer cancel()
+
+		if err := service.LoadMetadata(ctx); err != nil {
+			logger.Warn("unable to read the active table, using the last one read", "error", err)
+		}
+
+		return service.LastUpdatedTable()
+	}
+}
+
+// sanitizeQuery collapses whitespace in query and truncates it for logging. Queries are always
+// parameterized, so they never contain record values.
+func sanitizeQuery(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > MAX_LOGGED_QUERY_LENGTH {
+		query = query[:MAX_LOGGED_QUERY_LENGTH] + "..."
+	}
+
+	return query
+}
+
+// parseDuration parses value as a duration, logging a warning and returning zero if it is invalid.
+func parseDuration(value string, name string, logger *slog.Logger) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Warn("invalid duration, disabling", "setting", name, "value", value, "error", err)
+		return 0
+	}
+
+	return d
+}
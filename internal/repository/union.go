@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/lorendsnow/updater/internal/updater"
+)
+
+/*
+ *==================================================================================================
+ * Union Constants
+ *==================================================================================================
+ */
+
+// UNION_KEY_FIELDS are the Record fields making up the synthetic key RecordsUnion deduplicates
+// records by: each record is one offense type of one case, so a record present in both tables
+// shares these values in each.
+var UNION_KEY_FIELDS = []string{"CaseNumber", "OffenseType"}
+
+// ErrUnionReadsDisabled is returned by RecordsUnion unless database.union-reads is set.
+var ErrUnionReadsDisabled = errors.New("reading both tables is disabled by database.union-reads")
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// RecordsUnion returns up to limit records from the blue and green tables combined, matching the
+// Filter set by Where, for when it's in doubt whether the last swap should have happened. Records
+// in both tables are returned once, as the active table holds them, keyed by UNION_KEY_FIELDS.
+//
+// It's only allowed when UnionReads is set, since it costs far more than Records: the filter is
+// applied to both tables, and every matching row from each is then sorted by the database to
+// remove the duplicates before limit applies, so no index helps past the WHERE clause. Use it with
+// a narrow filter, and leave database.union-reads off once the swap is settled. The deduplication
+// uses a window function, which needs MySQL 8.0 or later.
+func (r *Repository) RecordsUnion(ctx context.Context, limit int) ([]updater.Record, error) {
+	if !r.UnionReads {
+		return nil, ErrUnionReadsDisabled
+	}
+
+	keys, err := r.unionKeyColumns()
+	if err != nil {
+		return nil, err
+	}
+
+	active := r.ActiveTable()
+	inactive := r.Tables[0]
+	if inactive == active {
+		inactive = r.Tables[1]
+	}
+
+	// The key columns are read alongside the selected ones, even when SelectFields left them out.
+	read := slices.Clone(r.Columns)
+	for _, key := range keys {
+		if !slices.ContainsFunc(read, func(c updater.Column) bool { return c.Name == key.Name }) {
+			read = append(read, key)
+		}
+	}
+
+	query := fmt.Sprintf(
+		"SELECT %s FROM ("+
+			"SELECT %s, ROW_NUMBER() OVER (PARTITION BY %s ORDER BY union_source) AS union_row "+
+			"FROM (SELECT %s, 0 AS union_source FROM `%s`%s "+
+			"UNION ALL SELECT %s, 1 AS union_source FROM `%s`%s) AS combined"+
+			") AS ranked WHERE union_row = 1 LIMIT ?",
+		updater.ColumnNames(r.Columns),
+		updater.ColumnNames(read),
+		updater.ColumnNames(keys),
+		updater.ColumnNames(read),
+		active,
+		r.where,
+		updater.ColumnNames(read),
+		inactive,
+		r.where,
+	)
+	args := slices.Concat(r.whereArgs, r.whereArgs, []any{limit})
+
+	var records []updater.Record
+	err = r.withQuery(ctx, query, func(ctx context.Context, q querier) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var record updater.Record
+			if err := r.scanRecord(rows, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("querying records from both tables: %w", err)
+	}
+
+	return records, nil
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// unionKeyColumns returns the columns storing UNION_KEY_FIELDS, out of every configured column
+// rather than only the selected ones.
+func (r *Repository) unionKeyColumns() ([]updater.Column, error) {
+	keys := make([]updater.Column, len(UNION_KEY_FIELDS))
+	for i, field := range UNION_KEY_FIELDS {
+		j := slices.IndexFunc(r.allColumns, func(c updater.Column) bool { return c.Field == field })
+		if j < 0 {
+			return nil, fmt.Errorf("cannot read both tables: the %s field is not stored", field)
+		}
+		keys[i] = r.allColumns[j]
+	}
+
+	return keys, nil
+}
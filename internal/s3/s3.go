@@ -0,0 +1,156 @@
+// Package s3 provides a minimal client for Amazon S3 and S3-compatible object stores, covering the
+// handful of object operations the updater needs on top of the AWS SDK.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+/*
+ *==================================================================================================
+ * S3 Constants
+ *==================================================================================================
+ */
+
+// SCHEME is the url scheme of S3 object locations, as in s3://bucket/key.
+const SCHEME = "s3"
+
+// DEFAULT_REGION is used when no region is configured in the environment or shared config file.
+const DEFAULT_REGION = "us-east-1"
+
+/*
+ *==================================================================================================
+ * Client Struct
+ *==================================================================================================
+ */
+
+// Client sends requests to S3 through the AWS SDK. With a custom endpoint configured, buckets are
+// addressed path-style, as S3-compatible stores expect.
+type Client struct {
+	api *awss3.Client
+}
+
+// NewClient creates a Client configured from the environment the way the AWS tools are, through
+// the SDK's default configuration chain: credentials from AWS_ACCESS_KEY_ID and the rest of the
+// standard environment variables, the shared config and credentials files of AWS_PROFILE, or the
+// instance's role; the region from AWS_REGION or the profile; and a custom endpoint from
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL. Requests are sent with httpClient, if not nil.
+func NewClient(ctx context.Context, httpClient *http.Client) (*Client, error) {
+	var options []func(*awsconfig.LoadOptions) error
+	if httpClient != nil {
+		options = append(options, awsconfig.WithHTTPClient(httpClient))
+	}
+
+	config, err := awsconfig.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	if config.Region == "" {
+		config.Region = DEFAULT_REGION
+	}
+
+	pathStyle := os.Getenv("AWS_ENDPOINT_URL_S3") != "" || os.Getenv("AWS_ENDPOINT_URL") != ""
+	api := awss3.NewFromConfig(config, func(o *awss3.Options) {
+		o.UsePathStyle = pathStyle
+		// S3-compatible stores often don't return checksums, which the SDK would warn about on
+		// every download.
+		o.DisableLogOutputChecksumValidationSkipped = true
+	})
+
+	return &Client{api: api}, nil
+}
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// ParseURL splits an s3://bucket/key url into its bucket and key. The key may be empty, or a
+// prefix, for urls naming a location rather than an object.
+func ParseURL(raw string) (string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != SCHEME || u.Host == "" {
+		return "", "", fmt.Errorf("%q is not an s3://bucket/key url", raw)
+	}
+
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// GetObject returns the body of the object at key in bucket. The caller must close it.
+func (c *Client) GetObject(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	output, err := c.api.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Body, nil
+}
+
+// PutObject stores body as the object at key in bucket.
+func (c *Client) PutObject(
+	ctx context.Context,
+	bucket string,
+	key string,
+	body []byte,
+	contentType string,
+) error {
+	_, err := c.api.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+
+	return err
+}
+
+// DeleteObject deletes the object at key in bucket. Deleting a missing object is not an error.
+func (c *Client) DeleteObject(ctx context.Context, bucket string, key string) error {
+	_, err := c.api.DeleteObject(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+
+	return err
+}
+
+// ListObjects returns the keys of the objects in bucket that start with prefix, in S3's
+// lexicographic order.
+func (c *Client) ListObjects(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	var keys []string
+
+	pages := awss3.NewListObjectsV2Paginator(c.api, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,211 @@
+// Package server provides the updater service's optional HTTP server, which exposes the state and
+// metrics of the running service for monitoring and lets operators trigger an update on demand.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lorendsnow/updater/internal/updater"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+ *==================================================================================================
+ * Health Struct
+ *==================================================================================================
+ */
+
+// Health is the payload served by /healthz.
+type Health struct {
+	Status      string              `json:"status"`
+	ActiveTable string              `json:"active_table"`
+	LastUpdated time.Time           `json:"last_updated"`
+	LastError   *updater.CycleError `json:"last_error,omitempty"`
+}
+
+// HEALTH_OK and HEALTH_FAILING are the possible Health statuses. The service is failing if its most
+// recent update cycle returned an error.
+const HEALTH_OK = "ok"
+const HEALTH_FAILING = "failing"
+
+// EVENTS_CONTENT_TYPE is the content type of the /events stream, newline-delimited JSON.
+const EVENTS_CONTENT_TYPE = "application/x-ndjson"
+
+// RELOAD_SECRET_HEADER carries the shared secret authenticating POST /reload requests.
+const RELOAD_SECRET_HEADER = "X-Reload-Secret"
+
+// reloadError is the payload served by /reload when the triggered update fails.
+type reloadError struct {
+	Error string `json:"error"`
+}
+
+/*
+ *==================================================================================================
+ * Server Struct
+ *==================================================================================================
+ */
+
+// Server serves the health of an UpdateService over HTTP, its Prometheus metrics at /metrics, and
+// a stream of its events at /events. If ReloadSecret is set it also serves
+// POST /reload, which runs an update cycle for requests carrying the secret.
+type Server struct {
+	Service      *updater.UpdateService
+	Logger       *slog.Logger
+	ReloadSecret string
+	httpServer   *http.Server
+}
+
+// NewServer creates a new Server for service, listening on addr once started. An empty
+// reloadSecret disables the /reload endpoint.
+func NewServer(
+	addr string,
+	reloadSecret string,
+	service *updater.UpdateService,
+	logger *slog.Logger,
+) *Server {
+	s := &Server{
+		Service:      service,
+		Logger:       logger.WithGroup("server"),
+		ReloadSecret: reloadSecret,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /events", s.handleEvents)
+	if reloadSecret != "" {
+		mux.HandleFunc("POST /reload", s.handleReload)
+	}
+
+	s.httpServer = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+// Start serves HTTP requests in the background until Shutdown is called.
+func (s *Server) Start() {
+	go func() {
+		s.Logger.Info("starting http server", "address", s.httpServer.Addr)
+
+		err := s.httpServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.Logger.Error("http server stopped", "error", err)
+		}
+	}()
+}
+
+// Shutdown gracefully stops the server, waiting for in-flight requests until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+/*
+ *==================================================================================================
+ * Handlers
+ *==================================================================================================
+ */
+
+// handleHealth reports the service's active table and last cycle error, responding with 503
+// Service Unavailable while the most recent cycle has failed.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := Health{
+		Status:      HEALTH_OK,
+		ActiveTable: s.Service.LastUpdatedTable(),
+		LastUpdated: s.Service.LastUpdated(),
+		LastError:   s.Service.LastError(),
+	}
+
+	status := http.StatusOK
+	if health.LastError != nil {
+		health.Status = HEALTH_FAILING
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		s.Logger.Error("failed to write health response", "error", err)
+	}
+}
+
+// handleEvents streams the service's events as they happen, one JSON TableChangeEvent per line,
+// starting with the most recent table change. The stream ends when the client disconnects or the
+// service stops.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	events := s.Service.Subscribe(true)
+	defer s.Service.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", EVENTS_CONTENT_TYPE)
+	w.WriteHeader(http.StatusOK)
+
+	flusher := http.NewResponseController(w)
+	if err := flusher.Flush(); err != nil {
+		s.Logger.Error("failed to start event stream", "error", err)
+		return
+	}
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(event); err != nil {
+				s.Logger.Debug("event stream closed", "remote", r.RemoteAddr, "error", err)
+				return
+			}
+			if err := flusher.Flush(); err != nil {
+				s.Logger.Debug("event stream closed", "remote", r.RemoteAddr, "error", err)
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleReload runs an update cycle and responds with its CycleStats, with 409 Conflict if an
+// update is already in progress, or with 500 Internal Server Error and the cycle's error if it
+// failed.
+// Requests without the shared secret in RELOAD_SECRET_HEADER are rejected with 401 Unauthorized.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	secret := r.Header.Get(RELOAD_SECRET_HEADER)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.ReloadSecret)) != 1 {
+		s.Logger.Warn("rejected unauthorized reload request", "remote", r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.Logger.Info("reload requested", "remote", r.RemoteAddr)
+
+	var payload any
+	status := http.StatusOK
+
+	stats, err := s.Service.TriggerUpdate(r.Context())
+	if errors.Is(err, updater.ErrUpdateInProgress) {
+		payload = reloadError{Error: err.Error()}
+		status = http.StatusConflict
+	} else if err != nil {
+		payload = reloadError{Error: err.Error()}
+		status = http.StatusInternalServerError
+	} else {
+		payload = stats
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		s.Logger.Error("failed to write reload response", "error", err)
+	}
+}
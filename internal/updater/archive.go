@@ -0,0 +1,126 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+ *==================================================================================================
+ * Archive Constants
+ *==================================================================================================
+ */
+
+// ARCHIVE_TIME_FORMAT formats the load time of an archived dataset into its table name. It sorts
+// chronologically, which retention relies on.
+const ARCHIVE_TIME_FORMAT = "2006_01_02_150405"
+
+// ARCHIVE_TABLES_SQL lists the tables in the current database whose names start with a prefix.
+const ARCHIVE_TABLES_SQL = "SELECT TABLE_NAME FROM information_schema.TABLES " +
+	"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME LIKE ?"
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// archiveTable copies the dataset in table into a table named after ArchivePrefix and the time the
+// dataset was loaded, then drops the oldest archives beyond ArchiveRetention. With a SnapshotSink
+// the dataset is exported there under the same name instead. Tables that have never been
+// populated, and datasets that were already archived, are skipped.
+func (s *UpdateService) archiveTable(ctx context.Context, table *Table) error {
+	lastUpdated := s.lastUpdated(table)
+	if lastUpdated.IsZero() {
+		return nil
+	}
+	if s.Db == nil {
+		return ErrNoDatabase
+	}
+
+	name := s.ArchivePrefix + "_" + lastUpdated.UTC().Format(ARCHIVE_TIME_FORMAT)
+	if s.SnapshotSink != "" {
+		return s.exportSnapshot(ctx, table, name)
+	}
+
+	archives, err := s.archiveTables(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(archives, name) {
+		stmt := fmt.Sprintf("CREATE TABLE `%s` LIKE `%s`", name, table.Name)
+		if _, err := s.Db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("creating archive table %s: %w", name, err)
+		}
+
+		stmt = fmt.Sprintf("INSERT INTO `%s` SELECT * FROM `%s`", name, table.Name)
+		if _, err := s.Db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("copying %s into archive table %s: %w", table.Name, name, err)
+		}
+
+		archives = append(archives, name)
+		slices.Sort(archives)
+		s.Logger.Info("archived previous dataset", "table", table.Name, "archive", name)
+	}
+
+	for len(archives) > s.ArchiveRetention {
+		stmt := fmt.Sprintf("DROP TABLE `%s`", archives[0])
+		if _, err := s.Db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("dropping archive table %s: %w", archives[0], err)
+		}
+
+		s.Logger.Info("dropped expired archive", "archive", archives[0])
+		archives = archives[1:]
+	}
+
+	return nil
+}
+
+// archiveTables returns the names of the existing archive tables, oldest first.
+func (s *UpdateService) archiveTables(ctx context.Context) ([]string, error) {
+	prefix := s.ArchivePrefix + "_"
+	pattern := strings.NewReplacer(`\`, `\\`, "_", `\_`, "%", `\%`).Replace(prefix) + "%"
+
+	rows, err := s.Db.QueryContext(ctx, ARCHIVE_TABLES_SQL, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("listing archive tables: %w", err)
+	}
+	defer rows.Close()
+
+	var archives []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("listing archive tables: %w", err)
+		}
+
+		// Only count tables whose suffix is an archive time, in case the prefix is shared.
+		suffix := strings.TrimPrefix(name, prefix)
+		if _, err := time.Parse(ARCHIVE_TIME_FORMAT, suffix); err == nil {
+			archives = append(archives, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing archive tables: %w", err)
+	}
+
+	slices.Sort(archives)
+
+	return archives, nil
+}
+
+// activeTable returns the table that is currently serving, the counterpart of inactiveTable.
+func (s *UpdateService) activeTable() *Table {
+	s.tablesMu.RLock()
+	defer s.tablesMu.RUnlock()
+
+	if s.leastRecentlyUpdated() == s.BlueTable {
+		return s.GreenTable
+	}
+
+	return s.BlueTable
+}
@@ -0,0 +1,374 @@
+package updater
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	cfg "github.com/lorendsnow/updater/internal/config"
+)
+
+/*
+ *==================================================================================================
+ * Column Struct
+ *==================================================================================================
+ */
+
+// Column declares one column of the blue/green tables and the Record field it holds. The same
+// column list drives EnsureSchema, WriteRecords and the Repository's reads, so adding or changing
+// a column only has to be done in one place.
+type Column struct {
+	Name     string
+	Field    string
+	SQLType  string
+	Nullable bool
+
+	// WriteExpr, if set, is an SQL expression written in place of the field's value, so the
+	// database computes the column's value.
+	WriteExpr string
+
+	// Value returns the field's value from a Record for writing.
+	Value func(r *Record) any
+
+	// Dest returns a pointer to the field in a Record for scanning.
+	Dest func(r *Record) any
+}
+
+// DEFAULT_COLUMNS stores every Record field in a column of the same name.
+var DEFAULT_COLUMNS = []Column{
+	{
+		Name:    "Address",
+		Field:   "Address",
+		SQLType: "VARCHAR(255)",
+		Value:   func(r *Record) any { return r.Address },
+		Dest:    func(r *Record) any { return &r.Address },
+	},
+	{
+		Name:    "CaseNumber",
+		Field:   "CaseNumber",
+		SQLType: "VARCHAR(32)",
+		Value:   func(r *Record) any { return r.CaseNumber },
+		Dest:    func(r *Record) any { return &r.CaseNumber },
+	},
+	{
+		Name:    "CrimeAgainst",
+		Field:   "CrimeAgainst",
+		SQLType: "VARCHAR(64)",
+		Value:   func(r *Record) any { return r.CrimeAgainst },
+		Dest:    func(r *Record) any { return &r.CrimeAgainst },
+	},
+	{
+		Name:    "Neighborhood",
+		Field:   "Neighborhood",
+		SQLType: "VARCHAR(128)",
+		Value:   func(r *Record) any { return r.Neighborhood },
+		Dest:    func(r *Record) any { return &r.Neighborhood },
+	},
+	{
+		Name:    "OccurDateTime",
+		Field:   "OccurDateTime",
+		SQLType: "DATETIME",
+		Value:   func(r *Record) any { return r.OccurDateTime },
+		Dest:    func(r *Record) any { return &r.OccurDateTime },
+	},
+	{
+		Name:    "OffenseCategory",
+		Field:   "OffenseCategory",
+		SQLType: "VARCHAR(128)",
+		Value:   func(r *Record) any { return r.OffenseCategory },
+		Dest:    func(r *Record) any { return &r.OffenseCategory },
+	},
+	{
+		Name:    "OffenseType",
+		Field:   "OffenseType",
+		SQLType: "VARCHAR(128)",
+		Value:   func(r *Record) any { return r.OffenseType },
+		Dest:    func(r *Record) any { return &r.OffenseType },
+	},
+	{
+		Name:     "OpenDataLat",
+		Field:    "OpenDataLat",
+		SQLType:  "DOUBLE",
+		Nullable: true,
+		Value:    func(r *Record) any { return r.OpenDataLat },
+		Dest:     func(r *Record) any { return &r.OpenDataLat },
+	},
+	{
+		Name:     "OpenDataLon",
+		Field:    "OpenDataLon",
+		SQLType:  "DOUBLE",
+		Nullable: true,
+		Value:    func(r *Record) any { return r.OpenDataLon },
+		Dest:     func(r *Record) any { return &r.OpenDataLon },
+	},
+	{
+		Name:     "OpenDataX",
+		Field:    "OpenDataX",
+		SQLType:  "DOUBLE",
+		Nullable: true,
+		Value:    func(r *Record) any { return r.OpenDataX },
+		Dest:     func(r *Record) any { return &r.OpenDataX },
+	},
+	{
+		Name:     "OpenDataY",
+		Field:    "OpenDataY",
+		SQLType:  "DOUBLE",
+		Nullable: true,
+		Value:    func(r *Record) any { return r.OpenDataY },
+		Dest:     func(r *Record) any { return &r.OpenDataY },
+	},
+	{
+		Name:    "ReportDate",
+		Field:   "ReportDate",
+		SQLType: "DATE",
+		Value:   func(r *Record) any { return r.ReportDate },
+		Dest:    func(r *Record) any { return &r.ReportDate },
+	},
+	{
+		Name:     "OffenseCount",
+		Field:    "OffenseCount",
+		SQLType:  "INT",
+		Nullable: true,
+		Value:    func(r *Record) any { return r.OffenseCount },
+		Dest:     func(r *Record) any { return &r.OffenseCount },
+	},
+}
+
+// SOURCE_URL_COLUMN stores the url each record was downloaded from. It isn't one of
+// DEFAULT_COLUMNS since it widens the tables; database.source-url-column appends it.
+var SOURCE_URL_COLUMN = Column{
+	Name:    "SourceURL",
+	Field:   "SourceURL",
+	SQLType: "VARCHAR(2048)",
+	Value:   func(r *Record) any { return r.SourceURL },
+	Dest:    func(r *Record) any { return &r.SourceURL },
+}
+
+// INGESTED_AT_COLUMN stores when each record was written in UTC, by the service's clock unless
+// database.ingested-at-clock is INGESTED_AT_CLOCK_DATABASE. It is appended by
+// database.ingested-at-column. It is nullable, since rows written before it was added have no
+// ingestion time, and NULL is read as the zero time.
+var INGESTED_AT_COLUMN = Column{
+	Name:     "IngestedAt",
+	Field:    "IngestedAt",
+	SQLType:  "DATETIME",
+	Nullable: true,
+	Value:    func(r *Record) any { return r.IngestedAt },
+	Dest:     func(r *Record) any { return &nullTime{dest: &r.IngestedAt} },
+}
+
+// INGESTED_AT_CLOCK_SERVICE sets IngestedAt from the service's clock, the default.
+// INGESTED_AT_CLOCK_DATABASE has the database set it with INGESTED_AT_EXPR as each batch of
+// records is inserted, for deployments whose database clock is the reference.
+const INGESTED_AT_CLOCK_SERVICE = "service"
+const INGESTED_AT_CLOCK_DATABASE = "database"
+
+// INGESTED_AT_EXPR is written to the IngestedAt column with INGESTED_AT_CLOCK_DATABASE. It is in
+// UTC, as the service's clock is written.
+const INGESTED_AT_EXPR = "UTC_TIMESTAMP()"
+
+// OPTIONAL_COLUMNS are the columns that are only stored when enabled in the configuration.
+var OPTIONAL_COLUMNS = []Column{SOURCE_URL_COLUMN, INGESTED_AT_COLUMN}
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// ColumnsFromConfig returns the configured table columns, or DEFAULT_COLUMNS if none are
+// configured, with each enabled OPTIONAL_COLUMNS column appended unless already configured. With
+// database.empty-strings set to EMPTY_STRING_NULL, the text columns are nullable and read NULL as
+// an empty string.
+//
+// Each configured column must name a Record field, and may override the column name, SQL type and
+// nullability, which otherwise default to the field's entry in DEFAULT_COLUMNS.
+func ColumnsFromConfig(config *cfg.Config) ([]Column, error) {
+	columns, err := configuredColumns(config)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := map[string]bool{
+		SOURCE_URL_COLUMN.Field:  config.Database.SourceURLColumn,
+		INGESTED_AT_COLUMN.Field: config.Database.IngestedAtColumn,
+	}
+
+	columns = slices.Clip(columns)
+	for _, optional := range OPTIONAL_COLUMNS {
+		configured := slices.ContainsFunc(columns, func(c Column) bool {
+			return c.Field == optional.Field
+		})
+		if enabled[optional.Field] && !configured {
+			columns = append(columns, optional)
+		}
+	}
+
+	if config.Database.IngestedAtClock == INGESTED_AT_CLOCK_DATABASE {
+		columns = slices.Clone(columns)
+		for i, c := range columns {
+			if c.Field == INGESTED_AT_COLUMN.Field {
+				columns[i].WriteExpr = INGESTED_AT_EXPR
+			}
+		}
+	}
+
+	// Applied after the optional columns are appended, so the source url column is nullable too.
+	if config.Database.EmptyStrings == EMPTY_STRING_NULL {
+		columns = nullableStrings(columns)
+	}
+
+	return columns, nil
+}
+
+// ValidateConfig checks the parts of config that refer to Record fields, which the config package
+// can't check itself: the column definitions and the required fields.
+func ValidateConfig(config *cfg.Config) error {
+	_, err := ColumnsFromConfig(config)
+	errs := []error{err}
+
+	for _, field := range config.Service.RequiredFields {
+		if _, ok := columnForField(field); !ok {
+			errs = append(
+				errs,
+				fmt.Errorf("service.required-fields: unknown record field %q", field),
+			)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ColumnNames returns the quoted, comma-separated names of columns for use in SQL statements.
+func ColumnNames(columns []Column) string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = "`" + c.Name + "`"
+	}
+
+	return strings.Join(names, ", ")
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// configuredColumns returns the columns listed in database.columns, or DEFAULT_COLUMNS if there
+// are none.
+func configuredColumns(config *cfg.Config) ([]Column, error) {
+	if len(config.Database.Columns) == 0 {
+		return DEFAULT_COLUMNS, nil
+	}
+
+	columns := make([]Column, 0, len(config.Database.Columns))
+	seen := make(map[string]bool)
+
+	for _, c := range config.Database.Columns {
+		column, ok := columnForField(c.Field)
+		if !ok {
+			return nil, fmt.Errorf("database.columns: unknown record field %q", c.Field)
+		}
+
+		if c.Name != "" {
+			column.Name = c.Name
+		}
+		if c.Type != "" {
+			column.SQLType = c.Type
+		}
+		if c.Nullable != nil {
+			column.Nullable = *c.Nullable
+		}
+
+		if seen[column.Name] {
+			return nil, fmt.Errorf("database.columns: duplicate column %q", column.Name)
+		}
+		seen[column.Name] = true
+
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+// nullableStrings returns a copy of columns with every column holding a string field made
+// nullable, reading NULL as an empty string. WriteRecords writes empty strings as NULL.
+func nullableStrings(columns []Column) []Column {
+	columns = slices.Clone(columns)
+
+	for i, c := range columns {
+		if !isStringColumn(c) {
+			continue
+		}
+
+		dest := c.Dest
+		columns[i].Nullable = true
+		columns[i].Dest = func(r *Record) any {
+			return &nullString{dest: dest(r).(*string)}
+		}
+	}
+
+	return columns
+}
+
+// isStringColumn reports whether c holds a string field of Record.
+func isStringColumn(c Column) bool {
+	_, ok := c.Value(&Record{}).(string)
+	return ok
+}
+
+// nullString scans a nullable text column into a string, reading NULL as an empty string.
+type nullString struct {
+	dest *string
+}
+
+// Scan implements sql.Scanner.
+func (n *nullString) Scan(value any) error {
+	var s sql.NullString
+	if err := s.Scan(value); err != nil {
+		return err
+	}
+	*n.dest = s.String
+
+	return nil
+}
+
+// nullTime scans a nullable date column into a time.Time, reading NULL as the zero time.
+type nullTime struct {
+	dest *time.Time
+}
+
+// Scan implements sql.Scanner.
+func (n *nullTime) Scan(value any) error {
+	var t sql.NullTime
+	if err := t.Scan(value); err != nil {
+		return err
+	}
+	*n.dest = t.Time
+
+	return nil
+}
+
+// columnForField returns the default or optional column holding the named Record field.
+func columnForField(field string) (Column, bool) {
+	for _, c := range slices.Concat(DEFAULT_COLUMNS, OPTIONAL_COLUMNS) {
+		if c.Field == field {
+			return c, true
+		}
+	}
+
+	return Column{}, false
+}
+
+// definition returns the column's definition for a CREATE TABLE statement.
+func (c Column) definition() string {
+	if c.Nullable {
+		return fmt.Sprintf("`%s` %s NULL", c.Name, c.SQLType)
+	}
+
+	return fmt.Sprintf("`%s` %s NOT NULL", c.Name, c.SQLType)
+}
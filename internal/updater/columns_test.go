@@ -0,0 +1,140 @@
+package updater
+
+import (
+	"testing"
+
+	cfg "github.com/lorendsnow/updater/internal/config"
+)
+
+func TestColumnsFromConfigEmptyStrings(t *testing.T) {
+	tests := []struct {
+		name         string
+		emptyStrings string
+		sourceURL    bool
+		wantNullable bool
+	}{
+		{name: "keep", emptyStrings: EMPTY_STRING_KEEP},
+		{name: "keep with source url", emptyStrings: EMPTY_STRING_KEEP, sourceURL: true},
+		{name: "null", emptyStrings: EMPTY_STRING_NULL, wantNullable: true},
+		{
+			name:         "null with source url",
+			emptyStrings: EMPTY_STRING_NULL,
+			sourceURL:    true,
+			wantNullable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config cfg.Config
+			config.Database.EmptyStrings = tt.emptyStrings
+			config.Database.SourceURLColumn = tt.sourceURL
+
+			columns, err := ColumnsFromConfig(&config)
+			if err != nil {
+				t.Fatalf("ColumnsFromConfig: %v", err)
+			}
+
+			sawSourceURL := false
+			for _, c := range columns {
+				if c.Field == SOURCE_URL_COLUMN.Field {
+					sawSourceURL = true
+				}
+
+				want := c.Nullable
+				if def, ok := columnForField(c.Field); ok {
+					want = def.Nullable
+				}
+				if isStringColumn(c) {
+					want = tt.wantNullable
+				}
+				if c.Nullable != want {
+					t.Errorf("%s nullable = %t, want %t", c.Name, c.Nullable, want)
+				}
+			}
+			if sawSourceURL != tt.sourceURL {
+				t.Errorf("source url column present = %t, want %t", sawSourceURL, tt.sourceURL)
+			}
+		})
+	}
+}
+
+func TestNullStringScan(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{name: "null", value: nil, want: ""},
+		{name: "empty", value: "", want: ""},
+		{name: "text", value: "Kerns", want: "Kerns"},
+		{name: "bytes", value: []byte("Kerns"), want: "Kerns"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := "unchanged"
+			if err := (&nullString{dest: &got}).Scan(tt.value); err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("scanned %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnsFromConfigIngestedAtClock(t *testing.T) {
+	tests := []struct {
+		name         string
+		clock        string
+		column       bool
+		wantExpr     string
+		wantIngested bool
+	}{
+		{name: "no column", clock: INGESTED_AT_CLOCK_DATABASE},
+		{name: "service clock", clock: INGESTED_AT_CLOCK_SERVICE, column: true, wantIngested: true},
+		{
+			name:         "database clock",
+			clock:        INGESTED_AT_CLOCK_DATABASE,
+			column:       true,
+			wantExpr:     INGESTED_AT_EXPR,
+			wantIngested: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config cfg.Config
+			config.Database.IngestedAtColumn = tt.column
+			config.Database.IngestedAtClock = tt.clock
+
+			columns, err := ColumnsFromConfig(&config)
+			if err != nil {
+				t.Fatalf("ColumnsFromConfig: %v", err)
+			}
+
+			ingested := false
+			for _, c := range columns {
+				if c.Field != INGESTED_AT_COLUMN.Field {
+					continue
+				}
+				ingested = true
+				if !c.Nullable {
+					t.Errorf("%s is NOT NULL, want nullable", c.Name)
+				}
+				if c.WriteExpr != tt.wantExpr {
+					t.Errorf("%s WriteExpr = %q, want %q", c.Name, c.WriteExpr, tt.wantExpr)
+				}
+			}
+			if ingested != tt.wantIngested {
+				t.Errorf("ingested at column present = %t, want %t", ingested, tt.wantIngested)
+			}
+
+			// The shared definition is never changed.
+			if INGESTED_AT_COLUMN.WriteExpr != "" {
+				t.Errorf("INGESTED_AT_COLUMN.WriteExpr = %q", INGESTED_AT_COLUMN.WriteExpr)
+			}
+		})
+	}
+}
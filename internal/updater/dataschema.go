@@ -1,8 +1,15 @@
 package updater
 
 import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,6 +22,41 @@ import (
 const DATE_TIME_FORMAT = "01/02/2006 1504"
 const DATE_ONLY_FORMAT = "01/02/2006"
 
+// CANCEL_CHECK_ROWS is how often, in rows, ParseCSV checks whether its context was cancelled.
+const CANCEL_CHECK_ROWS = 1000
+
+// CSV_FIELDS names the Record field fed by each CSV column, by position. The occurrence date and
+// time are separate columns combined into OccurDateTime.
+var CSV_FIELDS = []string{
+	"Address",
+	"CaseNumber",
+	"CrimeAgainst",
+	"Neighborhood",
+	"OccurDateTime (date)",
+	"OccurDateTime (time)",
+	"OffenseCategory",
+	"OffenseType",
+	"OpenDataLat",
+	"OpenDataLon",
+	"OpenDataX",
+	"OpenDataY",
+	"ReportDate",
+	"OffenseCount",
+}
+
+// COLUMN_TOLERANCE_STRICT skips rows with the wrong number of columns as parse errors, the default.
+// COLUMN_TOLERANCE_TOLERANT pads short rows with empty values and truncates long ones instead.
+const COLUMN_TOLERANCE_STRICT = "strict"
+const COLUMN_TOLERANCE_TOLERANT = "tolerant"
+
+// LOGGED_FITTED_ROWS is the number of rows padded or truncated under COLUMN_TOLERANCE_TOLERANT that
+// are logged with their line numbers by each parse, or each parse worker. The rest are only counted
+// in ParseStats.FittedRows, so a file with a column too many doesn't log every row.
+const LOGGED_FITTED_ROWS = 5
+
+// DEFAULT_DATE replaces dates that are empty or fail to parse.
+var DEFAULT_DATE = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
 /*
  *==================================================================================================
  * Record Struct
@@ -36,6 +78,108 @@ type Record struct {
 	OpenDataY       *float64
 	ReportDate      time.Time
 	OffenseCount    *int
+
+	// SourceURL is the url the record was downloaded from. It is only stored when the source url
+	// column is enabled.
+	SourceURL string
+
+	// IngestedAt is when the record was written to the database. It is only stored when the
+	// ingestion time column is enabled.
+	IngestedAt time.Time
+}
+
+/*
+ *==================================================================================================
+ * Parse Options
+ *==================================================================================================
+ */
+
+// ParseOptions controls how ParseCSV turns CSV rows into Records.
+type ParseOptions struct {
+	// InternStrings deduplicates the low-cardinality string fields (CrimeAgainst, Neighborhood,
+	// OffenseCategory and OffenseType) so records with equal values share one backing string,
+	// which substantially reduces memory use for large datasets.
+	InternStrings bool
+
+	// SampleRows stops parsing after this many data rows, not counting the header. Zero parses
+	// every row.
+	SampleRows int
+
+	// RequiredFields names Record fields that must not be empty. Rows missing any of them are
+	// counted as parse errors and skipped.
+	RequiredFields []string
+
+	// Neighborhoods keeps only records whose Neighborhood is one of these, ignoring case. The rest
+	// are dropped and counted in ParseResult.Filtered rather than as parse errors. Empty keeps
+	// every record.
+	Neighborhoods []string
+
+	// MinOccurDate drops records whose OccurDateTime is before it, counting them in
+	// ParseResult.Filtered. Records without an occur date are kept, since they aren't known to be
+	// older. Zero keeps every record.
+	MinOccurDate time.Time
+
+	// ColumnTolerance is COLUMN_TOLERANCE_STRICT or COLUMN_TOLERANCE_TOLERANT. Empty is strict.
+	ColumnTolerance string
+
+	// SourceURL is set as the SourceURL of every parsed Record.
+	SourceURL string
+
+	// Delimiter separates the fields of a row. Zero is a comma.
+	Delimiter rune
+
+	// NoHeader parses the first row as data rather than skipping it as a header.
+	NoHeader bool
+
+	// Workers parses rows on this many goroutines when greater than one, for very large files.
+	// Records then come out in no particular order. Sampling always parses serially.
+	Workers int
+
+	// CountOnly parses and counts every row without keeping the Records, so large files can be
+	// checked cheaply. ParseResult.Parsed still counts the records that would have been kept.
+	CountOnly bool
+
+	// Format is FORMAT_CSV or FORMAT_JSONL. Empty is CSV. Delimiter, NoHeader and Workers only
+	// apply to CSV.
+	Format string
+
+	// Encoding is the character encoding of the CSV file, one of ENCODING_UTF8, ENCODING_LATIN1
+	// and ENCODING_WINDOWS_1252. Empty is UTF-8.
+	Encoding string
+}
+
+/*
+ *==================================================================================================
+ * Interner Struct
+ *==================================================================================================
+ */
+
+// Interner deduplicates strings, returning a single shared copy for each distinct value.
+type Interner struct {
+	values map[string]string
+}
+
+// NewInterner creates an empty Interner.
+func NewInterner() *Interner {
+	return &Interner{values: make(map[string]string)}
+}
+
+// Intern returns the shared copy of s, storing a copy of s if it hasn't been seen before. A nil
+// Interner returns s unchanged.
+func (i *Interner) Intern(s string) string {
+	if i == nil {
+		return s
+	}
+
+	if shared, ok := i.values[s]; ok {
+		return shared
+	}
+
+	// Clone so the stored string doesn't keep the rest of the CSV line it was sliced from alive.
+	shared := strings.Clone(s)
+	i.values[shared] = shared
+
+	return shared
 }
 
 /*
@@ -47,10 +191,29 @@ type Record struct {
 // NewRecord takes a row of strings from a CSV file and marshals the data into
 // a Record.
 func NewRecord(row []string, logger *slog.Logger) Record {
-	if len(row) != 14 {
-		logger.Error("bad data format - expected 14 columns", "row length", len(row))
-		return Record{}
+	record, err := ParseRecord(row, logger)
+	var rowErr *RowError
+	if errors.As(err, &rowErr) {
+		logger.Error(
+			"bad data format - expected 14 columns",
+			"row length",
+			len(row),
+			"row",
+			rowErr.LoggedRow(),
+		)
 	}
+
+	return record
+}
+
+// ParseRecord marshals a row of strings from a CSV file into a Record as NewRecord does, but
+// instead of logging a row with the wrong number of columns it returns a *RowError holding the raw
+// row. The error's Line is left for the caller, which knows where the row was read, to set.
+func ParseRecord(row []string, logger *slog.Logger) (Record, error) {
+	if len(row) != len(CSV_FIELDS) {
+		return Record{}, &RowError{Row: row, Err: ErrWrongColumnCount}
+	}
+
 	return Record{
 		Address:         row[0],
 		CaseNumber:      row[1],
@@ -65,7 +228,61 @@ func NewRecord(row []string, logger *slog.Logger) Record {
 		OpenDataY:       parseFloat(row[11]),
 		ReportDate:      parseDate(row[12], logger),
 		OffenseCount:    parseInt(row[13]),
+	}, nil
+}
+
+// ParseCSV reads CSV data from r, skipping the header row, and marshals each remaining row into a
+// Record according to opts. If opts.SampleRows is set, reading stops as soon as that many records
+// have been parsed.
+//
+// Rows with the wrong number of columns, or missing any of opts.RequiredFields, are parse errors
+// and are skipped, though with a tolerant opts.ColumnTolerance short rows are padded and long rows
+// truncated instead. The returned ParseResult holds the records along with the skipped rows and
+// ParseStats counting the parse errors and the missing values in each field.
+//
+// ParseCSV stops with the context's error if ctx is cancelled, checking every CANCEL_CHECK_ROWS
+// rows so a shutdown during a large parse aborts promptly.
+func ParseCSV(
+	ctx context.Context,
+	r io.Reader,
+	opts ParseOptions,
+	logger *slog.Logger,
+) (ParseResult, error) {
+	var result ParseResult
+	interner := newInterner(opts)
+
+	reader, err := newCSVReader(r, opts)
+	if errors.Is(err, io.EOF) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
 	}
+
+	if opts.Workers > 1 && opts.SampleRows <= 0 {
+		return parseParallel(ctx, reader, opts, logger)
+	}
+
+	for opts.SampleRows <= 0 || result.Parsed < opts.SampleRows {
+		if result.Stats.Rows%CANCEL_CHECK_ROWS == 0 {
+			if err := ctx.Err(); err != nil {
+				return ParseResult{}, err
+			}
+		}
+
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ParseResult{}, fmt.Errorf("reading row: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		result.parseRow(row, line, opts, interner, logger)
+	}
+
+	return result, nil
 }
 
 /*
@@ -74,6 +291,158 @@ func NewRecord(row []string, logger *slog.Logger) Record {
  *==================================================================================================
  */
 
+// newCSVReader returns a csv.Reader for the CSV data in r using opts' encoding and delimiter,
+// having read past the header row unless opts.NoHeader is set. It returns io.EOF if r is empty.
+func newCSVReader(r io.Reader, opts ParseOptions) (*csv.Reader, error) {
+	reader := csv.NewReader(decodeReader(r, opts.Encoding))
+	reader.FieldsPerRecord = -1 // NewRecord reports rows with the wrong number of columns
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	}
+
+	if !opts.NoHeader {
+		if _, err := reader.Read(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("reading header: %w", err)
+		}
+	}
+
+	return reader, nil
+}
+
+// parseRow marshals row, which starts on line of the CSV file, into a Record according to opts,
+// adding it to r or counting it as a parse error.
+func (r *ParseResult) parseRow(
+	row []string,
+	line int,
+	opts ParseOptions,
+	interner *Interner,
+	logger *slog.Logger,
+) {
+	record, ok := r.parseRecord(row, line, opts, interner, logger)
+	if ok && !opts.CountOnly {
+		r.Records = append(r.Records, record)
+	}
+}
+
+// parseRecord marshals row as parseRow does, counting it in r but returning the Record rather than
+// adding it to r. It reports false if the row is a parse error.
+func (r *ParseResult) parseRecord(
+	row []string,
+	line int,
+	opts ParseOptions,
+	interner *Interner,
+	logger *slog.Logger,
+) (Record, bool) {
+	if len(row) != len(CSV_FIELDS) && opts.ColumnTolerance == COLUMN_TOLERANCE_TOLERANT {
+		r.Stats.FittedRows++
+		if r.Stats.FittedRows <= LOGGED_FITTED_ROWS {
+			logger.Warn(
+				"row has the wrong number of columns, padding or truncating",
+				"line",
+				line,
+				"columns",
+				len(row),
+				"expected",
+				len(CSV_FIELDS),
+			)
+		}
+		row = fitRow(row, len(CSV_FIELDS))
+	}
+
+	record, err := ParseRecord(row, logger)
+	var rowErr *RowError
+	if errors.As(err, &rowErr) {
+		rowErr.Line = line
+		r.Stats.Rows++
+		r.Stats.BadRows++
+		r.Skipped++
+		r.addError(*rowErr)
+		return Record{}, false
+	}
+
+	r.Stats.add(&record)
+	if field, missing := missingRequiredField(&record, opts.RequiredFields); missing {
+		logger.Warn(
+			"row is missing a required field, skipping",
+			"field",
+			field,
+			"case number",
+			record.CaseNumber,
+		)
+		r.Stats.MissingRequired++
+		r.Skipped++
+		r.addError(RowError{
+			Line: line,
+			Row:  row,
+			Err:  fmt.Errorf("%w: %s", ErrMissingRequiredField, field),
+		})
+		return Record{}, false
+	}
+	if !opts.keeps(&record) {
+		r.Filtered++
+		return Record{}, false
+	}
+	record.CrimeAgainst = interner.Intern(record.CrimeAgainst)
+	record.Neighborhood = interner.Intern(record.Neighborhood)
+	record.OffenseCategory = interner.Intern(record.OffenseCategory)
+	record.OffenseType = interner.Intern(record.OffenseType)
+	record.SourceURL = opts.SourceURL
+
+	r.Parsed++
+
+	return record, true
+}
+
+// keeps reports whether record passes opts.Neighborhoods and opts.MinOccurDate.
+func (opts ParseOptions) keeps(record *Record) bool {
+	if !opts.MinOccurDate.IsZero() &&
+		record.OccurDateTime.Before(opts.MinOccurDate) &&
+		!record.OccurDateTime.Equal(DEFAULT_DATE) {
+		return false
+	}
+
+	if len(opts.Neighborhoods) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(opts.Neighborhoods, func(n string) bool {
+		return strings.EqualFold(n, record.Neighborhood)
+	})
+}
+
+// newInterner returns a new Interner if opts.InternStrings is set, and nil otherwise.
+func newInterner(opts ParseOptions) *Interner {
+	if !opts.InternStrings {
+		return nil
+	}
+
+	return NewInterner()
+}
+
+// missingRequiredField returns the first of fields that is missing from record, if any.
+func missingRequiredField(record *Record, fields []string) (string, bool) {
+	for _, field := range fields {
+		column, ok := columnForField(field)
+		if ok && isMissing(column.Value(record)) {
+			return field, true
+		}
+	}
+
+	return "", false
+}
+
+// fitRow pads row with empty values, or truncates it, to exactly columns values.
+func fitRow(row []string, columns int) []string {
+	if len(row) > columns {
+		return row[:columns]
+	}
+
+	return append(row, make([]string, columns-len(row))...)
+}
+
 // parseDate takes a date string in the format "MM/DD/YYYY" and returns a
 // time.Time with UTC location. If the date string is empty or there's an error
 // while parsing the string, it returns a default value of "01/01/1900".
@@ -88,7 +457,7 @@ func parseDate(date string, logger *slog.Logger) time.Time {
 			"error",
 			err,
 		)
-		formattedDate = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+		formattedDate = DEFAULT_DATE
 	}
 
 	return formattedDate
@@ -111,7 +480,7 @@ func parseDateTime(date string, timeOnly string, logger *slog.Logger) time.Time
 			"error",
 			err,
 		)
-		formattedDate = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+		formattedDate = DEFAULT_DATE
 	}
 
 	return formattedDate
@@ -0,0 +1,384 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchmarkRows is the number of rows in the CSV data the benchmarks parse and write.
+const benchmarkRows = 10000
+
+// benchmarkRow returns a valid CSV row with a case number unique to i.
+func benchmarkRow(i int) []string {
+	return []string{
+		fmt.Sprintf("%d MAIN ST", i),
+		fmt.Sprintf("24-%06d", i),
+		"Property",
+		"Downtown",
+		"01/02/2024",
+		"1330",
+		"Larceny Offenses",
+		"Theft From Motor Vehicle",
+		"45.5",
+		"-122.6",
+		"7640000",
+		"680000",
+		"01/03/2024",
+		"1",
+	}
+}
+
+// benchmarkCSV returns CSV data with a header and rows valid rows.
+func benchmarkCSV(rows int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("Address,CaseNumber,CrimeAgainst,Neighborhood,OccurDate,OccurTime," +
+		"OffenseCategory,OffenseType,OpenDataLat,OpenDataLon,OpenDataX,OpenDataY,ReportDate," +
+		"OffenseCount\n")
+	for i := range rows {
+		for j, field := range benchmarkRow(i) {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(field)
+		}
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+func BenchmarkNewRecord(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	row := benchmarkRow(1)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		NewRecord(row, logger)
+	}
+}
+
+func BenchmarkParseCSV(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	data := benchmarkCSV(benchmarkRows)
+	ctx := context.Background()
+
+	for _, intern := range []bool{false, true} {
+		b.Run(fmt.Sprintf("intern=%t", intern), func(b *testing.B) {
+			opts := ParseOptions{InternStrings: intern}
+
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				result, err := ParseCSV(ctx, bytes.NewReader(data), opts, logger)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(result.Records) != benchmarkRows {
+					b.Fatalf("parsed %d records, want %d", len(result.Records), benchmarkRows)
+				}
+			}
+		})
+	}
+}
+
+func FuzzNewRecord(f *testing.F) {
+	valid := benchmarkRow(1)
+	seed := func(row []string) {
+		f.Add(strings.Join(row, ","))
+	}
+
+	seed(valid)
+	seed(withField(valid, 4, ""))
+	seed(withField(withField(valid, 4, ""), 5, ""))
+	seed(withField(valid, 12, ""))
+	seed(withField(valid, 4, "02/30/2024"))
+	seed(withField(valid, 5, "2561"))
+	seed(withField(valid, 8, "1e400"))
+	seed(withField(valid, 9, "-179769313486231570000000000000000000000000000000000000000000000"))
+	seed(withField(valid, 10, "NaN"))
+	seed(withField(valid, 11, "0x1p-1074"))
+	seed(withField(valid, 13, "99999999999999999999999"))
+	seed(withField(valid, 0, "‮TS NIAM 1‬"))
+	seed(withField(valid, 1, "\xff\xfe\x00"))
+	seed(withField(valid, 3, "Kérns \U0001F6A8"))
+	seed(withField(valid, 7, strings.Repeat("ß", 1000)))
+	seed(valid[:13])
+	seed(append(slices.Clone(valid), "extra"))
+	seed(nil)
+	f.Add(",")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	missingDate := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	f.Fuzz(func(t *testing.T, line string) {
+		row := strings.Split(line, ",")
+		record := NewRecord(row, logger)
+
+		if len(row) != 14 {
+			if record != (Record{}) {
+				t.Fatalf("NewRecord returned %+v for a row of %d columns", record, len(row))
+			}
+			return
+		}
+
+		strs := map[string][2]string{
+			"Address":         {record.Address, row[0]},
+			"CaseNumber":      {record.CaseNumber, row[1]},
+			"CrimeAgainst":    {record.CrimeAgainst, row[2]},
+			"Neighborhood":    {record.Neighborhood, row[3]},
+			"OffenseCategory": {record.OffenseCategory, row[6]},
+			"OffenseType":     {record.OffenseType, row[7]},
+		}
+		for field, values := range strs {
+			if values[0] != values[1] {
+				t.Errorf("%s = %q, want %q", field, values[0], values[1])
+			}
+		}
+
+		if _, err := time.Parse(DATE_TIME_FORMAT, row[4]+" "+row[5]); err != nil &&
+			!record.OccurDateTime.Equal(missingDate) {
+			t.Errorf("OccurDateTime = %v for an invalid date, want 1900-01-01",
+				record.OccurDateTime)
+		}
+		if _, err := time.Parse(DATE_ONLY_FORMAT, row[12]); err != nil &&
+			!record.ReportDate.Equal(missingDate) {
+			t.Errorf("ReportDate = %v for an invalid date, want 1900-01-01", record.ReportDate)
+		}
+
+		floats := map[string]struct {
+			value *float64
+			field string
+		}{
+			"OpenDataLat": {record.OpenDataLat, row[8]},
+			"OpenDataLon": {record.OpenDataLon, row[9]},
+			"OpenDataX":   {record.OpenDataX, row[10]},
+			"OpenDataY":   {record.OpenDataY, row[11]},
+		}
+		for field, float := range floats {
+			_, err := strconv.ParseFloat(float.field, 64)
+			if (err == nil) != (float.value != nil) {
+				t.Errorf("%s = %v for %q", field, float.value, float.field)
+			}
+		}
+
+		if _, err := strconv.Atoi(row[13]); (err == nil) != (record.OffenseCount != nil) {
+			t.Errorf("OffenseCount = %v for %q", record.OffenseCount, row[13])
+		}
+	})
+}
+
+// withField returns a copy of row with the field at i set to value.
+func withField(row []string, i int, value string) []string {
+	row = slices.Clone(row)
+	row[i] = value
+
+	return row
+}
+
+// cancellingReader reads from r, cancelling a context once after more than after bytes were read.
+type cancellingReader struct {
+	r      io.Reader
+	after  int
+	read   int
+	cancel context.CancelFunc
+}
+
+func (c *cancellingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	if c.read > c.after {
+		c.cancel()
+	}
+
+	return n, err
+}
+
+func TestParseCSVCancel(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	data := benchmarkCSV(benchmarkRows)
+
+	tests := []struct {
+		name string
+		// cancelAfter is how many bytes are read before the context is cancelled, or -1 to never
+		// cancel it.
+		cancelAfter int
+		wantErr     error
+	}{
+		{name: "not cancelled", cancelAfter: -1},
+		{name: "cancelled before parsing", cancelAfter: 0, wantErr: context.Canceled},
+		{name: "cancelled mid-parse", cancelAfter: len(data) / 4, wantErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var r io.Reader = bytes.NewReader(data)
+			switch {
+			case tt.cancelAfter == 0:
+				cancel()
+			case tt.cancelAfter > 0:
+				r = &cancellingReader{r: r, after: tt.cancelAfter, cancel: cancel}
+			}
+
+			result, err := ParseCSV(ctx, r, ParseOptions{}, logger)
+			records := result.Records
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseCSV error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && len(records) != benchmarkRows {
+				t.Errorf("parsed %d records, want %d", len(records), benchmarkRows)
+			}
+			if tt.wantErr != nil && records != nil {
+				t.Errorf("parsed %d records after cancelling, want none", len(records))
+			}
+		})
+	}
+}
+
+func TestParseCSVLogsFittedRows(t *testing.T) {
+	lines := func(n int) []int {
+		var lines []int
+		for i := range n {
+			lines = append(lines, 2*i+3)
+		}
+		return lines
+	}
+
+	tests := []struct {
+		name       string
+		short      []int
+		wantLogged []int
+	}{
+		{name: "none"},
+		{name: "a few", short: lines(3), wantLogged: lines(3)},
+		{
+			name:       "more than are logged",
+			short:      lines(LOGGED_FITTED_ROWS + 4),
+			wantLogged: lines(LOGGED_FITTED_ROWS),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, nil))
+			data := mixedCSV(2*LOGGED_FITTED_ROWS+10, tt.short, nil)
+			opts := ParseOptions{ColumnTolerance: COLUMN_TOLERANCE_TOLERANT}
+
+			result, err := ParseCSV(context.Background(), strings.NewReader(data), opts, logger)
+			if err != nil {
+				t.Fatalf("ParseCSV: %v", err)
+			}
+			if result.Stats.FittedRows != len(tt.short) || result.Stats.BadRows != 0 {
+				t.Errorf(
+					"fitted %d rows and skipped %d, want %d fitted and none skipped",
+					result.Stats.FittedRows,
+					result.Stats.BadRows,
+					len(tt.short),
+				)
+			}
+
+			var logged []int
+			decoder := json.NewDecoder(&buf)
+			for decoder.More() {
+				var entry struct {
+					Msg  string `json:"msg"`
+					Line int    `json:"line"`
+				}
+				if err := decoder.Decode(&entry); err != nil {
+					t.Fatalf("decoding log entry: %v", err)
+				}
+				if strings.Contains(entry.Msg, "wrong number of columns") {
+					logged = append(logged, entry.Line)
+				}
+			}
+			if !slices.Equal(logged, tt.wantLogged) {
+				t.Errorf("logged fitted rows on lines %v, want %v", logged, tt.wantLogged)
+			}
+		})
+	}
+}
+
+func TestParseCSVColumnTolerance(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	header := bytes.SplitAfter(benchmarkCSV(0), []byte("\n"))[0]
+	row := benchmarkRow(0)
+	short := row[:13]
+	long := append(slices.Clone(row), "extra")
+	one := 1
+
+	tests := []struct {
+		name      string
+		row       []string
+		tolerance string
+		skipped   bool
+		wantCount *int
+	}{
+		{name: "default short row", row: short, skipped: true},
+		{name: "strict short row", row: short, tolerance: COLUMN_TOLERANCE_STRICT, skipped: true},
+		{name: "strict long row", row: long, tolerance: COLUMN_TOLERANCE_STRICT, skipped: true},
+		{name: "tolerant short row", row: short, tolerance: COLUMN_TOLERANCE_TOLERANT},
+		{
+			name:      "tolerant long row",
+			row:       long,
+			tolerance: COLUMN_TOLERANCE_TOLERANT,
+			wantCount: &one,
+		},
+		{
+			name:      "tolerant full row",
+			row:       row,
+			tolerance: COLUMN_TOLERANCE_TOLERANT,
+			wantCount: &one,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := string(header) + strings.Join(tt.row, ",") + "\n"
+			opts := ParseOptions{ColumnTolerance: tt.tolerance}
+			r := strings.NewReader(data)
+
+			result, err := ParseCSV(context.Background(), r, opts, logger)
+			if err != nil {
+				t.Fatalf("ParseCSV: %v", err)
+			}
+			records, stats := result.Records, result.Stats
+
+			if tt.skipped {
+				if len(records) != 0 || stats.BadRows != 1 {
+					t.Errorf("got %d records, %d bad rows, want the row skipped",
+						len(records), stats.BadRows)
+				}
+				return
+			}
+			if len(records) != 1 || stats.BadRows != 0 {
+				t.Fatalf("got %d records, %d bad rows, want one record",
+					len(records), stats.BadRows)
+			}
+
+			record := records[0]
+			if record.CaseNumber != row[1] || record.ReportDate.IsZero() {
+				t.Errorf("record %+v does not hold the row's values", record)
+			}
+			if tt.wantCount == nil && record.OffenseCount != nil {
+				t.Errorf("OffenseCount = %d, want nil", *record.OffenseCount)
+			}
+			if tt.wantCount != nil &&
+				(record.OffenseCount == nil || *record.OffenseCount != *tt.wantCount) {
+				t.Errorf("OffenseCount = %v, want %d", record.OffenseCount, *tt.wantCount)
+			}
+		})
+	}
+}
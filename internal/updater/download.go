@@ -0,0 +1,407 @@
+package updater
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lorendsnow/updater/internal/backoff"
+	cfg "github.com/lorendsnow/updater/internal/config"
+	"github.com/lorendsnow/updater/internal/s3"
+)
+
+/*
+ *==================================================================================================
+ * Download Defaults
+ *==================================================================================================
+ */
+
+const DEFAULT_HTTP_TIMEOUT = 30 * time.Second
+
+// RETRY_BASE_DELAY and RETRY_MAX_DELAY bound the backoff between download attempts.
+const RETRY_BASE_DELAY = 1 * time.Second
+const RETRY_MAX_DELAY = 30 * time.Second
+
+// NETWORK_RETRY_BASE_DELAY and NETWORK_RETRY_MAX_DELAY bound the backoff between attempts that
+// failed on DNS resolution or a refused connection. They are variables so tests can shorten them.
+var NETWORK_RETRY_BASE_DELAY = 5 * time.Second
+var NETWORK_RETRY_MAX_DELAY = 2 * time.Minute
+
+// DIAL_TIMEOUT bounds establishing a TCP connection for a download.
+const DIAL_TIMEOUT = 30 * time.Second
+
+// ErrRetryBudgetExhausted is returned by downloads that fail once the cycle's retry budget has
+// been used up.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// GZIP_MAGIC starts every gzip stream.
+const GZIP_MAGIC = "\x1f\x8b"
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// DownloadCSV requests the CSV file at url and returns the response body for the caller to read
+// and close. Urls with the s3 scheme are fetched from S3 with credentials from the environment,
+// and gzipped objects are decompressed, as gzipped HTTP responses are.
+//
+// Failed requests and non-200 responses are retried up to s.Retries times, with exponential backoff
+// between attempts. Network failures that are usually transient, DNS resolution errors and refused
+// connections, are counted separately and retried up to s.NetworkRetries times with a longer
+// backoff, since they tend to last longer than a single bad response. A 429 or 503 response with a
+// Retry-After header is retried after the time it asks for, capped at s.MaxRetryAfter, instead of
+// the backoff. During an update cycle every retry also draws on the cycle's RetryBudget.
+func (s *UpdateService) DownloadCSV(ctx context.Context, url string) (io.ReadCloser, error) {
+	return s.download(ctx, url, s.timeout(url))
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// download requests url with retries as described for DownloadCSV, bounding each attempt by
+// timeout. A zero timeout leaves attempts unbounded.
+func (s *UpdateService) download(
+	ctx context.Context,
+	url string,
+	timeout time.Duration,
+) (io.ReadCloser, error) {
+	var httpAttempts, networkAttempts int
+
+	for {
+		body, err := s.get(ctx, url, timeout)
+		if err == nil {
+			return body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		var delay time.Duration
+		if isNetworkError(err) {
+			if networkAttempts >= s.NetworkRetries {
+				return nil, fmt.Errorf(
+					"download failed after %d network retries: %w",
+					networkAttempts,
+					err,
+				)
+			}
+			delay = backoff.Delay(
+				networkAttempts,
+				NETWORK_RETRY_BASE_DELAY,
+				NETWORK_RETRY_MAX_DELAY,
+			)
+			networkAttempts++
+		} else {
+			if httpAttempts >= s.Retries {
+				return nil, fmt.Errorf("download failed after %d retries: %w", httpAttempts, err)
+			}
+			delay = backoff.Delay(httpAttempts, RETRY_BASE_DELAY, RETRY_MAX_DELAY)
+			if wait, ok := s.retryAfter(err); ok {
+				delay = wait
+			}
+			httpAttempts++
+		}
+
+		if !takeRetry(ctx) {
+			s.Logger.Warn("retry budget exhausted, not retrying", "url", url, "error", err)
+			return nil, fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, err)
+		}
+
+		s.Logger.Warn(
+			"retrying csv download",
+			"url",
+			url,
+			"retries",
+			httpAttempts,
+			"network retries",
+			networkAttempts,
+			"delay",
+			delay,
+			"error",
+			err,
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// get performs a single GET request for url, returning the body if the response status is 200,
+// or fetches the object at url from S3 if it is an s3 url. The request, including reading the
+// body, is bounded by timeout.
+func (s *UpdateService) get(
+	ctx context.Context,
+	url string,
+	timeout time.Duration,
+) (io.ReadCloser, error) {
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	if strings.HasPrefix(url, s3.SCHEME+"://") {
+		body, err := s.getS3(ctx, url)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		return &cancelOnClose{ReadCloser: body, cancel: cancel}, nil
+	}
+
+	ctx, trace := newRequestTrace(ctx, s.HTTPTrace, url, s.Logger)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		trace.log(err)
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		err := &statusError{Status: resp.Status, StatusCode: resp.StatusCode}
+		err.RetryAfter, err.HasRetryAfter = parseRetryAfter(
+			resp.Header.Get("Retry-After"),
+			time.Now(),
+		)
+		trace.log(err)
+		return nil, err
+	}
+
+	return &cancelOnClose{ReadCloser: resp.Body, cancel: cancel, trace: trace}, nil
+}
+
+// getS3 fetches the object at the s3 url, decompressing it if it is gzipped.
+func (s *UpdateService) getS3(ctx context.Context, url string) (io.ReadCloser, error) {
+	bucket, key, err := s3.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s3.NewClient(ctx, s.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := client.GetObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Whether or not an object is stored with Content-Encoding: gzip, the SDK returns it as
+	// stored, so check the content itself for the gzip header.
+	buffered := bufio.NewReader(body)
+	if magic, err := buffered.Peek(len(GZIP_MAGIC)); err != nil || string(magic) != GZIP_MAGIC {
+		return &readCloser{Reader: buffered, Closer: body}, nil
+	}
+
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("decompressing %s: %w", url, err)
+	}
+
+	return &readCloser{Reader: gz, Closer: body}, nil
+}
+
+// timeout returns the timeout for downloading url: its source's timeout if it has one, otherwise
+// HTTPTimeout.
+func (s *UpdateService) timeout(url string) time.Duration {
+	if source := s.source(url); source.Timeout != "" {
+		if timeout, err := time.ParseDuration(source.Timeout); err == nil {
+			return timeout
+		}
+	}
+
+	return s.HTTPTimeout
+}
+
+// cancelOnClose is a response body that cancels its request's context once closed, so the timeout
+// covers reading the body, and logs the request's timings if it is traced.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	trace  *requestTrace
+}
+
+// Close closes the body, cancels the request's context and logs the request's trace.
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	c.trace.log(nil)
+
+	return err
+}
+
+// retryBudget counts the retries left for the downloads of a cycle.
+type retryBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+// retryBudgetKey is the context key for a cycle's retryBudget.
+type retryBudgetKey struct{}
+
+// withRetryBudget returns a context carrying a budget of retries for the downloads made with it.
+// A budget of zero or less is unlimited and leaves ctx as it is.
+func withRetryBudget(ctx context.Context, retries int) context.Context {
+	if retries <= 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, retryBudgetKey{}, &retryBudget{remaining: retries})
+}
+
+// takeRetry uses up one retry from ctx's retry budget, reporting false if none are left. Contexts
+// without a budget always allow the retry.
+func takeRetry(ctx context.Context) bool {
+	budget, ok := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	if !ok {
+		return true
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	if budget.remaining <= 0 {
+		return false
+	}
+	budget.remaining--
+
+	return true
+}
+
+// readCloser reads from Reader, which wraps a body, and closes the body through Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// newHTTPClient builds the client used for downloads, with a transport configured to reuse
+// connections across the many files fetched each cycle rather than relying on the defaults, and
+// refusing TLS versions older than the configured minimum. The client has no timeout of its own,
+// since each request applies its url's timeout.
+func newHTTPClient(config *cfg.Config, logger *slog.Logger) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   DIAL_TIMEOUT,
+		KeepAlive: optionalDuration(config.HTTP.KeepAlive, "http.keep-alive", logger),
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.MaxIdleConns = config.HTTP.MaxIdleConns
+	transport.MaxIdleConnsPerHost = config.HTTP.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = optionalDuration(
+		config.HTTP.IdleConnTimeout,
+		"http.idle-conn-timeout",
+		logger,
+	)
+	transport.DisableKeepAlives = config.HTTP.DisableKeepAlives
+
+	minVersion, err := config.TLSVersion()
+	if err != nil {
+		logger.Warn("invalid http.min-tls-version, using TLS 1.2", "error", err)
+		minVersion = tls.VersionTLS12
+	}
+	transport.TLSClientConfig = &tls.Config{MinVersion: minVersion}
+
+	return &http.Client{Transport: transport}
+}
+
+// statusError is the error for a response whose status isn't 200, carrying the wait asked for by
+// its Retry-After header, if it had a valid one.
+type statusError struct {
+	Status     string
+	StatusCode int
+
+	RetryAfter    time.Duration
+	HasRetryAfter bool
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status: " + e.Status
+}
+
+// retryAfter returns how long to wait before retrying after err: the Retry-After of a 429 or 503
+// response, capped at MaxRetryAfter. It reports false if err isn't such a response, the response
+// had no valid Retry-After, or MaxRetryAfter is zero.
+func (s *UpdateService) retryAfter(err error) (time.Duration, bool) {
+	var statusErr *statusError
+	if s.MaxRetryAfter <= 0 || !errors.As(err, &statusErr) || !statusErr.HasRetryAfter {
+		return 0, false
+	}
+
+	switch statusErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return min(statusErr.RetryAfter, s.MaxRetryAfter), true
+	default:
+		return 0, false
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, in either of its forms: a number of
+// seconds, or an HTTP date, which is taken relative to now. Dates already past wait for zero. It
+// reports false if the header is empty or invalid.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		// Guard against overflowing time.Duration on absurd values.
+		return time.Duration(min(seconds, int64(math.MaxInt64/time.Second))) * time.Second, true
+	}
+
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(date.Sub(now), 0), true
+}
+
+// isNetworkError reports whether err is a DNS resolution failure or a refused connection, which
+// usually clear up on their own.
+func isNetworkError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,408 @@
+package updater
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	cfg "github.com/lorendsnow/updater/internal/config"
+)
+
+// flakyDialer fails its first failures dials with err, as a resolver or server that isn't up yet
+// would, and then dials normally.
+type flakyDialer struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	dials    int
+}
+
+func (d *flakyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dials++
+	fail := d.dials <= d.failures
+	d.mu.Unlock()
+
+	if fail {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: d.err}
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, addr)
+}
+
+func TestDownloadCSVRetriesNetworkErrors(t *testing.T) {
+	baseDelay, maxDelay := NETWORK_RETRY_BASE_DELAY, NETWORK_RETRY_MAX_DELAY
+	NETWORK_RETRY_BASE_DELAY, NETWORK_RETRY_MAX_DELAY = time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { NETWORK_RETRY_BASE_DELAY, NETWORK_RETRY_MAX_DELAY = baseDelay, maxDelay })
+
+	dnsErr := &net.DNSError{Err: "no such host", Name: "data.example.com", IsNotFound: true}
+	refused := os.NewSyscallError("connect", syscall.ECONNREFUSED)
+
+	tests := []struct {
+		name           string
+		err            error
+		failures       int
+		networkRetries int
+		wantErr        bool
+	}{
+		{name: "no failures", err: dnsErr, failures: 0, networkRetries: 0},
+		{name: "dns fails then succeeds", err: dnsErr, failures: 2, networkRetries: 2},
+		{name: "refused then succeeds", err: refused, failures: 1, networkRetries: 2},
+		{name: "dns keeps failing", err: dnsErr, failures: 3, networkRetries: 2, wantErr: true},
+		{name: "no network retries", err: dnsErr, failures: 1, networkRetries: 0, wantErr: true},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Address,CaseNumber\n")
+	}))
+	defer server.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &flakyDialer{failures: tt.failures, err: tt.err}
+			transport := &http.Transport{DialContext: dialer.DialContext}
+			defer transport.CloseIdleConnections()
+			s := &UpdateService{
+				Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+				HTTPClient:     &http.Client{Transport: transport},
+				NetworkRetries: tt.networkRetries,
+			}
+
+			body, err := s.DownloadCSV(context.Background(), server.URL+"/offenses.csv")
+			if tt.wantErr {
+				if err == nil {
+					body.Close()
+					t.Fatal("DownloadCSV succeeded, want an error")
+				}
+				if !isNetworkError(err) {
+					t.Errorf("DownloadCSV error = %v, want the network error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DownloadCSV: %v", err)
+			}
+			body.Close()
+
+			// Network failures are retried without using up the HTTP retries, which are zero.
+			if dialer.dials != tt.failures+1 {
+				t.Errorf("dialed %d times, want %d", dialer.dials, tt.failures+1)
+			}
+		})
+	}
+}
+
+func TestDownloadCSVSourceTimeout(t *testing.T) {
+	// The server takes 100ms to respond, and a further 100ms to finish the body.
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("Address\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("1 MAIN ST\n"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	url := server.URL + "/offenses.csv"
+
+	tests := []struct {
+		name          string
+		globalTimeout time.Duration
+		sourceTimeout string
+		wantErr       bool
+	}{
+		{name: "no timeouts"},
+		{name: "global timeout long enough", globalTimeout: time.Second},
+		{name: "global timeout too short", globalTimeout: 50 * time.Millisecond, wantErr: true},
+		{
+			name:          "global timeout too short for the body",
+			globalTimeout: 150 * time.Millisecond,
+			wantErr:       true,
+		},
+		{
+			name:          "source timeout overrides a short global timeout",
+			globalTimeout: 50 * time.Millisecond,
+			sourceTimeout: "1s",
+		},
+		{
+			name:          "source timeout overrides a long global timeout",
+			globalTimeout: time.Second,
+			sourceTimeout: "50ms",
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &UpdateService{
+				HTTPClient:  server.Client(),
+				HTTPTimeout: tt.globalTimeout,
+				Sources:     map[string]cfg.SourceConfig{},
+				Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+			if tt.sourceTimeout != "" {
+				s.Sources[url] = cfg.SourceConfig{URL: url, Timeout: tt.sourceTimeout}
+			}
+
+			body, err := s.DownloadCSV(context.Background(), url)
+			if err == nil {
+				_, err = io.ReadAll(body)
+				body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("download error = %v, want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// contextTransport answers every request with status and records the request's context.
+type contextTransport struct {
+	status int
+	ctx    context.Context
+}
+
+func (c *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.ctx = req.Context()
+
+	return &http.Response{
+		Status:     http.StatusText(c.status),
+		StatusCode: c.status,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("Address\n")),
+		Request:    req,
+	}, nil
+}
+
+func TestGetCancelsRequestContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		status  int
+		wantErr bool
+	}{
+		{name: "no timeout", status: http.StatusOK},
+		{name: "timeout", timeout: time.Minute, status: http.StatusOK},
+		{name: "status error", status: http.StatusNotFound, wantErr: true},
+		{
+			name:    "status error with timeout",
+			timeout: time.Minute,
+			status:  http.StatusNotFound,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &contextTransport{status: tt.status}
+			s := &UpdateService{
+				HTTPClient: &http.Client{Transport: transport},
+				Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+
+			url := "https://example.com/offenses.csv"
+			body, err := s.get(context.Background(), url, tt.timeout)
+			if tt.wantErr {
+				if err == nil {
+					body.Close()
+					t.Fatal("get succeeded, want a status error")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("get: %v", err)
+				}
+				if err := transport.ctx.Err(); err != nil {
+					t.Fatalf("request context done before the body was closed: %v", err)
+				}
+				body.Close()
+			}
+
+			if err := transport.ctx.Err(); !errors.Is(err, context.Canceled) {
+				t.Errorf("request context error = %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClientMinTLSVersion(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// The server only speaks TLS 1.2, so clients requiring 1.3 must refuse it.
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(handler))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	// The refused handshakes are expected, so the server doesn't log them.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	tests := []struct {
+		name       string
+		minVersion string
+		wantErr    bool
+	}{
+		{name: "default", minVersion: ""},
+		{name: "tls 1.2", minVersion: "1.2"},
+		{name: "tls 1.3", minVersion: "1.3", wantErr: true},
+		{name: "invalid falls back to tls 1.2", minVersion: "1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config cfg.Config
+			config.HTTP.MinTLSVersion = tt.minVersion
+
+			client := newHTTPClient(&config, logger)
+			transport := client.Transport.(*http.Transport)
+			defer transport.CloseIdleConnections()
+			transport.TLSClientConfig.RootCAs = roots
+
+			resp, err := client.Get(server.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("Get succeeded, want a TLS version error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			resp.Body.Close()
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "missing", header: ""},
+		{name: "seconds", header: "120", want: 2 * time.Minute, wantOK: true},
+		{name: "padded seconds", header: " 5 ", want: 5 * time.Second, wantOK: true},
+		{name: "zero seconds", header: "0", wantOK: true},
+		{name: "negative seconds", header: "-1"},
+		{
+			name:   "huge seconds",
+			header: "99999999999999999",
+			want:   time.Duration(math.MaxInt64/time.Second) * time.Second,
+			wantOK: true,
+		},
+		{
+			name:   "future date",
+			header: now.Add(90 * time.Second).Format(http.TimeFormat),
+			want:   90 * time.Second,
+			wantOK: true,
+		},
+		{
+			name:   "past date",
+			header: now.Add(-time.Hour).Format(http.TimeFormat),
+			wantOK: true,
+		},
+		{name: "invalid", header: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.header, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf(
+					"parseRetryAfter(%q) = %v, %t, want %v, %t",
+					tt.header,
+					got,
+					ok,
+					tt.want,
+					tt.wantOK,
+				)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	rateLimited := func(code int, wait time.Duration) error {
+		return fmt.Errorf(
+			"downloading: %w",
+			&statusError{StatusCode: code, RetryAfter: wait, HasRetryAfter: true},
+		)
+	}
+
+	tests := []struct {
+		name          string
+		maxRetryAfter time.Duration
+		err           error
+		want          time.Duration
+		wantOK        bool
+	}{
+		{
+			name:          "too many requests",
+			maxRetryAfter: time.Minute,
+			err:           rateLimited(http.StatusTooManyRequests, 10*time.Second),
+			want:          10 * time.Second,
+			wantOK:        true,
+		},
+		{
+			name:          "unavailable, capped",
+			maxRetryAfter: time.Minute,
+			err:           rateLimited(http.StatusServiceUnavailable, time.Hour),
+			want:          time.Minute,
+			wantOK:        true,
+		},
+		{
+			name:          "other status",
+			maxRetryAfter: time.Minute,
+			err:           rateLimited(http.StatusInternalServerError, 10*time.Second),
+		},
+		{
+			name:          "no header",
+			maxRetryAfter: time.Minute,
+			err:           &statusError{StatusCode: http.StatusTooManyRequests},
+		},
+		{
+			name: "header ignored",
+			err:  rateLimited(http.StatusTooManyRequests, 10*time.Second),
+		},
+		{
+			name:          "not a status error",
+			maxRetryAfter: time.Minute,
+			err:           io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &UpdateService{MaxRetryAfter: tt.maxRetryAfter}
+
+			got, ok := s.retryAfter(tt.err)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("retryAfter() = %v, %t, want %v, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package updater
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+ *==================================================================================================
+ * Source Encodings
+ *==================================================================================================
+ */
+
+// ENCODING_UTF8, ENCODING_LATIN1 and ENCODING_WINDOWS_1252 are the character encodings a source
+// may be published in. Empty is UTF-8.
+const ENCODING_UTF8 = "utf-8"
+const ENCODING_LATIN1 = "latin1"
+const ENCODING_WINDOWS_1252 = "windows-1252"
+
+// UTF8_BOM is the byte order mark some tools write at the start of UTF-8 files.
+const UTF8_BOM = "\xef\xbb\xbf"
+
+// WINDOWS_1252_HIGH maps the bytes 0x80 to 0x9f of Windows-1252, where it differs from Latin-1, to
+// their characters. The five bytes Windows-1252 leaves undefined map to the same code points as in
+// Latin-1.
+var WINDOWS_1252_HIGH = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// decodeReader returns a reader of r's contents converted from encoding to UTF-8. UTF-8 input is
+// passed through with any byte order mark removed, so it can't end up in the first field.
+func decodeReader(r io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(encoding) {
+	case ENCODING_LATIN1:
+		return &singleByteReader{r: r, buf: make([]byte, 4096)}
+	case ENCODING_WINDOWS_1252:
+		return &singleByteReader{r: r, buf: make([]byte, 4096), high: &WINDOWS_1252_HIGH}
+	default:
+		buffered := bufio.NewReader(r)
+		if bom, err := buffered.Peek(len(UTF8_BOM)); err == nil && string(bom) == UTF8_BOM {
+			buffered.Discard(len(UTF8_BOM))
+		}
+		return buffered
+	}
+}
+
+// singleByteReader converts text in a single-byte encoding to UTF-8. Every byte is the code point
+// of the same value, as in Latin-1, except the bytes 0x80 to 0x9f when high is set.
+type singleByteReader struct {
+	r    io.Reader
+	high *[32]rune
+
+	buf     []byte // bytes read from r
+	out     []byte // buf converted to UTF-8
+	pending []byte // the part of out not yet returned
+	err     error  // the error from r, returned once pending is empty
+}
+
+func (d *singleByteReader) Read(p []byte) (int, error) {
+	if len(d.pending) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+
+		n, err := d.r.Read(d.buf)
+		d.out = d.out[:0]
+		for _, b := range d.buf[:n] {
+			d.out = utf8.AppendRune(d.out, d.decode(b))
+		}
+		d.pending = d.out
+		d.err = err
+
+		if len(d.pending) == 0 {
+			return 0, d.err
+		}
+	}
+
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+
+	return n, nil
+}
+
+// decode returns the character encoded by b.
+func (d *singleByteReader) decode(b byte) rune {
+	if d.high != nil && b >= 0x80 && b <= 0x9f {
+		return d.high[b-0x80]
+	}
+
+	return rune(b)
+}
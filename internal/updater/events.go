@@ -0,0 +1,166 @@
+package updater
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+/*
+ *==================================================================================================
+ * Event Constants
+ *==================================================================================================
+ */
+
+// SUBSCRIBER_BUFFER_SIZE is the number of events buffered for each subscriber. Events sent to a
+// subscriber whose buffer is full are dropped rather than blocking the update loop.
+const SUBSCRIBER_BUFFER_SIZE = 8
+
+// EVENT_TABLE_CHANGED is sent when an update makes a different table active. EVENT_CYCLE_SUCCEEDED
+// and EVENT_CYCLE_FAILED are sent at the end of every update cycle, whether or not it swapped
+// tables, so monitoring can tell a cycle that ran without changing anything from one that didn't
+// run at all.
+const EVENT_TABLE_CHANGED = "table_changed"
+const EVENT_CYCLE_SUCCEEDED = "cycle_succeeded"
+const EVENT_CYCLE_FAILED = "cycle_failed"
+
+// EVENT_PARSE_ERROR_RATE_EXCEEDED is sent, ahead of EVENT_CYCLE_FAILED, when a cycle is aborted
+// because too many rows failed to parse, so alerting can single out upstream data that is likely
+// broken from downloads that failed.
+const EVENT_PARSE_ERROR_RATE_EXCEEDED = "parse_error_rate_exceeded"
+
+// EVENT_ALL_URLS_FAILED is sent, ahead of EVENT_CYCLE_FAILED, when a cycle is aborted because every
+// CSV url failed to download, leaving the previous table active.
+const EVENT_ALL_URLS_FAILED = "all_urls_failed"
+
+/*
+ *==================================================================================================
+ * TableChangeEvent Struct
+ *==================================================================================================
+ */
+
+// TableChangeEvent is sent to subscribers each time an update makes a different table active, and
+// at the end of every update cycle. Type tells them apart: table changes set Table, UpdatedAt and
+// Records, successful cycles set Stats, failed cycles set Error, cycles aborted for their parse
+// error rate set Error and ParseErrors, and cycles in which every url failed set Error.
+type TableChangeEvent struct {
+	Type        string               `json:"type"`
+	Table       string               `json:"table,omitempty"`
+	UpdatedAt   time.Time            `json:"updated_at,omitzero"`
+	Records     int                  `json:"records,omitempty"`
+	Stats       *CycleStats          `json:"stats,omitempty"`
+	Error       string               `json:"error,omitempty"`
+	ParseErrors *ParseErrorRateError `json:"parse_errors,omitempty"`
+}
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// Subscribe returns a channel that receives a TableChangeEvent each time the active table changes
+// and each time an update cycle ends. If replay is true and a table change has already happened,
+// the most recent table change event is delivered immediately, so subscribers joining late don't
+// have to wait for the next swap to learn the active table.
+//
+// The channel is closed when Run returns, after the last event has been sent, so subscribers can
+// tell a shut down service (closed channel) apart from one that simply hasn't swapped tables in a
+// while. Subscribing after Run has returned yields an already closed channel.
+func (s *UpdateService) Subscribe(replay bool) <-chan TableChangeEvent {
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+
+	ch := make(chan TableChangeEvent, SUBSCRIBER_BUFFER_SIZE)
+	if s.subscribersClosed {
+		close(ch)
+		return ch
+	}
+
+	if replay && s.lastEvent != nil {
+		ch <- *s.lastEvent
+	}
+	s.subscribers = append(s.subscribers, ch)
+
+	return ch
+}
+
+// Unsubscribe stops sending events to ch, a channel returned by Subscribe, and closes it so any
+// goroutine ranging over it exits. Unsubscribing a channel that was already removed, or after Run
+// has returned, does nothing.
+func (s *UpdateService) Unsubscribe(ch <-chan TableChangeEvent) {
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+
+	for i, subscriber := range s.subscribers {
+		if (<-chan TableChangeEvent)(subscriber) == ch {
+			close(subscriber)
+			s.subscribers = slices.Delete(s.subscribers, i, i+1)
+			return
+		}
+	}
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// publish sends event to every subscriber, dropping it for subscribers whose buffer is full. Table
+// change events are kept for replay.
+func (s *UpdateService) publish(event TableChangeEvent) {
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+
+	if event.Type == EVENT_TABLE_CHANGED {
+		s.lastEvent = &event
+	}
+	for _, ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+			s.Logger.Warn("subscriber buffer full, dropping event", "type", event.Type)
+		}
+	}
+}
+
+// publishOutcome sends the outcome of an update cycle to every subscriber.
+func (s *UpdateService) publishOutcome(stats CycleStats, err error) {
+	var rateErr *ParseErrorRateError
+	if errors.As(err, &rateErr) {
+		s.publish(TableChangeEvent{
+			Type:        EVENT_PARSE_ERROR_RATE_EXCEEDED,
+			Error:       err.Error(),
+			ParseErrors: rateErr,
+		})
+	}
+
+	if errors.Is(err, ErrAllURLsFailed) {
+		s.publish(TableChangeEvent{Type: EVENT_ALL_URLS_FAILED, Error: err.Error()})
+	}
+
+	if err != nil {
+		s.publish(TableChangeEvent{Type: EVENT_CYCLE_FAILED, Error: err.Error()})
+		return
+	}
+
+	s.publish(TableChangeEvent{Type: EVENT_CYCLE_SUCCEEDED, Stats: &stats})
+}
+
+// closeSubscribers closes every subscriber channel. Events published afterwards are discarded, and
+// later subscribers receive a closed channel.
+func (s *UpdateService) closeSubscribers() {
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+
+	if s.subscribersClosed {
+		return
+	}
+
+	for _, ch := range s.subscribers {
+		close(ch)
+	}
+	s.subscribers = nil
+	s.subscribersClosed = true
+}
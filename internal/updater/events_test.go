@@ -0,0 +1,164 @@
+package updater
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscribersClosedAfterLastEvent(t *testing.T) {
+	s, _ := newMockService(t)
+	first := s.Subscribe(false)
+	second := s.Subscribe(false)
+
+	events := []TableChangeEvent{
+		{Table: "blue", UpdatedAt: time.Now(), Records: 3},
+		{Table: "green", UpdatedAt: time.Now(), Records: 4},
+	}
+	for _, event := range events {
+		s.publish(event)
+	}
+	s.closeSubscribers()
+
+	// Published after shutdown, so discarded rather than sent on a closed channel.
+	s.publish(TableChangeEvent{Table: "blue"})
+
+	for i, ch := range []<-chan TableChangeEvent{first, second} {
+		for _, want := range events {
+			got, ok := <-ch
+			if !ok {
+				t.Fatalf("subscriber %d closed before receiving %+v", i, want)
+			}
+			if got != want {
+				t.Errorf("subscriber %d received %+v, want %+v", i, got, want)
+			}
+		}
+		if event, ok := <-ch; ok {
+			t.Errorf("subscriber %d received %+v after shutdown, want a closed channel", i, event)
+		}
+	}
+
+	if event, ok := <-s.Subscribe(false); ok {
+		t.Errorf("late subscriber received %+v, want a closed channel", event)
+	}
+}
+
+func TestRunClosesSubscribers(t *testing.T) {
+	s := newTestService(t, NewMemoryStore())
+	s.CheckEvery = "1h"
+	events := s.Subscribe(false)
+
+	// Run shuts down once the tables are created and the update loop is waiting.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := s.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Errorf("received %+v, want a closed channel", event)
+		}
+	case <-time.After(time.Second):
+		t.Error("subscriber channel not closed when Run returned")
+	}
+}
+
+func TestUnsubscribeWhilePublishing(t *testing.T) {
+	s, _ := newMockService(t)
+
+	stop := make(chan struct{})
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.publish(TableChangeEvent{Table: "blue"})
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ch := s.Subscribe(false)
+			<-ch
+			s.Unsubscribe(ch)
+
+			// The channel is closed, so this ends once the buffered events are drained, and
+			// publishing to it again would have panicked.
+			for range ch {
+			}
+			s.Unsubscribe(ch)
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-published
+
+	s.subscribersMu.Lock()
+	defer s.subscribersMu.Unlock()
+	if len(s.subscribers) != 0 {
+		t.Errorf("%d subscribers left after every channel unsubscribed", len(s.subscribers))
+	}
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	event := TableChangeEvent{
+		Type:      EVENT_TABLE_CHANGED,
+		Table:     "green",
+		UpdatedAt: time.Now(),
+		Records:   3,
+	}
+
+	tests := []struct {
+		name      string
+		replay    bool
+		published bool
+		// outcome publishes a cycle outcome after any table change, which isn't replayed.
+		outcome bool
+		want    bool
+	}{
+		{name: "replay", replay: true, published: true, want: true},
+		{name: "replay past an outcome", replay: true, published: true, outcome: true, want: true},
+		{name: "no replay", replay: false, published: true, want: false},
+		{name: "nothing to replay", replay: true, published: false, want: false},
+		{name: "only an outcome", replay: true, outcome: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newMockService(t)
+			if tt.published {
+				s.publish(event)
+			}
+			if tt.outcome {
+				s.publishOutcome(CycleStats{Table: "green"}, nil)
+			}
+
+			ch := s.Subscribe(tt.replay)
+			select {
+			case got := <-ch:
+				if !tt.want {
+					t.Fatalf("received %+v, want nothing", got)
+				}
+				if got != event {
+					t.Errorf("received %+v, want %+v", got, event)
+				}
+			default:
+				if tt.want {
+					t.Fatal("received nothing, want the last event")
+				}
+			}
+		})
+	}
+}
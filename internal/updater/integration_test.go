@@ -0,0 +1,441 @@
+//go:build integration
+
+package updater
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+
+	cfg "github.com/lorendsnow/updater/internal/config"
+)
+
+// These tests run full update cycles against a MySQL server in a Docker container. They need a
+// running Docker daemon, and only build with the integration tag:
+//
+//	go test -tags integration ./internal/updater/
+
+// MYSQL_IMAGE and MYSQL_TAG name the image the integration tests run MySQL from.
+const MYSQL_IMAGE = "mysql"
+const MYSQL_TAG = "8.0"
+
+// MYSQL_PASSWORD and MYSQL_DATABASE are the root password and database of the test server.
+const MYSQL_PASSWORD = "secret"
+const MYSQL_DATABASE = "updater"
+
+// MYSQL_START_TIMEOUT bounds how long to wait for the test server to accept connections.
+const MYSQL_START_TIMEOUT = 2 * time.Minute
+
+// testLogger discards everything logged by the integration tests.
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// startMySQL runs a MySQL server in a container for the duration of the test, returning the
+// configuration to connect to it. The container is removed when the test finishes.
+func startMySQL(t *testing.T) *cfg.Config {
+	t.Helper()
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("connecting to docker: %v", err)
+	}
+	pool.MaxWait = MYSQL_START_TIMEOUT
+
+	resource, err := pool.RunWithOptions(
+		&dockertest.RunOptions{
+			Repository: MYSQL_IMAGE,
+			Tag:        MYSQL_TAG,
+			Env: []string{
+				"MYSQL_ROOT_PASSWORD=" + MYSQL_PASSWORD,
+				"MYSQL_DATABASE=" + MYSQL_DATABASE,
+			},
+			// WRITE_MODE_LOAD_DATA needs local_infile enabled on the server.
+			Cmd: []string{"--local-infile=1"},
+		},
+		func(hc *docker.HostConfig) {
+			hc.AutoRemove = true
+			hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+		},
+	)
+	if err != nil {
+		t.Fatalf("starting mysql: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Purge(resource); err != nil {
+			t.Logf("removing mysql container: %v", err)
+		}
+	})
+
+	var config cfg.Config
+	config.Database.Host = "localhost"
+	fmt.Sscan(resource.GetPort("3306/tcp"), &config.Database.Port)
+	config.Database.Username = "root"
+	config.Database.Password = MYSQL_PASSWORD
+	config.Database.Name = MYSQL_DATABASE
+
+	// The server restarts once while initializing, so wait until it accepts connections.
+	err = pool.Retry(func() error {
+		db, err := OpenDatabase(context.Background(), &config, testLogger)
+		if err != nil {
+			return err
+		}
+		return db.Close()
+	})
+	if err != nil {
+		t.Fatalf("waiting for mysql: %v", err)
+	}
+
+	return &config
+}
+
+// createDatabase creates the database name on the server configured by config, returning the
+// configuration to connect to it.
+func createDatabase(t *testing.T, config *cfg.Config, name string) *cfg.Config {
+	t.Helper()
+
+	db, err := OpenDatabase(context.Background(), config, testLogger)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("CREATE DATABASE `%s`", name)); err != nil {
+		t.Fatalf("creating database %s: %v", name, err)
+	}
+
+	created := *config
+	created.Database.Name = name
+
+	return &created
+}
+
+// serveRows serves CSV data holding rows valid rows, starting from row first, for the duration of
+// the test, returning its url.
+func serveRows(t *testing.T, first, rows int) string {
+	t.Helper()
+
+	var b strings.Builder
+	b.Write(bytes.SplitAfter(benchmarkCSV(0), []byte("\n"))[0])
+	for i := first; i < first+rows; i++ {
+		b.WriteString(strings.Join(benchmarkRow(i), ",") + "\n")
+	}
+
+	return serveCSV(t, b.String())
+}
+
+// newMySQLService returns an UpdateService downloading urls into the database configured by
+// config, writing with writeMode, with its tables created.
+func newMySQLService(
+	t *testing.T,
+	config *cfg.Config,
+	writeMode string,
+	urls ...string,
+) (*UpdateService, *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+
+	db, err := OpenDatabase(ctx, config, testLogger)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s := &UpdateService{
+		CSVUrls:           urls,
+		BlueTable:         &Table{Name: "blue"},
+		GreenTable:        &Table{Name: "green"},
+		MetadataTable:     "metadata",
+		Logger:            testLogger,
+		HTTPClient:        http.DefaultClient,
+		Columns:           DEFAULT_COLUMNS,
+		WriteMode:         writeMode,
+		EmptyOffenseCount: EMPTY_COUNT_NULL,
+		MaxParseErrorRate: 1,
+	}
+	s.UseDatabase(db)
+	if err := s.Store.EnsureSchema(ctx, s.BlueTable.Name, s.GreenTable.Name); err != nil {
+		t.Fatalf("creating tables: %v", err)
+	}
+
+	return s, db
+}
+
+// caseNumbers returns the case numbers stored in table, in order.
+func caseNumbers(t *testing.T, db *sql.DB, table string) []string {
+	t.Helper()
+
+	query := fmt.Sprintf("SELECT `CaseNumber` FROM `%s` ORDER BY `CaseNumber`", table)
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("querying %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	var cases []string
+	for rows.Next() {
+		var caseNumber string
+		if err := rows.Scan(&caseNumber); err != nil {
+			t.Fatalf("scanning %s: %v", table, err)
+		}
+		cases = append(cases, caseNumber)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("reading %s: %v", table, err)
+	}
+
+	return cases
+}
+
+func TestMySQLRunCycle(t *testing.T) {
+	config := startMySQL(t)
+
+	tests := []struct {
+		name      string
+		database  string
+		writeMode string
+		urls      int
+	}{
+		{name: "insert", database: "insert_one", writeMode: WRITE_MODE_INSERT, urls: 1},
+		{
+			name:      "load data",
+			database:  "load_data_one",
+			writeMode: WRITE_MODE_LOAD_DATA,
+			urls:      1,
+		},
+		{name: "insert two urls", database: "insert_two", writeMode: WRITE_MODE_INSERT, urls: 2},
+		{
+			name:      "load data two urls",
+			database:  "load_data_two",
+			writeMode: WRITE_MODE_LOAD_DATA,
+			urls:      2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := createDatabase(t, config, tt.database)
+
+			// Each url serves three rows of its own.
+			var urls, want []string
+			for i := range tt.urls {
+				urls = append(urls, serveRows(t, i*3, 3))
+				for row := i * 3; row < i*3+3; row++ {
+					want = append(want, benchmarkRow(row)[1])
+				}
+			}
+			s, db := newMySQLService(t, config, tt.writeMode, urls...)
+			ctx := context.Background()
+
+			// Two cycles, so both of the blue/green tables are written and activated in turn.
+			var tables []string
+			for cycle := range 2 {
+				stats, err := s.runCycle(ctx)
+				if err != nil {
+					t.Fatalf("cycle %d: %v", cycle, err)
+				}
+				if stats.Records != len(want) {
+					t.Errorf(
+						"cycle %d: stats.Records = %d, want %d",
+						cycle,
+						stats.Records,
+						len(want),
+					)
+				}
+
+				active := s.LastUpdatedTable()
+				if active != stats.Table {
+					t.Errorf("cycle %d: active table = %s, want %s", cycle, active, stats.Table)
+				}
+				if got := caseNumbers(t, db, active); !slices.Equal(got, want) {
+					t.Errorf("cycle %d: %s holds %v, want %v", cycle, active, got, want)
+				}
+				tables = append(tables, active)
+			}
+
+			if tables[0] == tables[1] {
+				t.Errorf("both cycles activated %s", tables[0])
+			}
+
+			// A fresh service reads the same active table from the metadata table.
+			fresh, _ := newMySQLService(t, config, tt.writeMode)
+			if err := fresh.LoadMetadata(ctx); err != nil {
+				t.Fatalf("LoadMetadata: %v", err)
+			}
+			if got := fresh.LastUpdatedTable(); got != tables[1] {
+				t.Errorf("active table after LoadMetadata = %s, want %s", got, tables[1])
+			}
+		})
+	}
+}
+
+func TestMySQLEmptyStrings(t *testing.T) {
+	config := startMySQL(t)
+
+	tests := []struct {
+		name         string
+		database     string
+		writeMode    string
+		emptyStrings string
+		wantNull     int
+	}{
+		{
+			name:         "insert keep",
+			database:     "insert_keep",
+			writeMode:    WRITE_MODE_INSERT,
+			emptyStrings: EMPTY_STRING_KEEP,
+		},
+		{
+			name:         "insert null",
+			database:     "insert_null",
+			writeMode:    WRITE_MODE_INSERT,
+			emptyStrings: EMPTY_STRING_NULL,
+			wantNull:     1,
+		},
+		{
+			name:         "load data keep",
+			database:     "load_data_keep",
+			writeMode:    WRITE_MODE_LOAD_DATA,
+			emptyStrings: EMPTY_STRING_KEEP,
+		},
+		{
+			name:         "load data null",
+			database:     "load_data_null",
+			writeMode:    WRITE_MODE_LOAD_DATA,
+			emptyStrings: EMPTY_STRING_NULL,
+			wantNull:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := createDatabase(t, config, tt.database)
+			config.Database.EmptyStrings = tt.emptyStrings
+			ctx := context.Background()
+
+			columns, err := ColumnsFromConfig(config)
+			if err != nil {
+				t.Fatalf("ColumnsFromConfig: %v", err)
+			}
+			db, err := OpenDatabase(ctx, config, testLogger)
+			if err != nil {
+				t.Fatalf("opening database: %v", err)
+			}
+			defer db.Close()
+
+			store := &MySQLStore{
+				Db:            db,
+				MetadataTable: "metadata",
+				Columns:       columns,
+				WriteMode:     tt.writeMode,
+				EmptyStrings:  tt.emptyStrings,
+				Logger:        testLogger,
+			}
+			if err := store.EnsureSchema(ctx, "blue"); err != nil {
+				t.Fatalf("EnsureSchema: %v", err)
+			}
+
+			// One record with an address and one without.
+			records := []Record{
+				{CaseNumber: "20-1", Address: "1 MAIN ST"},
+				{CaseNumber: "20-2"},
+			}
+			for i := range records {
+				records[i].OccurDateTime = DEFAULT_DATE
+				records[i].ReportDate = DEFAULT_DATE
+			}
+			if err := store.WriteRecords(ctx, "blue", records); err != nil {
+				t.Fatalf("WriteRecords: %v", err)
+			}
+
+			var nulls, empties int
+			err = db.QueryRow(
+				"SELECT SUM(`Address` IS NULL), SUM(`Address` = '') FROM `blue`",
+			).Scan(&nulls, &empties)
+			if err != nil {
+				t.Fatalf("counting empty addresses: %v", err)
+			}
+			if nulls != tt.wantNull || empties != 1-tt.wantNull {
+				t.Errorf(
+					"%d NULL and %d empty addresses, want %d NULL",
+					nulls,
+					empties,
+					tt.wantNull,
+				)
+			}
+		})
+	}
+}
+
+func TestMySQLMultibyteValues(t *testing.T) {
+	config := startMySQL(t)
+
+	tests := []struct {
+		name      string
+		database  string
+		writeMode string
+	}{
+		{name: "insert", database: "insert_multibyte", writeMode: WRITE_MODE_INSERT},
+		{name: "load data", database: "load_data_multibyte", writeMode: WRITE_MODE_LOAD_DATA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := createDatabase(t, config, tt.database)
+			config.Database.Charset = "utf8mb4"
+			ctx := context.Background()
+
+			db, err := OpenDatabase(ctx, config, testLogger)
+			if err != nil {
+				t.Fatalf("opening database: %v", err)
+			}
+			defer db.Close()
+
+			// A latin1 database, so the tables only hold multibyte values with their own charset.
+			stmt := fmt.Sprintf("ALTER DATABASE `%s` CHARACTER SET latin1", tt.database)
+			if _, err := db.Exec(stmt); err != nil {
+				t.Fatalf("setting the database charset: %v", err)
+			}
+
+			store := &MySQLStore{
+				Db:            db,
+				MetadataTable: "metadata",
+				Columns:       DEFAULT_COLUMNS,
+				WriteMode:     tt.writeMode,
+				Charset:       config.Database.Charset,
+				Logger:        testLogger,
+			}
+			if err := store.EnsureSchema(ctx, "blue"); err != nil {
+				t.Fatalf("EnsureSchema: %v", err)
+			}
+
+			want := "Café ☕ 東京 🚓"
+			record := Record{
+				CaseNumber:    "20-1",
+				Address:       want,
+				OccurDateTime: DEFAULT_DATE,
+				ReportDate:    DEFAULT_DATE,
+			}
+			if err := store.WriteRecords(ctx, "blue", []Record{record}); err != nil {
+				t.Fatalf("WriteRecords: %v", err)
+			}
+
+			var got string
+			if err := db.QueryRow("SELECT `Address` FROM `blue`").Scan(&got); err != nil {
+				t.Fatalf("reading the address: %v", err)
+			}
+			if got != want {
+				t.Errorf("stored address %q, want %q", got, want)
+			}
+		})
+	}
+}
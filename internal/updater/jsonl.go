@@ -0,0 +1,175 @@
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+/*
+ *==================================================================================================
+ * JSON Lines Constants
+ *==================================================================================================
+ */
+
+// FORMAT_CSV and FORMAT_JSONL are the formats a source may be published in: CSV, the default, or
+// newline-delimited JSON with one object per record.
+const FORMAT_CSV = "csv"
+const FORMAT_JSONL = "jsonl"
+
+// JSONL_KEYS names the JSON key feeding each CSV column, by position, so JSON objects are parsed
+// exactly as the equivalent CSV rows are. Keys are matched case-insensitively.
+var JSONL_KEYS = []string{
+	"Address",
+	"CaseNumber",
+	"CrimeAgainst",
+	"Neighborhood",
+	"OccurDate",
+	"OccurTime",
+	"OffenseCategory",
+	"OffenseType",
+	"OpenDataLat",
+	"OpenDataLon",
+	"OpenDataX",
+	"OpenDataY",
+	"ReportDate",
+	"OffenseCount",
+}
+
+// MAX_JSONL_LINE bounds the length of a line of JSON lines input.
+const MAX_JSONL_LINE = 16 << 20
+
+// ErrInvalidJSONLine is the cause of row parse errors for lines that aren't a flat JSON object.
+var ErrInvalidJSONLine = errors.New("invalid json line")
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// ParseJSONL reads newline-delimited JSON from r and marshals each object into a Record according
+// to opts, as ParseCSV does for CSV rows. Each object's values are looked up by the keys in
+// JSONL_KEYS and parsed like the CSV column at the same position, so missing keys and nulls are
+// empty values and produce the same nil fields and DEFAULT_DATE sentinels. Unknown keys are
+// ignored and blank lines skipped.
+//
+// Lines that aren't a JSON object of strings, numbers, booleans and nulls are parse errors, as are
+// objects missing any of opts.RequiredFields.
+func ParseJSONL(
+	ctx context.Context,
+	r io.Reader,
+	opts ParseOptions,
+	logger *slog.Logger,
+) (ParseResult, error) {
+	var result ParseResult
+	interner := newInterner(opts)
+
+	scanner := bufio.NewScanner(decodeReader(r, opts.Encoding))
+	scanner.Buffer(nil, MAX_JSONL_LINE)
+
+	for line := 1; opts.SampleRows <= 0 || result.Parsed < opts.SampleRows; line++ {
+		if line%CANCEL_CHECK_ROWS == 0 {
+			if err := ctx.Err(); err != nil {
+				return ParseResult{}, err
+			}
+		}
+
+		if !scanner.Scan() {
+			break
+		}
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		row, err := jsonRow(text)
+		if err != nil {
+			result.Stats.Rows++
+			result.Stats.BadRows++
+			result.Skipped++
+			result.addError(RowError{
+				Line: line,
+				Row:  []string{string(text)},
+				Err:  fmt.Errorf("%w: %w", ErrInvalidJSONLine, err),
+			})
+			continue
+		}
+
+		result.parseRow(row, line, opts, interner, logger)
+	}
+	if err := scanner.Err(); err != nil {
+		return ParseResult{}, fmt.Errorf("reading line: %w", err)
+	}
+
+	return result, nil
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// parse parses r as opts.Format, with ParseJSONL for FORMAT_JSONL and ParseCSV otherwise.
+func parse(ctx context.Context, r io.Reader, opts ParseOptions, logger *slog.Logger) (
+	ParseResult,
+	error,
+) {
+	if strings.ToLower(opts.Format) == FORMAT_JSONL {
+		return ParseJSONL(ctx, r, opts, logger)
+	}
+
+	return ParseCSV(ctx, r, opts, logger)
+}
+
+// jsonRow decodes a JSON object into a row of values in CSV column order, by the keys in
+// JSONL_KEYS. Missing keys and nulls are empty values.
+func jsonRow(text []byte) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+
+	row := make([]string, len(JSONL_KEYS))
+	for key, value := range object {
+		i := jsonKeyIndex(key)
+		if i < 0 {
+			continue
+		}
+
+		switch v := value.(type) {
+		case nil:
+		case string:
+			row[i] = v
+		case json.Number:
+			row[i] = v.String()
+		case bool:
+			row[i] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("%s is not a string, number, boolean or null", key)
+		}
+	}
+
+	return row, nil
+}
+
+// jsonKeyIndex returns the position of key in JSONL_KEYS, ignoring case, or -1 if it isn't one.
+func jsonKeyIndex(key string) int {
+	for i, k := range JSONL_KEYS {
+		if strings.EqualFold(k, key) {
+			return i
+		}
+	}
+
+	return -1
+}
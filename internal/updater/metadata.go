@@ -0,0 +1,408 @@
+package updater
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+ *==================================================================================================
+ * Metadata Constants
+ *==================================================================================================
+ */
+
+// CREATE_METADATA_TABLE_SQL creates the table recording when each blue/green table was last
+// updated, which is how separate processes agree on the active table, along with when it was last
+// staged and last about to be written. The table name is substituted in with fmt.Sprintf.
+const CREATE_METADATA_TABLE_SQL = "CREATE TABLE IF NOT EXISTS `%s` (" +
+	"table_name VARCHAR(64) NOT NULL PRIMARY KEY, " +
+	"last_updated DATETIME(6) NULL, " +
+	"staged_at DATETIME(6) NULL, " +
+	"written_at DATETIME(6) NULL)"
+
+// ErrNotStaged is returned by Promote when the inactive table wasn't staged by a NoSwap cycle.
+var ErrNotStaged = errors.New("table is not staged")
+
+// ErrNeverPopulated is returned when trying to activate a table that has never been written.
+var ErrNeverPopulated = errors.New("table has never been populated")
+
+// ErrOverwritten is returned by Rollback when the inactive table has been written since it was last
+// active, so it no longer holds the previous dataset.
+var ErrOverwritten = errors.New("table has been written since it was last active")
+
+// ErrIncompleteWrite is returned by PromoteTable when the table was last written by an update that
+// neither made it active nor staged it, so it may hold partial data.
+var ErrIncompleteWrite = errors.New("table was written by an update that didn't finish")
+
+// ErrTableActive is returned when asked to write the active table in place.
+var ErrTableActive = errors.New("table is active")
+
+// ErrNoDatabase is returned by features that run SQL directly, such as check queries and archival,
+// when the service has no database connection.
+var ErrNoDatabase = errors.New("no database connection")
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// LoadMetadata reads the update, staging and write times of the blue and green tables from the
+// Store, picking up any changes made by other processes, such as a rollback. Tables the Store has
+// no update time for have never been updated and keep a zero LastUpdated.
+//
+// If another process changed the active table since the metadata was last loaded, such as the
+// promote command, the change is published to subscribers as this service's own updates are.
+func (s *UpdateService) LoadMetadata(ctx context.Context) error {
+	metadata, err := s.Store.Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("loading metadata: %w", err)
+	}
+
+	previous := s.activeTable()
+	loaded := !s.lastUpdated(previous).IsZero()
+
+	s.tablesMu.Lock()
+	for _, table := range []*Table{s.BlueTable, s.GreenTable} {
+		times := metadata[table.Name]
+		if !times.LastUpdated.IsZero() {
+			table.LastUpdated = times.LastUpdated
+		}
+		table.StagedAt = times.StagedAt
+		table.WrittenAt = times.WrittenAt
+	}
+	s.tablesMu.Unlock()
+
+	if active := s.activeTable(); loaded && active != previous {
+		s.publishActivated(ctx, active)
+	}
+
+	return nil
+}
+
+// Promote makes the table staged by the last NoSwap cycle active, archiving the previously active
+// table and publishing the change as an update cycle would, and returns its name. It returns
+// ErrNotStaged if the inactive table isn't staged, which is the case once it has been written by
+// a later cycle or made active, and ErrUpdateInProgress if this service is running an update.
+func (s *UpdateService) Promote(ctx context.Context) (string, error) {
+	if !s.updateMu.TryLock() {
+		return "", ErrUpdateInProgress
+	}
+	defer s.updateMu.Unlock()
+
+	if err := s.LoadMetadata(ctx); err != nil {
+		return "", err
+	}
+
+	target := s.inactiveTable()
+	if !s.staged(target) {
+		return "", fmt.Errorf("cannot promote %s: %w", target.Name, ErrNotStaged)
+	}
+
+	count, err := s.Store.CountRows(ctx, target.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.activate(ctx, target, count); err != nil {
+		return "", err
+	}
+
+	return target.Name, nil
+}
+
+// PromoteTable makes the named blue/green table active without downloading anything, archiving
+// the previously active table and publishing the change as an update cycle would, whether or not
+// the table was staged. It refuses a table that isn't one of the blue/green tables, is already
+// active, is empty or missing, fails validation, or was written by an update that didn't finish,
+// such as one that failed or is still running in another process, with ErrIncompleteWrite. It
+// returns ErrUpdateInProgress if this service is running an update.
+func (s *UpdateService) PromoteTable(ctx context.Context, name string) error {
+	if !s.updateMu.TryLock() {
+		return ErrUpdateInProgress
+	}
+	defer s.updateMu.Unlock()
+
+	if err := s.LoadMetadata(ctx); err != nil {
+		return err
+	}
+
+	target := s.inactiveTable()
+	switch name {
+	case target.Name:
+	case s.activeTable().Name:
+		return fmt.Errorf("cannot promote %s: table is already active", name)
+	default:
+		return fmt.Errorf(
+			"cannot promote %s: not one of the blue/green tables %s and %s",
+			name,
+			s.BlueTable.Name,
+			s.GreenTable.Name,
+		)
+	}
+
+	if s.writeIncomplete(target) {
+		return fmt.Errorf("cannot promote %s: %w", target.Name, ErrIncompleteWrite)
+	}
+
+	count, err := s.Store.CountRows(ctx, target.Name)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("cannot promote %s: table is empty", target.Name)
+	}
+
+	if err := s.ValidateTable(ctx, target.Name); err != nil {
+		return fmt.Errorf("cannot promote %s: %w", target.Name, err)
+	}
+
+	return s.activate(ctx, target, count)
+}
+
+// Rollback makes the inactive table active again, so the previous dataset serves without
+// downloading anything, and returns its name. It refuses to activate a table that has never been
+// populated, is empty, or has been written since it was last active, such as by a cycle that failed
+// validation or a NoSwap cycle, whose table Promote makes active instead. It returns
+// ErrUpdateInProgress if this service is running an update.
+func (s *UpdateService) Rollback(ctx context.Context) (string, error) {
+	if !s.updateMu.TryLock() {
+		return "", ErrUpdateInProgress
+	}
+	defer s.updateMu.Unlock()
+
+	if err := s.LoadMetadata(ctx); err != nil {
+		return "", err
+	}
+
+	target := s.inactiveTable()
+	if s.lastUpdated(target).IsZero() {
+		return "", fmt.Errorf("cannot roll back to %s: %w", target.Name, ErrNeverPopulated)
+	}
+	if s.overwritten(target) {
+		return "", fmt.Errorf("cannot roll back to %s: %w", target.Name, ErrOverwritten)
+	}
+
+	count, err := s.Store.CountRows(ctx, target.Name)
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", fmt.Errorf("cannot roll back to %s: table is empty", target.Name)
+	}
+
+	if err := s.setLastUpdated(ctx, target, time.Now()); err != nil {
+		return "", err
+	}
+
+	return target.Name, nil
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// staged reports whether table was staged by a NoSwap cycle and hasn't been written or made
+// active since.
+func (s *UpdateService) staged(table *Table) bool {
+	s.tablesMu.RLock()
+	defer s.tablesMu.RUnlock()
+
+	return table.StagedAt.After(table.LastUpdated)
+}
+
+// overwritten reports whether table has been written since it was last made active.
+func (s *UpdateService) overwritten(table *Table) bool {
+	s.tablesMu.RLock()
+	defer s.tablesMu.RUnlock()
+
+	return table.WrittenAt.After(table.LastUpdated)
+}
+
+// writeIncomplete reports whether table has been written since it was last made active or staged,
+// so the write never finished.
+func (s *UpdateService) writeIncomplete(table *Table) bool {
+	s.tablesMu.RLock()
+	defer s.tablesMu.RUnlock()
+
+	return table.WrittenAt.After(table.LastUpdated) && table.WrittenAt.After(table.StagedAt)
+}
+
+// publishActivated publishes that another process made table active, counting its records for the
+// event. If they can't be counted the event is published without them.
+func (s *UpdateService) publishActivated(ctx context.Context, table *Table) {
+	count, err := s.Store.CountRows(ctx, table.Name)
+	if err != nil {
+		s.Logger.Warn("unable to count records of the new active table", "error", err)
+	}
+
+	s.Logger.Info("active table changed by another process", "table", table.Name)
+
+	s.publish(TableChangeEvent{
+		Type:      EVENT_TABLE_CHANGED,
+		Table:     table.Name,
+		UpdatedAt: s.lastUpdated(table),
+		Records:   count,
+	})
+}
+
+// lastUpdated returns when table was last updated.
+func (s *UpdateService) lastUpdated(table *Table) time.Time {
+	s.tablesMu.RLock()
+	defer s.tablesMu.RUnlock()
+
+	return table.LastUpdated
+}
+
+// stage records t as the staging time of table in the Store, and then in memory. A zero t clears
+// the staging.
+func (s *UpdateService) stage(ctx context.Context, table *Table, t time.Time) error {
+	if err := s.Store.Stage(ctx, table.Name, t); err != nil {
+		return err
+	}
+
+	s.tablesMu.Lock()
+	table.StagedAt = t
+	s.tablesMu.Unlock()
+
+	return nil
+}
+
+// unstage clears the staging of table before it is written, so the data that was reviewed is the
+// only data Promote can make active.
+func (s *UpdateService) unstage(ctx context.Context, table *Table) error {
+	if !s.staged(table) {
+		return nil
+	}
+
+	return s.stage(ctx, table, time.Time{})
+}
+
+// markWritten records t as the time table was last about to be written, in the Store and then in
+// memory. It is recorded before writing, so a write that fails partway still counts.
+func (s *UpdateService) markWritten(ctx context.Context, table *Table, t time.Time) error {
+	if err := s.Store.MarkWritten(ctx, table.Name, t); err != nil {
+		return err
+	}
+
+	s.tablesMu.Lock()
+	table.WrittenAt = t
+	s.tablesMu.Unlock()
+
+	return nil
+}
+
+// setLastUpdated records t as the last update time of table in the Store, and then in memory,
+// making table the active one.
+func (s *UpdateService) setLastUpdated(ctx context.Context, table *Table, t time.Time) error {
+	if err := s.Store.Swap(ctx, table.Name, t); err != nil {
+		return err
+	}
+
+	s.tablesMu.Lock()
+	table.LastUpdated = t
+	s.tablesMu.Unlock()
+
+	return nil
+}
+
+/*
+ *==================================================================================================
+ * MySQLStore Metadata
+ *==================================================================================================
+ */
+
+// Swap records t as the last update time of table in the metadata table.
+func (m *MySQLStore) Swap(ctx context.Context, table string, t time.Time) error {
+	if err := m.setMetadataTime(ctx, table, "last_updated", t); err != nil {
+		return fmt.Errorf("recording update of %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// Stage records t as the staging time of table in the metadata table, or clears it if t is zero.
+func (m *MySQLStore) Stage(ctx context.Context, table string, t time.Time) error {
+	if err := m.setMetadataTime(ctx, table, "staged_at", t); err != nil {
+		return fmt.Errorf("recording staging of %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// MarkWritten records t as the time table was last about to be written in the metadata table.
+func (m *MySQLStore) MarkWritten(ctx context.Context, table string, t time.Time) error {
+	if err := m.setMetadataTime(ctx, table, "written_at", t); err != nil {
+		return fmt.Errorf("recording write of %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// Metadata reads every row of the metadata table.
+func (m *MySQLStore) Metadata(ctx context.Context) (map[string]TableMetadata, error) {
+	query := fmt.Sprintf(
+		"SELECT table_name, last_updated, staged_at, written_at FROM `%s`",
+		m.MetadataTable,
+	)
+
+	rows, err := m.Db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("querying %s: %w", m.MetadataTable, err)
+	}
+	defer rows.Close()
+
+	metadata := make(map[string]TableMetadata)
+	for rows.Next() {
+		var name string
+		var lastUpdated, stagedAt, writtenAt sql.NullTime
+		if err := rows.Scan(&name, &lastUpdated, &stagedAt, &writtenAt); err != nil {
+			return nil, fmt.Errorf("scanning %s: %w", m.MetadataTable, err)
+		}
+		metadata[name] = TableMetadata{
+			LastUpdated: lastUpdated.Time,
+			StagedAt:    stagedAt.Time,
+			WrittenAt:   writtenAt.Time,
+		}
+	}
+
+	return metadata, rows.Err()
+}
+
+// CountRows counts the rows in table.
+func (m *MySQLStore) CountRows(ctx context.Context, table string) (int, error) {
+	var count int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM `%s`", table)
+	if err := m.Db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return 0, fmt.Errorf("counting rows in %s: %w", table, err)
+	}
+
+	return count, nil
+}
+
+// setMetadataTime records t in column of table's row of the metadata table, creating the row if
+// needed. A zero t is recorded as NULL.
+func (m *MySQLStore) setMetadataTime(
+	ctx context.Context,
+	table string,
+	column string,
+	t time.Time,
+) error {
+	stmt := fmt.Sprintf(
+		"INSERT INTO `%s` (table_name, %s) VALUES (?, ?) "+
+			"ON DUPLICATE KEY UPDATE %s = VALUES(%s)",
+		m.MetadataTable,
+		column,
+		column,
+		column,
+	)
+	value := sql.NullTime{Time: t, Valid: !t.IsZero()}
+	_, err := m.Db.ExecContext(ctx, stmt, table, value)
+
+	return err
+}
@@ -0,0 +1,166 @@
+package updater
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPromote(t *testing.T) {
+	tests := []struct {
+		name string
+		// cycles are run in order, each with NoSwap as given, before promoting.
+		cycles     []bool
+		wantErr    error
+		wantActive string
+	}{
+		{name: "nothing staged", cycles: []bool{false}, wantErr: ErrNotStaged},
+		{name: "staged", cycles: []bool{false, true}, wantActive: "green"},
+		{name: "staged first cycle", cycles: []bool{true}, wantActive: "blue"},
+		{name: "written after staging", cycles: []bool{true, false}, wantErr: ErrNotStaged},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := serveCSV(t, mixedCSV(3, nil, nil))
+			s := newTestService(t, NewMemoryStore(), url)
+			ctx := context.Background()
+
+			for i, noSwap := range tt.cycles {
+				s.NoSwap = noSwap
+				stats, err := s.runCycle(ctx)
+				if err != nil {
+					t.Fatalf("cycle %d: %v", i, err)
+				}
+				if stats.Staged != noSwap {
+					t.Errorf("cycle %d: stats.Staged = %t, want %t", i, stats.Staged, noSwap)
+				}
+			}
+			before := s.LastUpdatedTable()
+
+			table, err := s.Promote(ctx)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Promote() error = %v, want %v", err, tt.wantErr)
+				}
+				if got := s.LastUpdatedTable(); got != before {
+					t.Errorf("active table = %s after a failed promote, want %s", got, before)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Promote: %v", err)
+			}
+
+			if table != tt.wantActive || s.LastUpdatedTable() != tt.wantActive {
+				t.Errorf(
+					"promoted %s, active table %s, want %s",
+					table,
+					s.LastUpdatedTable(),
+					tt.wantActive,
+				)
+			}
+
+			// A promoted table is no longer staged.
+			if _, err := s.Promote(ctx); !errors.Is(err, ErrNotStaged) {
+				t.Errorf("second Promote() error = %v, want %v", err, ErrNotStaged)
+			}
+		})
+	}
+}
+
+func TestMySQLStoreMetadataTimes(t *testing.T) {
+	at := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		record func(ctx context.Context, m *MySQLStore) error
+		column string
+		want   driver.Value
+	}{
+		{
+			name: "swap",
+			record: func(ctx context.Context, m *MySQLStore) error {
+				return m.Swap(ctx, "blue", at)
+			},
+			column: "last_updated",
+			want:   at,
+		},
+		{
+			name: "stage",
+			record: func(ctx context.Context, m *MySQLStore) error {
+				return m.Stage(ctx, "blue", at)
+			},
+			column: "staged_at",
+			want:   at,
+		},
+		{
+			name: "unstage",
+			record: func(ctx context.Context, m *MySQLStore) error {
+				return m.Stage(ctx, "blue", time.Time{})
+			},
+			column: "staged_at",
+			want:   nil,
+		},
+		{
+			name: "mark written",
+			record: func(ctx context.Context, m *MySQLStore) error {
+				return m.MarkWritten(ctx, "blue", at)
+			},
+			column: "written_at",
+			want:   at,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newMockService(t)
+			stmt := fmt.Sprintf(
+				"INSERT INTO `metadata` (table_name, %s) VALUES (?, ?) "+
+					"ON DUPLICATE KEY UPDATE %s = VALUES(%s)",
+				tt.column,
+				tt.column,
+				tt.column,
+			)
+			mock.ExpectExec(regexp.QuoteMeta(stmt)).
+				WithArgs("blue", tt.want).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			if err := tt.record(context.Background(), s.Store.(*MySQLStore)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMySQLStoreMetadata(t *testing.T) {
+	s, mock := newMockService(t)
+	at := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	rows := sqlmock.NewRows([]string{"table_name", "last_updated", "staged_at", "written_at"}).
+		AddRow("blue", at, nil, at).
+		AddRow("green", nil, at, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT table_name, last_updated, staged_at, written_at FROM `metadata`",
+	)).WillReturnRows(rows)
+
+	got, err := s.Store.Metadata(context.Background())
+	if err != nil {
+		t.Fatalf("Metadata: %v", err)
+	}
+
+	want := map[string]TableMetadata{
+		"blue":  {LastUpdated: at, WrittenAt: at},
+		"green": {StagedAt: at},
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("Metadata() = %v, want %v", got, want)
+	}
+}
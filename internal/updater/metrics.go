@@ -0,0 +1,43 @@
+package updater
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+/*
+ *==================================================================================================
+ * Metrics
+ *==================================================================================================
+ */
+
+// skippedRecords counts the rows update cycles skipped as parse errors, labeled by reason, so a
+// dashboard shows which way the upstream data is degrading. It is registered with the default
+// Prometheus registry, which the server exposes at /metrics.
+var skippedRecords = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "updater_records_skipped_total",
+		Help: "Rows skipped as parse errors by update cycles, by reason.",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	// Start every reason at zero, so rates can be taken before the first skipped row.
+	for _, reason := range SKIP_REASONS {
+		skippedRecords.WithLabelValues(reason)
+	}
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// countSkipped adds the rows stats counts as skipped to the skipped records counter.
+func countSkipped(stats ParseStats) {
+	for reason, count := range stats.SkippedByReason() {
+		skippedRecords.WithLabelValues(reason).Add(float64(count))
+	}
+}
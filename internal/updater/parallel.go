@@ -0,0 +1,132 @@
+package updater
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+/*
+ *==================================================================================================
+ * Parallel Parsing Constants
+ *==================================================================================================
+ */
+
+// PARSE_BATCH_ROWS is the number of rows handed to a parse worker at a time, so workers don't
+// contend on the channel for every row.
+const PARSE_BATCH_ROWS = 1000
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// rowBatch is a batch of rows read from a CSV file, with the line each row starts on.
+type rowBatch struct {
+	rows  [][]string
+	lines []int
+}
+
+// parseParallel reads the rows after the header from reader and parses them on opts.Workers
+// goroutines, as ParseCSV does serially. Each worker parses into its own ParseResult, interning
+// strings with its own Interner, and the results are merged once every row is parsed. The kept
+// row errors are the earliest in the file, as with the serial parser, so they are logged in order.
+func parseParallel(
+	ctx context.Context,
+	reader *csv.Reader,
+	opts ParseOptions,
+	logger *slog.Logger,
+) (ParseResult, error) {
+	batches := make(chan rowBatch, opts.Workers)
+	results := make([]ParseResult, opts.Workers)
+
+	var wg sync.WaitGroup
+	for i := range opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			interner := newInterner(opts)
+			for batch := range batches {
+				for j, row := range batch.rows {
+					results[i].parseRow(row, batch.lines[j], opts, interner, logger)
+				}
+			}
+		}()
+	}
+
+	err := readBatches(ctx, reader, batches)
+	close(batches)
+	wg.Wait()
+
+	if err != nil {
+		return ParseResult{}, err
+	}
+
+	var result ParseResult
+	var rowErrors []RowError
+	result.Records = make([]Record, 0, sumRecords(results))
+	for _, r := range results {
+		rowErrors = append(rowErrors, r.Errors...)
+		r.Errors = nil
+		result.Merge(r)
+	}
+
+	slices.SortFunc(rowErrors, func(a, b RowError) int { return a.Line - b.Line })
+	result.Errors = rowErrors[:min(len(rowErrors), MAX_ROW_ERRORS)]
+
+	return result, nil
+}
+
+// readBatches reads every remaining row from reader and sends them to batches, PARSE_BATCH_ROWS at
+// a time. It stops with the context's error if ctx is cancelled, checking before each batch.
+func readBatches(ctx context.Context, reader *csv.Reader, batches chan<- rowBatch) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch := rowBatch{
+			rows:  make([][]string, 0, PARSE_BATCH_ROWS),
+			lines: make([]int, 0, PARSE_BATCH_ROWS),
+		}
+		for len(batch.rows) < PARSE_BATCH_ROWS {
+			row, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				if len(batch.rows) > 0 {
+					batches <- batch
+				}
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("reading row: %w", err)
+			}
+			line, _ := reader.FieldPos(0)
+
+			batch.rows = append(batch.rows, row)
+			batch.lines = append(batch.lines, line)
+		}
+
+		select {
+		case batches <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sumRecords returns the number of records held by results.
+func sumRecords(results []ParseResult) int {
+	var n int
+	for _, r := range results {
+		n += len(r.Records)
+	}
+
+	return n
+}
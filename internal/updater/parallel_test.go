@@ -0,0 +1,98 @@
+package updater
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseCSVParallelMatchesSerial(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name         string
+		rows         int
+		short        []int
+		noCaseNumber []int
+		workers      int
+	}{
+		{name: "empty", workers: 4},
+		{name: "single batch", rows: 10, short: []int{3}, noCaseNumber: []int{7}, workers: 4},
+		{
+			name:         "several batches",
+			rows:         3*PARSE_BATCH_ROWS + 17,
+			short:        []int{2, PARSE_BATCH_ROWS + 1, 2 * PARSE_BATCH_ROWS},
+			noCaseNumber: []int{5, 3 * PARSE_BATCH_ROWS},
+			workers:      3,
+		},
+		{
+			name:    "more workers than batches",
+			rows:    PARSE_BATCH_ROWS + 1,
+			short:   []int{PARSE_BATCH_ROWS + 2},
+			workers: 8,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := mixedCSV(tt.rows, tt.short, tt.noCaseNumber)
+			ctx := context.Background()
+			opts := ParseOptions{RequiredFields: []string{"CaseNumber"}}
+
+			serial, err := ParseCSV(ctx, strings.NewReader(data), opts, logger)
+			if err != nil {
+				t.Fatalf("serial ParseCSV: %v", err)
+			}
+			opts.Workers = tt.workers
+			parallel, err := ParseCSV(ctx, strings.NewReader(data), opts, logger)
+			if err != nil {
+				t.Fatalf("parallel ParseCSV: %v", err)
+			}
+
+			// Records come out of the workers in no particular order.
+			cases := func(records []Record) []string {
+				var cases []string
+				for _, r := range records {
+					cases = append(cases, r.CaseNumber)
+				}
+				slices.Sort(cases)
+				return cases
+			}
+			got, want := cases(parallel.Records), cases(serial.Records)
+			if !slices.Equal(got, want) {
+				t.Errorf("parallel parsed %d records, serial %d", len(got), len(want))
+			}
+
+			if parallel.Skipped != serial.Skipped || parallel.Parsed != serial.Parsed {
+				t.Errorf(
+					"parallel parsed %d and skipped %d, serial parsed %d and skipped %d",
+					parallel.Parsed,
+					parallel.Skipped,
+					serial.Parsed,
+					serial.Skipped,
+				)
+			}
+			if parallel.Stats.Rows != serial.Stats.Rows ||
+				parallel.Stats.Errors() != serial.Stats.Errors() ||
+				!maps.Equal(parallel.Stats.Missing, serial.Stats.Missing) {
+				t.Errorf("parallel stats %+v, serial %+v", parallel.Stats, serial.Stats)
+			}
+
+			lines := func(result ParseResult) []int {
+				var lines []int
+				for _, rowErr := range result.Errors {
+					lines = append(lines, rowErr.Line)
+				}
+				return lines
+			}
+			gotLines, wantLines := lines(parallel), lines(serial)
+			if !slices.Equal(gotLines, wantLines) {
+				t.Errorf("parallel kept errors on lines %v, serial on %v", gotLines, wantLines)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package updater
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+/*
+ *==================================================================================================
+ * Query Logging
+ *==================================================================================================
+ */
+
+// logConnector wraps the MySQL connector so every statement run on its connections is logged at
+// debug level, for database.log-queries. Only the number of arguments is logged, never their
+// values, since they hold the downloaded data.
+type logConnector struct {
+	driver.Connector
+	logger *slog.Logger
+}
+
+// logConn is a connection whose statements are logged. It forwards every optional driver
+// interface the MySQL connection implements, so database/sql treats it as it would the original.
+type logConn struct {
+	driver.Conn
+	logger *slog.Logger
+}
+
+// logStmt is a prepared statement whose executions are logged.
+type logStmt struct {
+	driver.Stmt
+	query  string
+	logger *slog.Logger
+}
+
+func (c *logConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logConn{Conn: conn, logger: c.logger}, nil
+}
+
+// ExecContext runs query on the connection. database/sql falls back to preparing the statement
+// when the driver returns driver.ErrSkip, so those attempts are logged by logStmt instead.
+func (c *logConn) ExecContext(
+	ctx context.Context,
+	query string,
+	args []driver.NamedValue,
+) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if !errors.Is(err, driver.ErrSkip) {
+		logQuery(c.logger, query, len(args), start, err)
+	}
+
+	return result, err
+}
+
+// QueryContext runs query on the connection, logged as ExecContext is.
+func (c *logConn) QueryContext(
+	ctx context.Context,
+	query string,
+	args []driver.NamedValue,
+) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if !errors.Is(err, driver.ErrSkip) {
+		logQuery(c.logger, query, len(args), start, err)
+	}
+
+	return rows, err
+}
+
+func (c *logConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &logStmt{Stmt: stmt, query: query, logger: c.logger}, nil
+}
+
+func (c *logConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	return c.Conn.Begin()
+}
+
+func (c *logConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (c *logConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (c *logConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+
+	return true
+}
+
+func (c *logConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+func (s *logStmt) ExecContext(
+	ctx context.Context,
+	args []driver.NamedValue,
+) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, errors.New("driver statement does not support ExecContext")
+	}
+
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, args)
+	logQuery(s.logger, s.query, len(args), start, err)
+
+	return result, err
+}
+
+func (s *logStmt) QueryContext(
+	ctx context.Context,
+	args []driver.NamedValue,
+) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, errors.New("driver statement does not support QueryContext")
+	}
+
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, args)
+	logQuery(s.logger, s.query, len(args), start, err)
+
+	return rows, err
+}
+
+func (s *logStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// logQuery logs a statement that was run with args arguments, starting at start.
+func logQuery(logger *slog.Logger, query string, args int, start time.Time, err error) {
+	if err != nil {
+		logger.Debug(
+			"sql statement failed",
+			"query",
+			query,
+			"args",
+			args,
+			"duration",
+			time.Since(start),
+			"error",
+			err,
+		)
+		return
+	}
+
+	logger.Debug("sql statement", "query", query, "args", args, "duration", time.Since(start))
+}
@@ -0,0 +1,154 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+ *==================================================================================================
+ * Parse Result Constants
+ *==================================================================================================
+ */
+
+// MAX_ROW_ERRORS is the number of RowErrors a ParseResult keeps. Skipped keeps counting past it,
+// so a badly broken file doesn't hold every bad row in memory.
+const MAX_ROW_ERRORS = 100
+
+// LOGGED_ROW_ERRORS is the number of skipped rows from each file logged by an update cycle.
+const LOGGED_ROW_ERRORS = 5
+
+// LOGGED_FIELD_LENGTH is the length past which the fields of a skipped row are truncated when
+// logged, so a runaway field, such as a stray quote swallowing the rest of the file, doesn't flood
+// the log.
+const LOGGED_FIELD_LENGTH = 200
+
+// PARSE_ERROR_SAMPLES is the number of skipped rows included in a ParseErrorRateError.
+const PARSE_ERROR_SAMPLES = 10
+
+// ErrWrongColumnCount and ErrMissingRequiredField are the causes of row parse errors.
+var ErrWrongColumnCount = errors.New("wrong number of columns")
+var ErrMissingRequiredField = errors.New("missing required field")
+
+/*
+ *==================================================================================================
+ * RowError Struct
+ *==================================================================================================
+ */
+
+// RowError describes a CSV row that was skipped as a parse error. Row holds the fields exactly as
+// read, so the data that caused the error can be seen without downloading the file again.
+type RowError struct {
+	Line int      // line in the CSV file the row starts on
+	Row  []string // the row's fields as read from the file
+	Err  error    // why the row was skipped
+}
+
+// Error implements the error interface.
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the cause of the error.
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON encodes the error with its cause as a message, since errors don't encode as JSON.
+func (e RowError) MarshalJSON() ([]byte, error) {
+	var message string
+	if e.Err != nil {
+		message = e.Err.Error()
+	}
+
+	return json.Marshal(struct {
+		Line  int      `json:"line"`
+		Row   []string `json:"row"`
+		Error string   `json:"error"`
+	}{e.Line, e.Row, message})
+}
+
+// ParseErrorRateError is returned by an update cycle that was aborted because more of its rows were
+// parse errors than MaxParseErrorRate allows, which usually means the upstream data is broken
+// rather than unreachable. Samples holds the first of the skipped rows.
+type ParseErrorRateError struct {
+	Rate    float64    `json:"rate"`
+	MaxRate float64    `json:"max_rate"`
+	Rows    int        `json:"rows"`
+	Errors  int        `json:"errors"`
+	Samples []RowError `json:"samples"`
+}
+
+// Error implements the error interface.
+func (e *ParseErrorRateError) Error() string {
+	return fmt.Sprintf(
+		"parse error rate %.2f%% exceeds maximum of %.2f%%",
+		e.Rate*100,
+		e.MaxRate*100,
+	)
+}
+
+/*
+ *==================================================================================================
+ * ParseResult Struct
+ *==================================================================================================
+ */
+
+// ParseResult is the outcome of parsing CSV data: the records parsed and their number, the first
+// MAX_ROW_ERRORS rows skipped as parse errors, the total number of rows skipped, and statistics on
+// the data. Parsed is len(Records) unless the records were only counted.
+type ParseResult struct {
+	Records []Record
+	Parsed  int
+	Errors  []RowError
+	Skipped int
+	Stats   ParseStats
+
+	// Filtered counts the records dropped by ParseOptions.Neighborhoods and MinOccurDate.
+	Filtered int
+}
+
+// Merge appends the records and errors from other to r and adds up the counts.
+func (r *ParseResult) Merge(other ParseResult) {
+	r.Records = append(r.Records, other.Records...)
+	r.Parsed += other.Parsed
+	r.Skipped += other.Skipped
+	r.Filtered += other.Filtered
+	r.Stats.Merge(other.Stats)
+
+	for _, rowErr := range other.Errors {
+		r.addError(rowErr)
+	}
+}
+
+// LoggedRow returns the row's fields for logging, each truncated to LOGGED_FIELD_LENGTH bytes.
+func (e RowError) LoggedRow() []string {
+	logged := make([]string, len(e.Row))
+	for i, field := range e.Row {
+		logged[i] = truncateField(field)
+	}
+
+	return logged
+}
+
+// addError counts a skipped row, keeping rowErr if fewer than MAX_ROW_ERRORS are held.
+func (r *ParseResult) addError(rowErr RowError) {
+	if len(r.Errors) < MAX_ROW_ERRORS {
+		r.Errors = append(r.Errors, rowErr)
+	}
+}
+
+// truncateField returns field cut to LOGGED_FIELD_LENGTH bytes, noting how many bytes were cut.
+func truncateField(field string) string {
+	if len(field) <= LOGGED_FIELD_LENGTH {
+		return field
+	}
+
+	return fmt.Sprintf(
+		"%s... (%d more bytes)",
+		strings.ToValidUTF8(field[:LOGGED_FIELD_LENGTH], ""),
+		len(field)-LOGGED_FIELD_LENGTH,
+	)
+}
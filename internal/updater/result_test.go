@@ -0,0 +1,147 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// mixedCSV returns CSV data whose rows are good, except for short rows at the given lines and
+// rows with no case number at the given lines. The header is line 1, so rows start at line 2.
+func mixedCSV(rows int, short, noCaseNumber []int) string {
+	header := bytes.SplitAfter(benchmarkCSV(0), []byte("\n"))[0]
+
+	var b strings.Builder
+	b.Write(header)
+	for i := range rows {
+		row := benchmarkRow(i)
+		switch line := i + 2; {
+		case slices.Contains(short, line):
+			row = row[:10]
+		case slices.Contains(noCaseNumber, line):
+			row[1] = ""
+		}
+		b.WriteString(strings.Join(row, ",") + "\n")
+	}
+
+	return b.String()
+}
+
+func TestParseCSVAggregatesRowErrors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name         string
+		rows         int
+		short        []int
+		noCaseNumber []int
+		wantLines    []int
+		wantCauses   []error
+	}{
+		{name: "all good", rows: 5},
+		{
+			name:         "mixed",
+			rows:         10,
+			short:        []int{3, 9},
+			noCaseNumber: []int{5},
+			wantLines:    []int{3, 5, 9},
+			wantCauses: []error{
+				ErrWrongColumnCount,
+				ErrMissingRequiredField,
+				ErrWrongColumnCount,
+			},
+		},
+		{
+			name:       "all bad",
+			rows:       3,
+			short:      []int{2, 3, 4},
+			wantLines:  []int{2, 3, 4},
+			wantCauses: []error{ErrWrongColumnCount, ErrWrongColumnCount, ErrWrongColumnCount},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := mixedCSV(tt.rows, tt.short, tt.noCaseNumber)
+			opts := ParseOptions{RequiredFields: []string{"CaseNumber"}}
+
+			result, err := ParseCSV(context.Background(), strings.NewReader(data), opts, logger)
+			if err != nil {
+				t.Fatalf("ParseCSV: %v", err)
+			}
+
+			if want := tt.rows - len(tt.wantLines); len(result.Records) != want {
+				t.Errorf("parsed %d records, want %d", len(result.Records), want)
+			}
+			if result.Skipped != len(tt.wantLines) {
+				t.Errorf("Skipped = %d, want %d", result.Skipped, len(tt.wantLines))
+			}
+			if result.Stats.BadRows != len(tt.short) {
+				t.Errorf("Stats.BadRows = %d, want %d", result.Stats.BadRows, len(tt.short))
+			}
+			if result.Stats.MissingRequired != len(tt.noCaseNumber) {
+				t.Errorf("Stats.MissingRequired = %d, want %d",
+					result.Stats.MissingRequired, len(tt.noCaseNumber))
+			}
+
+			if len(result.Errors) != len(tt.wantLines) {
+				t.Fatalf("got %d row errors, want %d", len(result.Errors), len(tt.wantLines))
+			}
+			for i, rowErr := range result.Errors {
+				if rowErr.Line != tt.wantLines[i] {
+					t.Errorf("error %d on line %d, want %d", i, rowErr.Line, tt.wantLines[i])
+				}
+				if !errors.Is(&rowErr, tt.wantCauses[i]) {
+					t.Errorf("error %d = %v, want %v", i, &rowErr, tt.wantCauses[i])
+				}
+				if len(rowErr.Row) == 0 {
+					t.Errorf("error %d does not hold the raw row", i)
+				}
+			}
+		})
+	}
+}
+
+func TestParseResultMerge(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	parse := func(data string) ParseResult {
+		r := strings.NewReader(data)
+		result, err := ParseCSV(context.Background(), r, ParseOptions{}, logger)
+		if err != nil {
+			t.Fatalf("ParseCSV: %v", err)
+		}
+		return result
+	}
+
+	var merged ParseResult
+	merged.Merge(parse(mixedCSV(4, []int{2}, nil)))
+	merged.Merge(parse(mixedCSV(MAX_ROW_ERRORS+2, nil, nil)))
+	merged.Merge(parse(mixedCSV(MAX_ROW_ERRORS, seq(2, MAX_ROW_ERRORS+1), nil)))
+
+	if want := 3 + MAX_ROW_ERRORS + 2; len(merged.Records) != want {
+		t.Errorf("merged %d records, want %d", len(merged.Records), want)
+	}
+	if want := 1 + MAX_ROW_ERRORS; merged.Skipped != want {
+		t.Errorf("Skipped = %d, want %d", merged.Skipped, want)
+	}
+	if merged.Stats.Rows != 4+MAX_ROW_ERRORS+2+MAX_ROW_ERRORS {
+		t.Errorf("Stats.Rows = %d, want every row counted", merged.Stats.Rows)
+	}
+	if len(merged.Errors) != MAX_ROW_ERRORS {
+		t.Errorf("kept %d row errors, want %d", len(merged.Errors), MAX_ROW_ERRORS)
+	}
+}
+
+// seq returns the integers from first to last inclusive.
+func seq(first, last int) []int {
+	var s []int
+	for i := first; i <= last; i++ {
+		s = append(s, i)
+	}
+	return s
+}
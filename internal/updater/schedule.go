@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+/*
+ *==================================================================================================
+ * Schedules
+ *==================================================================================================
+ */
+
+// schedule returns when Run's update cycles are due.
+type schedule interface {
+	// Next returns the first time after t an update is due.
+	Next(t time.Time) time.Time
+}
+
+// every is the schedule of a fixed CheckEvery interval.
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+func (e every) String() string {
+	return time.Duration(e).String()
+}
+
+// cronSchedule is the schedule of a Cron expression, keeping the expression and its time zone for
+// logging.
+type cronSchedule struct {
+	cron.Schedule
+	spec     string
+	timezone string
+}
+
+func (c cronSchedule) String() string {
+	if c.timezone == "" {
+		return c.spec
+	}
+
+	return c.spec + " (" + c.timezone + ")"
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// schedule returns Run's schedule: the Cron expression if one is set, and otherwise every
+// CheckEvery interval.
+func (s *UpdateService) schedule() (schedule, error) {
+	s.mu.Lock()
+	spec, timezone := s.Cron, s.CronTimezone
+	s.mu.Unlock()
+
+	if spec == "" {
+		interval, err := s.interval()
+		if err != nil {
+			return nil, err
+		}
+		return every(interval), nil
+	}
+
+	parsed, err := parseCron(spec, timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	return cronSchedule{Schedule: parsed, spec: spec, timezone: timezone}, nil
+}
+
+// parseCron parses the cron expression spec, evaluated in the named IANA time zone, or the local
+// time zone if timezone is blank. A CRON_TZ prefix in spec takes precedence over timezone.
+func parseCron(spec string, timezone string) (cron.Schedule, error) {
+	parsed, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", spec, err)
+	}
+
+	if timezone == "" || strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		return parsed, nil
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron time zone %q: %w", timezone, err)
+	}
+
+	// Only expressions with fields depend on the time zone; @every intervals don't.
+	if fields, ok := parsed.(*cron.SpecSchedule); ok {
+		fields.Location = location
+	}
+
+	return parsed, nil
+}
+
+// nextRun returns when the update after the one due at last is due, skipping any that came due
+// before now while the last one ran.
+func nextRun(sched schedule, last time.Time, now time.Time) time.Time {
+	next := sched.Next(last)
+	if next.After(now) {
+		return next
+	}
+
+	return sched.Next(now)
+}
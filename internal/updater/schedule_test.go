@@ -0,0 +1,136 @@
+package updater
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleAcrossDSTBoundaries(t *testing.T) {
+	const timezone = "America/New_York"
+
+	newYork, err := time.LoadLocation(timezone)
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, newYork)
+	}
+	utc := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	// On 2024-03-10 clocks in New York jump from 02:00 EST to 03:00 EDT, and on 2024-11-03 they
+	// fall back from 02:00 EDT to 01:00 EST.
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want []time.Time
+	}{
+		{
+			// 02:30 doesn't exist on the day clocks spring forward, so that day's run is skipped.
+			name: "daily in the skipped hour",
+			spec: "30 2 * * *",
+			from: at(time.March, 9, 12, 0),
+			want: []time.Time{utc(time.March, 11, 6, 30), utc(time.March, 12, 6, 30)},
+		},
+		{
+			name: "daily before the skipped hour",
+			spec: "0 1 * * *",
+			from: at(time.March, 9, 12, 0),
+			want: []time.Time{
+				utc(time.March, 10, 6, 0),
+				utc(time.March, 11, 5, 0),
+				utc(time.March, 12, 5, 0),
+			},
+		},
+		{
+			// 03:00 comes straight after the jump, in EDT from then on.
+			name: "daily after the skipped hour",
+			spec: "0 3 * * *",
+			from: at(time.March, 9, 12, 0),
+			want: []time.Time{
+				utc(time.March, 10, 7, 0),
+				utc(time.March, 11, 7, 0),
+			},
+		},
+		{
+			name: "hourly through the skipped hour",
+			spec: "0 * * * *",
+			from: at(time.March, 10, 0, 30),
+			want: []time.Time{
+				utc(time.March, 10, 6, 0),
+				utc(time.March, 10, 7, 0),
+				utc(time.March, 10, 8, 0),
+			},
+		},
+		{
+			// 01:30 happens twice on the day clocks fall back, first in EDT and then in EST, and
+			// runs both times.
+			name: "daily in the repeated hour",
+			spec: "30 1 * * *",
+			from: at(time.November, 2, 12, 0),
+			want: []time.Time{
+				utc(time.November, 3, 5, 30),
+				utc(time.November, 3, 6, 30),
+				utc(time.November, 4, 6, 30),
+			},
+		},
+		{
+			name: "daily after the repeated hour",
+			spec: "0 2 * * *",
+			from: at(time.November, 2, 12, 0),
+			want: []time.Time{
+				utc(time.November, 3, 7, 0),
+				utc(time.November, 4, 7, 0),
+			},
+		},
+		{
+			name: "hourly through the repeated hour",
+			spec: "0 * * * *",
+			from: at(time.November, 3, 0, 30),
+			want: []time.Time{
+				utc(time.November, 3, 5, 0),
+				utc(time.November, 3, 6, 0),
+				utc(time.November, 3, 7, 0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := parseCron(tt.spec, timezone)
+			if err != nil {
+				t.Fatalf("parseCron(%q, %q): %v", tt.spec, timezone, err)
+			}
+			sched := cronSchedule{Schedule: parsed, spec: tt.spec, timezone: timezone}
+
+			next := tt.from
+			for i, want := range tt.want {
+				next = sched.Next(next)
+				if !next.Equal(want) {
+					t.Fatalf("run %d of %s = %v, want %v", i+1, sched, next, want.In(newYork))
+				}
+			}
+		})
+	}
+}
+
+func TestParseCronTimezoneOverriddenBySpec(t *testing.T) {
+	parsed, err := parseCron("CRON_TZ=UTC 0 2 * * *", "America/New_York")
+	if err != nil {
+		t.Fatalf("parseCron: %v", err)
+	}
+
+	from := time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC)
+	want := time.Date(2024, time.March, 10, 2, 0, 0, 0, time.UTC)
+	if got := parsed.Next(from); !got.Equal(want) {
+		t.Errorf("Next = %v, want %v", got, want)
+	}
+}
+
+func TestParseCronInvalidTimezone(t *testing.T) {
+	if _, err := parseCron("0 2 * * *", "Mars/Olympus_Mons"); err == nil {
+		t.Error("parseCron accepted an unknown time zone")
+	}
+}
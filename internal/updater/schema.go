@@ -0,0 +1,170 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+ *==================================================================================================
+ * Schema Constants
+ *==================================================================================================
+ */
+
+// INDEX_EXISTS_SQL counts the indexes with a given name on a table in the current database.
+const INDEX_EXISTS_SQL = "SELECT COUNT(*) FROM information_schema.STATISTICS " +
+	"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?"
+
+// TABLE_COLUMNS_SQL lists the columns of a table in the current database, and whether each is
+// nullable.
+const TABLE_COLUMNS_SQL = "SELECT COLUMN_NAME, IS_NULLABLE FROM information_schema.COLUMNS " +
+	"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?"
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// EnsureSchema creates the metadata table and the given tables if they don't already exist, and
+// adds any columns and configured indexes missing from the tables. Tables are created with the
+// store's Charset and Collation; existing tables are never converted.
+//
+// Missing columns are added so optional columns, such as the source url, can be enabled on
+// existing tables, and NOT NULL columns the store declares nullable are made nullable, such as the
+// text columns when empty strings are stored as NULL.
+//
+// Indexes are checked individually rather than only when a table is created, so a table that was
+// dropped and recreated outside the service still ends up with its indexes.
+func (m *MySQLStore) EnsureSchema(ctx context.Context, tables ...string) error {
+	for _, column := range m.Indexes {
+		if !slices.ContainsFunc(m.Columns, func(c Column) bool { return c.Name == column }) {
+			return fmt.Errorf("cannot index unknown column %q", column)
+		}
+	}
+
+	stmt := fmt.Sprintf(CREATE_METADATA_TABLE_SQL, m.MetadataTable)
+	if _, err := m.Db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("creating metadata table %s: %w", m.MetadataTable, err)
+	}
+
+	for _, table := range tables {
+		if _, err := m.Db.ExecContext(ctx, m.createTableSQL(table)); err != nil {
+			return fmt.Errorf("creating table %s: %w", table, err)
+		}
+
+		if err := m.ensureColumns(ctx, table); err != nil {
+			return err
+		}
+
+		for _, column := range m.Indexes {
+			if err := m.ensureIndex(ctx, table, column); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// createTableSQL returns a CREATE TABLE IF NOT EXISTS statement for table with the store's columns,
+// and its Charset and Collation if set.
+func (m *MySQLStore) createTableSQL(table string) string {
+	definitions := make([]string, len(m.Columns))
+	for i, c := range m.Columns {
+		definitions[i] = c.definition()
+	}
+
+	var options string
+	if m.Charset != "" {
+		options += " DEFAULT CHARSET=" + m.Charset
+	}
+	if m.Collation != "" {
+		options += " COLLATE=" + m.Collation
+	}
+
+	return fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS `%s` (%s)%s",
+		table,
+		strings.Join(definitions, ", "),
+		options,
+	)
+}
+
+// ensureColumns adds the store's columns that are missing from table, and makes existing columns
+// the store declares nullable nullable. Nullable columns are never made NOT NULL, since rows may
+// already hold NULLs.
+func (m *MySQLStore) ensureColumns(ctx context.Context, table string) error {
+	rows, err := m.Db.QueryContext(ctx, TABLE_COLUMNS_SQL, table)
+	if err != nil {
+		return fmt.Errorf("listing columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	// existing maps each column's lowercased name to whether it is nullable.
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name, nullable string
+		if err := rows.Scan(&name, &nullable); err != nil {
+			return fmt.Errorf("listing columns of %s: %w", table, err)
+		}
+		existing[strings.ToLower(name)] = nullable == "YES"
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("listing columns of %s: %w", table, err)
+	}
+
+	for _, column := range m.Columns {
+		nullable, ok := existing[strings.ToLower(column.Name)]
+
+		switch {
+		case !ok:
+			stmt := fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN %s", table, column.definition())
+			if _, err := m.Db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("adding column %s to %s: %w", column.Name, table, err)
+			}
+
+			m.Logger.Info("added column", "table", table, "column", column.Name)
+		case column.Nullable && !nullable:
+			stmt := fmt.Sprintf("ALTER TABLE `%s` MODIFY COLUMN %s", table, column.definition())
+			if _, err := m.Db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("making column %s of %s nullable: %w", column.Name, table, err)
+			}
+
+			m.Logger.Info("made column nullable", "table", table, "column", column.Name)
+		}
+	}
+
+	return nil
+}
+
+// ensureIndex creates an index on column in table, unless one with the same name already exists.
+func (m *MySQLStore) ensureIndex(ctx context.Context, table string, column string) error {
+	name := "idx_" + column
+
+	var count int
+	if err := m.Db.QueryRowContext(ctx, INDEX_EXISTS_SQL, table, name).Scan(&count); err != nil {
+		return fmt.Errorf("checking index %s on %s: %w", name, table, err)
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	stmt := fmt.Sprintf("CREATE INDEX `%s` ON `%s` (`%s`)", name, table, column)
+	if _, err := m.Db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("creating index %s on %s: %w", name, table, err)
+	}
+
+	m.Logger.Info("created index", "table", table, "column", column)
+
+	return nil
+}
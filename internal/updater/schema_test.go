@@ -0,0 +1,165 @@
+package updater
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newMockService returns an UpdateService on a sqlmock database, failing the test if any expected
+// statement isn't run.
+func newMockService(t *testing.T) (*UpdateService, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("creating sqlmock: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	s := &UpdateService{
+		BlueTable:     &Table{Name: "blue"},
+		GreenTable:    &Table{Name: "green"},
+		MetadataTable: "metadata",
+		Columns:       DEFAULT_COLUMNS,
+		Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	s.UseDatabase(db)
+
+	return s, mock
+}
+
+func TestEnsureSchemaAddsColumnsAndIndexes(t *testing.T) {
+	tests := []struct {
+		name    string
+		indexes []string
+		// existing holds the indexes already on both tables.
+		existing map[string]bool
+		// missingColumn is a column missing from both tables, to be added, and notNullColumn a
+		// nullable column that is NOT NULL in both tables, to be made nullable.
+		missingColumn string
+		notNullColumn string
+	}{
+		{name: "no indexes"},
+		{name: "missing column", missingColumn: "OffenseCount"},
+		{name: "not null column", notNullColumn: "OffenseCount"},
+		{name: "missing indexes", indexes: []string{"CaseNumber", "ReportDate"}},
+		{
+			name:     "existing index",
+			indexes:  []string{"CaseNumber", "ReportDate"},
+			existing: map[string]bool{"idx_CaseNumber": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newMockService(t)
+			s.Store.(*MySQLStore).Indexes = tt.indexes
+
+			mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS `metadata`")).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			for _, table := range []string{"blue", "green"} {
+				mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS `" + table + "`")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+
+				columns := sqlmock.NewRows([]string{"COLUMN_NAME", "IS_NULLABLE"})
+				for _, c := range s.Columns {
+					nullable := "NO"
+					if c.Nullable && c.Name != tt.notNullColumn {
+						nullable = "YES"
+					}
+					if c.Name != tt.missingColumn {
+						columns.AddRow(c.Name, nullable)
+					}
+				}
+				mock.ExpectQuery(regexp.QuoteMeta(TABLE_COLUMNS_SQL)).
+					WithArgs(table).
+					WillReturnRows(columns)
+				if tt.missingColumn != "" {
+					stmt := "ALTER TABLE `" + table + "` ADD COLUMN `" + tt.missingColumn + "`"
+					mock.ExpectExec(regexp.QuoteMeta(stmt)).
+						WillReturnResult(sqlmock.NewResult(0, 0))
+				}
+				if tt.notNullColumn != "" {
+					stmt := "ALTER TABLE `" + table + "` MODIFY COLUMN `" + tt.notNullColumn + "`"
+					mock.ExpectExec(regexp.QuoteMeta(stmt + " INT NULL")).
+						WillReturnResult(sqlmock.NewResult(0, 0))
+				}
+
+				for _, column := range tt.indexes {
+					name := "idx_" + column
+					count := 0
+					if tt.existing[name] {
+						count = 1
+					}
+					mock.ExpectQuery(regexp.QuoteMeta(INDEX_EXISTS_SQL)).
+						WithArgs(table, name).
+						WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+
+					if count == 0 {
+						stmt := "CREATE INDEX `" + name + "` ON `" + table + "` (`" + column + "`)"
+						mock.ExpectExec(regexp.QuoteMeta(stmt)).
+							WillReturnResult(sqlmock.NewResult(0, 0))
+					}
+				}
+			}
+
+			err := s.Store.EnsureSchema(context.Background(), "blue", "green")
+			if err != nil {
+				t.Fatalf("EnsureSchema: %v", err)
+			}
+		})
+	}
+}
+
+func TestEnsureSchemaRejectsUnknownIndex(t *testing.T) {
+	s, _ := newMockService(t)
+	s.Store.(*MySQLStore).Indexes = []string{"Precinct"}
+
+	if err := s.Store.EnsureSchema(context.Background(), "blue", "green"); err == nil {
+		t.Fatal("EnsureSchema returned no error for an unknown column")
+	}
+}
+
+func TestCreateTableSQLCharset(t *testing.T) {
+	tests := []struct {
+		name       string
+		charset    string
+		collation  string
+		wantSuffix string
+	}{
+		{name: "server default", wantSuffix: ")"},
+		{name: "charset", charset: "utf8mb4", wantSuffix: ") DEFAULT CHARSET=utf8mb4"},
+		{
+			name:       "charset and collation",
+			charset:    "utf8mb4",
+			collation:  "utf8mb4_bin",
+			wantSuffix: ") DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &MySQLStore{
+				Columns:   DEFAULT_COLUMNS,
+				Charset:   tt.charset,
+				Collation: tt.collation,
+			}
+
+			stmt := store.createTableSQL("blue")
+			if !strings.HasSuffix(stmt, tt.wantSuffix) {
+				t.Errorf("createTableSQL() = %q, want suffix %q", stmt, tt.wantSuffix)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package updater
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lorendsnow/updater/internal/s3"
+)
+
+/*
+ *==================================================================================================
+ * Snapshot Constants
+ *==================================================================================================
+ */
+
+// SNAPSHOT_EXTENSION ends the name of every exported snapshot.
+const SNAPSHOT_EXTENSION = ".csv.gz"
+
+/*
+ *==================================================================================================
+ * SnapshotSink Interface
+ *==================================================================================================
+ */
+
+// SnapshotSink stores archived datasets exported as gzipped CSV files, as an alternative to
+// keeping them in archive tables.
+type SnapshotSink interface {
+	// List returns the names of the stored snapshots.
+	List(ctx context.Context) ([]string, error)
+
+	// Write stores data as the snapshot called name.
+	Write(ctx context.Context, name string, data []byte) error
+
+	// Delete removes the snapshot called name.
+	Delete(ctx context.Context, name string) error
+}
+
+// dirSink is a SnapshotSink keeping snapshots as files in a local directory.
+type dirSink struct {
+	dir string
+}
+
+// s3Sink is a SnapshotSink keeping snapshots as objects under a prefix in an S3 bucket.
+type s3Sink struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// newSnapshotSink returns the SnapshotSink for location, which is either an s3://bucket/prefix url
+// or a local directory. S3 credentials are read from the environment.
+func newSnapshotSink(
+	ctx context.Context,
+	location string,
+	httpClient *http.Client,
+) (SnapshotSink, error) {
+	if !strings.HasPrefix(location, s3.SCHEME+"://") {
+		return &dirSink{dir: location}, nil
+	}
+
+	bucket, prefix, err := s3.ParseURL(location)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s3.NewClient(ctx, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &s3Sink{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+func (d *dirSink) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// Write writes data to a temporary file first and renames it into place, so a failed export never
+// leaves a truncated snapshot behind.
+func (d *dirSink) Write(ctx context.Context, name string, data []byte) error {
+	if err := os.MkdirAll(d.dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(d.dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(d.dir, name))
+}
+
+func (d *dirSink) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(d.dir, name))
+}
+
+func (s *s3Sink) List(ctx context.Context) ([]string, error) {
+	keys, err := s.client.ListObjects(ctx, s.bucket, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, key := range keys {
+		// Skip objects in "subdirectories" of the prefix.
+		if name := strings.TrimPrefix(key, s.prefix); !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+func (s *s3Sink) Write(ctx context.Context, name string, data []byte) error {
+	return s.client.PutObject(ctx, s.bucket, s.prefix+name, data, "application/gzip")
+}
+
+func (s *s3Sink) Delete(ctx context.Context, name string) error {
+	return s.client.DeleteObject(ctx, s.bucket, s.prefix+name)
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// exportSnapshot exports the dataset in table to SnapshotSink as a gzipped CSV file called name
+// with SNAPSHOT_EXTENSION appended, then deletes the oldest snapshots beyond ArchiveRetention.
+// Datasets that were already exported are skipped.
+func (s *UpdateService) exportSnapshot(ctx context.Context, table *Table, name string) error {
+	sink, err := newSnapshotSink(ctx, s.SnapshotSink, s.HTTPClient)
+	if err != nil {
+		return fmt.Errorf("opening snapshot sink: %w", err)
+	}
+
+	snapshots, err := snapshotNames(ctx, sink, s.ArchivePrefix)
+	if err != nil {
+		return fmt.Errorf("listing snapshots: %w", err)
+	}
+
+	name += SNAPSHOT_EXTENSION
+	if !slices.Contains(snapshots, name) {
+		data, err := s.tableCSV(ctx, table.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := sink.Write(ctx, name, data); err != nil {
+			return fmt.Errorf("writing snapshot %s: %w", name, err)
+		}
+
+		snapshots = append(snapshots, name)
+		slices.Sort(snapshots)
+		s.Logger.Info(
+			"exported previous dataset",
+			"table",
+			table.Name,
+			"snapshot",
+			strings.TrimSuffix(s.SnapshotSink, "/")+"/"+name,
+			"bytes",
+			len(data),
+		)
+	}
+
+	for len(snapshots) > s.ArchiveRetention {
+		if err := sink.Delete(ctx, snapshots[0]); err != nil {
+			return fmt.Errorf("deleting snapshot %s: %w", snapshots[0], err)
+		}
+
+		s.Logger.Info("deleted expired snapshot", "snapshot", snapshots[0])
+		snapshots = snapshots[1:]
+	}
+
+	return nil
+}
+
+// snapshotNames returns the names of the snapshots in sink exported with prefix, oldest first.
+func snapshotNames(ctx context.Context, sink SnapshotSink, prefix string) ([]string, error) {
+	names, err := sink.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []string
+	for _, name := range names {
+		// Only count files whose suffix is an archive time, in case the prefix is shared.
+		suffix, ok := strings.CutPrefix(name, prefix+"_")
+		suffix, hasExtension := strings.CutSuffix(suffix, SNAPSHOT_EXTENSION)
+		if !ok || !hasExtension {
+			continue
+		}
+		if _, err := time.Parse(ARCHIVE_TIME_FORMAT, suffix); err == nil {
+			snapshots = append(snapshots, name)
+		}
+	}
+
+	slices.Sort(snapshots)
+
+	return snapshots, nil
+}
+
+// tableCSV returns the contents of table as a gzipped CSV file with a header row of column names.
+// NULL values are written as empty fields.
+func (s *UpdateService) tableCSV(ctx context.Context, table string) ([]byte, error) {
+	rows, err := s.Db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM `%s`", table))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", table, err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	writer := csv.NewWriter(gz)
+
+	if err := writer.Write(columns); err != nil {
+		return nil, err
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", table, err)
+		}
+		for i, value := range values {
+			record[i] = value.String
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", table, err)
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
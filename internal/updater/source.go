@@ -0,0 +1,160 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	cfg "github.com/lorendsnow/updater/internal/config"
+)
+
+/*
+ *==================================================================================================
+ * Source Types
+ *==================================================================================================
+ */
+
+// SOURCE_TYPE_CSV is a single CSV file, the default. SOURCE_TYPE_PAGINATED is an API serving the
+// data as a series of CSV pages, each with its own header row.
+const SOURCE_TYPE_CSV = "csv"
+const SOURCE_TYPE_PAGINATED = "paginated"
+
+// MAX_PAGES stops a paginated source that never returns a short page.
+const MAX_PAGES = 10000
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// CountURL downloads and parses the source at url as an update cycle would, counting its records
+// and parse errors without keeping the records or writing anything. The result's Records are
+// empty; Parsed, Skipped, Errors and Stats are filled in.
+func (s *UpdateService) CountURL(ctx context.Context, url string) (ParseResult, error) {
+	return s.fetchSource(ctx, url, true)
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// fetchSource downloads and parses the source at url, following its pages if it is a paginated
+// source. With countOnly the records are counted rather than kept.
+func (s *UpdateService) fetchSource(
+	ctx context.Context,
+	url string,
+	countOnly bool,
+) (ParseResult, error) {
+	source := s.source(url)
+	if source.Type != SOURCE_TYPE_PAGINATED {
+		return s.fetchCSV(ctx, url, url, countOnly)
+	}
+
+	var result ParseResult
+	for page := range MAX_PAGES {
+		pageURL := pageURL(url, page, source.PageSize)
+
+		parsed, err := s.fetchCSV(ctx, url, pageURL, countOnly)
+		if err != nil {
+			return ParseResult{}, err
+		}
+		result.Merge(parsed)
+
+		// A short page is the last one. Rows counts bad rows too, so they don't end it early.
+		if parsed.Stats.Rows < source.PageSize {
+			s.Logger.Debug("fetched paginated source", "url", url, "pages", page+1)
+			return result, nil
+		}
+		if s.Parse.SampleRows > 0 && result.Parsed >= s.Parse.SampleRows {
+			result.Records = result.Records[:min(len(result.Records), s.Parse.SampleRows)]
+			result.Parsed = s.Parse.SampleRows
+			return result, nil
+		}
+	}
+
+	return ParseResult{}, fmt.Errorf("%s returned more than %d full pages", url, MAX_PAGES)
+}
+
+// fetchCSV downloads and parses the CSV file at fetchURL, using the timeout and format of the
+// source at url, which may also be JSON lines. With countOnly the records are counted rather than
+// kept.
+func (s *UpdateService) fetchCSV(
+	ctx context.Context,
+	url string,
+	fetchURL string,
+	countOnly bool,
+) (ParseResult, error) {
+	body, err := s.download(ctx, fetchURL, s.timeout(url))
+	if err != nil {
+		return ParseResult{}, fmt.Errorf("downloading %s: %w", fetchURL, err)
+	}
+	defer body.Close()
+
+	opts := s.parseOptions(url, fetchURL)
+	opts.CountOnly = countOnly
+
+	parsed, err := parse(ctx, body, opts, s.Logger)
+	if err != nil {
+		return ParseResult{}, fmt.Errorf("parsing %s: %w", fetchURL, err)
+	}
+
+	return parsed, nil
+}
+
+// parseOptions returns the options for parsing the CSV file at fetchURL of the source at url: the
+// global options with the source's delimiter, header and encoding in place of the global ones, and
+// the source's format.
+func (s *UpdateService) parseOptions(url, fetchURL string) ParseOptions {
+	opts := s.Parse
+	opts.SourceURL = fetchURL
+	opts.MinOccurDate = s.minOccurDate()
+
+	source := s.source(url)
+	if source.Delimiter != "" {
+		opts.Delimiter, _ = utf8.DecodeRuneInString(source.Delimiter)
+	}
+	if source.Header != nil {
+		opts.NoHeader = !*source.Header
+	}
+	if source.Encoding != "" {
+		opts.Encoding = source.Encoding
+	}
+	opts.Format = source.Format
+
+	return opts
+}
+
+// minOccurDate returns the earliest occur date of records to keep as of now, from MinOccurDate. If
+// the setting is invalid a warning is logged and records of every date are kept.
+func (s *UpdateService) minOccurDate() time.Time {
+	date, err := cfg.ParseMinOccurDate(s.MinOccurDate, time.Now())
+	if err != nil {
+		s.Logger.Warn(
+			"invalid min occur date, keeping records of every date",
+			"value",
+			s.MinOccurDate,
+			"error",
+			err,
+		)
+	}
+
+	return date
+}
+
+// pageURL fills the {page}, {offset} and {limit} placeholders of a paginated source's url template.
+func pageURL(template string, page, pageSize int) string {
+	return strings.NewReplacer(
+		"{page}",
+		strconv.Itoa(page),
+		"{offset}",
+		strconv.Itoa(page*pageSize),
+		"{limit}",
+		strconv.Itoa(pageSize),
+	).Replace(template)
+}
@@ -0,0 +1,130 @@
+package updater
+
+import (
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+)
+
+/*
+ *==================================================================================================
+ * Skip Reasons
+ *==================================================================================================
+ */
+
+// SKIP_REASON_WRONG_COLUMN_COUNT and SKIP_REASON_MISSING_REQUIRED_FIELD name the reasons a row is
+// skipped as a parse error, matching ErrWrongColumnCount and ErrMissingRequiredField. They label
+// the skipped records metric and the skipped breakdown of a cycle.
+const SKIP_REASON_WRONG_COLUMN_COUNT = "wrong_column_count"
+const SKIP_REASON_MISSING_REQUIRED_FIELD = "missing_required_field"
+
+// SKIP_REASONS lists every skip reason.
+var SKIP_REASONS = []string{SKIP_REASON_WRONG_COLUMN_COUNT, SKIP_REASON_MISSING_REQUIRED_FIELD}
+
+/*
+ *==================================================================================================
+ * ParseStats Struct
+ *==================================================================================================
+ */
+
+// ParseStats summarizes the quality of parsed data: how many rows were read, how many were parse
+// errors because they had the wrong number of columns or were missing a required field, how many
+// were padded or truncated to fit under a tolerant ColumnTolerance, and for each Record field how
+// many values were empty, nil, or replaced with the DEFAULT_DATE sentinel.
+type ParseStats struct {
+	Rows            int
+	BadRows         int
+	MissingRequired int
+	FittedRows      int
+	Missing         map[string]int
+}
+
+// Errors returns the number of rows that were parse errors.
+func (p ParseStats) Errors() int {
+	return p.BadRows + p.MissingRequired
+}
+
+// ErrorRate returns the fraction of rows that were parse errors, or zero if no rows were read.
+func (p ParseStats) ErrorRate() float64 {
+	if p.Rows == 0 {
+		return 0
+	}
+
+	return float64(p.Errors()) / float64(p.Rows)
+}
+
+// SkippedByReason returns the number of rows that were parse errors for each of SKIP_REASONS.
+func (p ParseStats) SkippedByReason() map[string]int {
+	return map[string]int{
+		SKIP_REASON_WRONG_COLUMN_COUNT:     p.BadRows,
+		SKIP_REASON_MISSING_REQUIRED_FIELD: p.MissingRequired,
+	}
+}
+
+// Merge adds the counts from other into p.
+func (p *ParseStats) Merge(other ParseStats) {
+	p.Rows += other.Rows
+	p.BadRows += other.BadRows
+	p.MissingRequired += other.MissingRequired
+	p.FittedRows += other.FittedRows
+
+	for field, count := range other.Missing {
+		if p.Missing == nil {
+			p.Missing = make(map[string]int)
+		}
+		p.Missing[field] += count
+	}
+}
+
+// LogValue logs the stats as a group, with missing counts nested under "missing" by field name.
+func (p ParseStats) LogValue() slog.Value {
+	missing := make([]slog.Attr, 0, len(p.Missing))
+	for _, field := range slices.Sorted(maps.Keys(p.Missing)) {
+		missing = append(missing, slog.Int(field, p.Missing[field]))
+	}
+
+	return slog.GroupValue(
+		slog.Int("rows", p.Rows),
+		slog.Int("bad_rows", p.BadRows),
+		slog.Int("missing_required", p.MissingRequired),
+		slog.Int("fitted_rows", p.FittedRows),
+		slog.Attr{Key: "missing", Value: slog.GroupValue(missing...)},
+	)
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// add counts a row read by the parser and the missing values in its record.
+func (p *ParseStats) add(record *Record) {
+	p.Rows++
+
+	for _, c := range DEFAULT_COLUMNS {
+		if isMissing(c.Value(record)) {
+			if p.Missing == nil {
+				p.Missing = make(map[string]int)
+			}
+			p.Missing[c.Field]++
+		}
+	}
+}
+
+// isMissing reports whether a Record field value is empty, nil, or the DEFAULT_DATE sentinel.
+func isMissing(value any) bool {
+	switch v := value.(type) {
+	case string:
+		return v == ""
+	case time.Time:
+		return v.Equal(DEFAULT_DATE)
+	case *float64:
+		return v == nil
+	case *int:
+		return v == nil
+	default:
+		return false
+	}
+}
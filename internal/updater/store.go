@@ -0,0 +1,217 @@
+package updater
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+ *==================================================================================================
+ * Store Interface
+ *==================================================================================================
+ */
+
+// Store holds the blue/green tables for an UpdateService, along with when each was last updated.
+// MySQLStore is the real implementation; MemoryStore keeps everything in memory, so the scheduling
+// and parsing logic can run without a database.
+type Store interface {
+	// EnsureSchema creates the given tables, and anything else the store needs, if missing.
+	EnsureSchema(ctx context.Context, tables ...string) error
+
+	// WriteRecords replaces the contents of table with records.
+	WriteRecords(ctx context.Context, table string, records []Record) error
+
+	// StreamRecords replaces the contents of table with records as they are produced, returning
+	// how many were written. If records yields an error, table is left unchanged.
+	StreamRecords(ctx context.Context, table string, records iter.Seq2[Record, error]) (int, error)
+
+	// Swap records that table was updated at t, which makes it the active table when t is the most
+	// recent update.
+	Swap(ctx context.Context, table string, t time.Time) error
+
+	// Stage records that a NoSwap cycle finished writing table at t, or clears the staging if t is
+	// zero.
+	Stage(ctx context.Context, table string, t time.Time) error
+
+	// MarkWritten records that table was about to be written at t.
+	MarkWritten(ctx context.Context, table string, t time.Time) error
+
+	// Metadata returns the times recorded for every table with any, by name.
+	Metadata(ctx context.Context) (map[string]TableMetadata, error)
+
+	// CountRows returns the number of records in table.
+	CountRows(ctx context.Context, table string) (int, error)
+}
+
+// TableMetadata holds the times a Store recorded for a table, each zero if never recorded.
+type TableMetadata struct {
+	LastUpdated time.Time
+	StagedAt    time.Time
+	WrittenAt   time.Time
+}
+
+/*
+ *==================================================================================================
+ * MySQLStore Struct
+ *==================================================================================================
+ */
+
+// MySQLStore is a Store keeping each table in a MySQL table, and update times in MetadataTable.
+type MySQLStore struct {
+	Db                *sql.DB
+	MetadataTable     string
+	Columns           []Column
+	Indexes           []string
+	WriteMode         string
+	EmptyOffenseCount string
+	EmptyStrings      string
+	Charset           string
+	Collation         string
+	TxOptions         *sql.TxOptions
+	Logger            *slog.Logger
+
+	// RecreateMissingTables lets WriteRecords recreate a table that was dropped.
+	RecreateMissingTables bool
+}
+
+/*
+ *==================================================================================================
+ * MemoryStore Struct
+ *==================================================================================================
+ */
+
+// MemoryStore is a Store keeping tables in memory, for running an UpdateService without a
+// database. It is safe for concurrent use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	tables   map[string][]Record
+	metadata map[string]TableMetadata
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tables:   make(map[string][]Record),
+		metadata: make(map[string]TableMetadata),
+	}
+}
+
+// EnsureSchema creates empty tables for any of tables that don't exist.
+func (m *MemoryStore) EnsureSchema(ctx context.Context, tables ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, table := range tables {
+		if _, ok := m.tables[table]; !ok {
+			m.tables[table] = nil
+		}
+	}
+
+	return nil
+}
+
+// WriteRecords replaces the contents of table with a copy of records.
+func (m *MemoryStore) WriteRecords(ctx context.Context, table string, records []Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tables[table]; !ok {
+		return fmt.Errorf("table %s does not exist", table)
+	}
+	m.tables[table] = slices.Clone(records)
+
+	return nil
+}
+
+// StreamRecords replaces the contents of table with records once every record has been produced.
+func (m *MemoryStore) StreamRecords(
+	ctx context.Context,
+	table string,
+	records iter.Seq2[Record, error],
+) (int, error) {
+	var written []Record
+	for record, err := range records {
+		if err != nil {
+			return 0, err
+		}
+		written = append(written, record)
+	}
+
+	if err := m.WriteRecords(ctx, table, written); err != nil {
+		return 0, err
+	}
+
+	return len(written), nil
+}
+
+// Swap records that table was updated at t.
+func (m *MemoryStore) Swap(ctx context.Context, table string, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	times := m.metadata[table]
+	times.LastUpdated = t
+	m.metadata[table] = times
+
+	return nil
+}
+
+// Stage records that table was staged at t, or clears the staging if t is zero.
+func (m *MemoryStore) Stage(ctx context.Context, table string, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	times := m.metadata[table]
+	times.StagedAt = t
+	m.metadata[table] = times
+
+	return nil
+}
+
+// MarkWritten records that table was about to be written at t.
+func (m *MemoryStore) MarkWritten(ctx context.Context, table string, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	times := m.metadata[table]
+	times.WrittenAt = t
+	m.metadata[table] = times
+
+	return nil
+}
+
+// Metadata returns the times recorded by Swap, Stage and MarkWritten.
+func (m *MemoryStore) Metadata(ctx context.Context) (map[string]TableMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return maps.Clone(m.metadata), nil
+}
+
+// CountRows returns the number of records in table.
+func (m *MemoryStore) CountRows(ctx context.Context, table string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records, ok := m.tables[table]
+	if !ok {
+		return 0, fmt.Errorf("table %s does not exist", table)
+	}
+
+	return len(records), nil
+}
+
+// Records returns a copy of the records in table.
+func (m *MemoryStore) Records(table string) []Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.tables[table])
+}
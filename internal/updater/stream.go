@@ -0,0 +1,215 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"time"
+)
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// IngestStream replaces the contents of table with the records parsed from the CSV data in r,
+// returning how many were written. Rather than parsing every record before writing any, rows are
+// parsed as they are read and written INSERT_BATCH_SIZE at a time, so memory use doesn't grow with
+// the size of the data and writing starts with the first batch.
+//
+// Rows are parsed with the service's parse options, apart from Workers, since rows are parsed in
+// order as they are read. Parse errors are skipped as in an update cycle, and if more of the rows
+// were parse errors than MaxParseErrorRate allows, a ParseErrorRateError is returned.
+//
+// Everything is written in a single transaction, which is rolled back if reading, parsing or
+// writing fails, leaving the table's previous contents in place. The table isn't made active, but
+// the inactive table is staged once written, for Promote or PromoteTable to make active. Writing
+// the active table in place is refused with ErrTableActive, since its data would change without
+// being validated. It returns ErrUpdateInProgress if this service is running an update.
+func (s *UpdateService) IngestStream(ctx context.Context, table string, r io.Reader) (int, error) {
+	if !s.updateMu.TryLock() {
+		return 0, ErrUpdateInProgress
+	}
+	defer s.updateMu.Unlock()
+
+	if err := s.LoadMetadata(ctx); err != nil {
+		return 0, err
+	}
+
+	if table == s.activeTable().Name {
+		return 0, fmt.Errorf("cannot ingest into %s: %w", table, ErrTableActive)
+	}
+	inactive := s.inactiveTable()
+	if table == inactive.Name {
+		if err := s.unstage(ctx, inactive); err != nil {
+			return 0, err
+		}
+		if err := s.markWritten(ctx, inactive, time.Now()); err != nil {
+			return 0, err
+		}
+	}
+
+	var result ParseResult
+	opts := s.Parse
+	opts.MinOccurDate = s.minOccurDate()
+
+	written, err := s.Store.StreamRecords(ctx, table, s.streamCSV(ctx, r, opts, &result))
+	if err != nil {
+		return 0, err
+	}
+	if table == inactive.Name {
+		if err := s.stage(ctx, inactive, time.Now()); err != nil {
+			return 0, err
+		}
+	}
+
+	s.Logger.Info(
+		"ingested stream",
+		"table",
+		table,
+		"records",
+		written,
+		"stats",
+		result.Stats,
+		"skipped",
+		result.Skipped,
+		"filtered",
+		result.Filtered,
+	)
+
+	return written, nil
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// streamable reports whether a cycle over urls can stream its records into the table as they are
+// parsed: there's a single url, and it's a single CSV file rather than pages or JSON lines.
+func (s *UpdateService) streamable(urls []string) bool {
+	if len(urls) != 1 {
+		return false
+	}
+	source := s.source(urls[0])
+
+	return source.Type != SOURCE_TYPE_PAGINATED && !strings.EqualFold(source.Format, FORMAT_JSONL)
+}
+
+// streamSource downloads the CSV file at url and streams its records into table as they are
+// parsed, as IngestStream does, returning the parse result, without its records, and how many
+// were written. If the parse error rate is too high, or anything fails once writing starts, the
+// write is rolled back and table keeps its previous contents.
+func (s *UpdateService) streamSource(
+	ctx context.Context,
+	url string,
+	table *Table,
+) (ParseResult, int, error) {
+	body, err := s.download(ctx, url, s.timeout(url))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ParseResult{}, 0, err
+		}
+		s.urlFailed(url)
+		err = fmt.Errorf("downloading %s: %w", url, err)
+		return ParseResult{}, 0, fmt.Errorf("%w: %w", ErrAllURLsFailed, err)
+	}
+	defer body.Close()
+	s.urlSucceeded(url)
+
+	if err := s.unstage(ctx, table); err != nil {
+		return ParseResult{}, 0, err
+	}
+	if err := s.markWritten(ctx, table, time.Now()); err != nil {
+		return ParseResult{}, 0, err
+	}
+
+	var result ParseResult
+	records := func(yield func(Record, error) bool) {
+		var i int
+		for record, err := range s.streamCSV(ctx, body, s.parseOptions(url, url), &result) {
+			if err == nil && i < s.LogSampleRows {
+				s.Logger.Debug("sample record", "index", i, "record", record)
+				i++
+			}
+			if !yield(record, err) {
+				return
+			}
+		}
+	}
+
+	written, err := s.Store.StreamRecords(ctx, table.Name, records)
+	s.logSkippedRows(url, result)
+	s.logParseResult(result)
+	var rateErr *ParseErrorRateError
+	if errors.As(err, &rateErr) {
+		return ParseResult{}, 0, err
+	}
+	if err != nil {
+		return ParseResult{}, 0, fmt.Errorf("streaming %s into %s: %w", url, table.Name, err)
+	}
+
+	return result, written, nil
+}
+
+// streamCSV returns an iterator over the records parsed from the CSV data in r with opts, counting
+// every row in result. It yields an error, and stops, if reading the data fails, ctx is cancelled,
+// or once every row is read the parse error rate is too high.
+func (s *UpdateService) streamCSV(
+	ctx context.Context,
+	r io.Reader,
+	opts ParseOptions,
+	result *ParseResult,
+) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		interner := newInterner(opts)
+		ingestedAt := time.Now().UTC()
+
+		reader, err := newCSVReader(r, opts)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			yield(Record{}, err)
+			return
+		}
+
+		for opts.SampleRows <= 0 || result.Parsed < opts.SampleRows {
+			if result.Stats.Rows%CANCEL_CHECK_ROWS == 0 {
+				if err := ctx.Err(); err != nil {
+					yield(Record{}, err)
+					return
+				}
+			}
+
+			row, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				yield(Record{}, fmt.Errorf("reading row: %w", err))
+				return
+			}
+			line, _ := reader.FieldPos(0)
+
+			record, ok := result.parseRecord(row, line, opts, interner, s.Logger)
+			if !ok {
+				continue
+			}
+
+			record.IngestedAt = ingestedAt
+			if !yield(record, nil) {
+				return
+			}
+		}
+
+		if err := s.checkParseErrorRate(*result); err != nil {
+			yield(Record{}, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIngestStream(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup runs after a cycle has made blue active and a NoSwap cycle has staged green.
+		setup       func(t *testing.T, s *UpdateService, store *MemoryStore)
+		table       string
+		data        string
+		wantErr     error
+		wantWritten int
+		wantStaged  bool
+	}{
+		{
+			name:        "inactive table",
+			table:       "green",
+			data:        mixedCSV(5, nil, nil),
+			wantWritten: 5,
+			wantStaged:  true,
+		},
+		{
+			name:       "active table",
+			table:      "blue",
+			data:       mixedCSV(5, nil, nil),
+			wantErr:    ErrTableActive,
+			wantStaged: true,
+		},
+		{
+			name: "activated by another process",
+			setup: func(t *testing.T, s *UpdateService, store *MemoryStore) {
+				if err := store.Swap(context.Background(), "green", time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			table:   "green",
+			data:    mixedCSV(5, nil, nil),
+			wantErr: ErrTableActive,
+		},
+		{
+			name: "update in progress",
+			setup: func(t *testing.T, s *UpdateService, store *MemoryStore) {
+				s.updateMu.Lock()
+				t.Cleanup(s.updateMu.Unlock)
+			},
+			table:      "green",
+			data:       mixedCSV(5, nil, nil),
+			wantErr:    ErrUpdateInProgress,
+			wantStaged: true,
+		},
+		{
+			name: "parse error rate exceeded",
+			setup: func(t *testing.T, s *UpdateService, store *MemoryStore) {
+				s.MaxParseErrorRate = 0
+			},
+			table:   "green",
+			data:    mixedCSV(5, []int{3}, nil),
+			wantErr: &ParseErrorRateError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			s := newTestService(t, store, serveCSV(t, mixedCSV(3, nil, nil)))
+			ctx := context.Background()
+
+			for i, noSwap := range []bool{false, true} {
+				s.NoSwap = noSwap
+				if _, err := s.runCycle(ctx); err != nil {
+					t.Fatalf("cycle %d: %v", i, err)
+				}
+			}
+			if tt.setup != nil {
+				tt.setup(t, s, store)
+			}
+
+			written, err := s.IngestStream(ctx, tt.table, strings.NewReader(tt.data))
+			var rateErr *ParseErrorRateError
+			switch {
+			case errors.As(tt.wantErr, &rateErr):
+				if !errors.As(err, &rateErr) {
+					t.Fatalf("IngestStream() error = %v, want a ParseErrorRateError", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("IngestStream() error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("IngestStream: %v", err)
+			}
+			if written != tt.wantWritten {
+				t.Errorf("IngestStream() wrote %d records, want %d", written, tt.wantWritten)
+			}
+
+			if got := s.staged(s.GreenTable); got != tt.wantStaged {
+				t.Errorf("green staged = %t, want %t", got, tt.wantStaged)
+			}
+		})
+	}
+}
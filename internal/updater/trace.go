@@ -0,0 +1,129 @@
+package updater
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// requestTrace times the phases of a download for HTTPTrace: DNS resolution, connecting, the TLS
+// handshake, waiting for the first response byte, and transferring the body. A nil requestTrace
+// records nothing, so tracing can be left off without checks at every call.
+type requestTrace struct {
+	url    string
+	logger *slog.Logger
+
+	// mu guards the fields below, since the transport may dial on another goroutine.
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	dns          time.Duration
+	connect      time.Duration
+	tls          time.Duration
+	firstByte    time.Time
+	reused       bool
+}
+
+// newRequestTrace starts timing a download of url, returning ctx with the trace attached. If
+// enabled is false it returns ctx unchanged and a nil requestTrace.
+func newRequestTrace(
+	ctx context.Context,
+	enabled bool,
+	url string,
+	logger *slog.Logger,
+) (context.Context, *requestTrace) {
+	if !enabled {
+		return ctx, nil
+	}
+
+	t := &requestTrace{url: url, logger: logger, start: time.Now()}
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.since(&t.dns, t.dnsStart) },
+		ConnectStart: func(string, string) {
+			t.mark(&t.connectStart)
+		},
+		ConnectDone: func(string, string, error) {
+			t.since(&t.connect, t.connectStart)
+		},
+		TLSHandshakeStart: func() { t.mark(&t.tlsStart) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.since(&t.tls, t.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.reused = info.Reused
+		},
+		GotFirstResponseByte: func() { t.mark(&t.firstByte) },
+	})
+
+	return ctx, t
+}
+
+// mark records the current time in field.
+func (t *requestTrace) mark(field *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	*field = time.Now()
+}
+
+// since records the time elapsed from start in field.
+func (t *requestTrace) since(field *time.Duration, start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	*field = time.Since(start)
+}
+
+// log logs the timings of the download at debug level once it has finished, either with the body
+// read and closed or with err. Phases that didn't happen, such as DNS and connecting on a reused
+// connection, are logged as zero.
+func (t *requestTrace) log(err error) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	attrs := []any{
+		"url",
+		t.url,
+		"reused connection",
+		t.reused,
+		"dns",
+		t.dns,
+		"connect",
+		t.connect,
+		"tls",
+		t.tls,
+	}
+	if !t.firstByte.IsZero() {
+		attrs = append(
+			attrs,
+			"first byte",
+			t.firstByte.Sub(t.start),
+			"transfer",
+			time.Since(t.firstByte),
+		)
+	}
+	attrs = append(attrs, "total", time.Since(t.start))
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+
+	t.logger.Debug("download timing", attrs...)
+}
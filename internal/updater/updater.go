@@ -3,16 +3,35 @@
 package updater
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"net/http"
+	"slices"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-sql-driver/mysql"
+	"github.com/lorendsnow/updater/internal/backoff"
 	cfg "github.com/lorendsnow/updater/internal/config"
 )
 
+// REDACTED replaces secrets in logged values.
+const REDACTED = cfg.REDACTED
+
+// CONNECT_RETRY_BASE_DELAY and CONNECT_RETRY_MAX_DELAY bound the backoff between attempts to
+// connect to the database at startup.
+const CONNECT_RETRY_BASE_DELAY = 1 * time.Second
+const CONNECT_RETRY_MAX_DELAY = 30 * time.Second
+
+// URL_FAILURE_ABORT fails an update cycle as soon as a CSV url fails to download, the default.
+// URL_FAILURE_SKIP leaves a url that fails out of the cycle, writing the table from the rest.
+const URL_FAILURE_ABORT = "abort"
+const URL_FAILURE_SKIP = "skip"
+
 // UpdateService periodically downloads csv files from the City's website and
 // updates the database.
 //
@@ -26,22 +45,240 @@ import (
 // different tables and maintaining which one was most recently updated for the
 // repository to check before querying.
 //
+// The active table always holds the last dataset that was fully written and validated. Every
+// write goes to the inactive table inside a single transaction, and a table only becomes active
+// once its write has committed and passed validation, so a failed download, a parse error, a
+// database error partway through writing or a shutdown leaves the previous dataset serving.
+// Nothing but an update cycle, Promote, PromoteTable or Rollback changes which table is active,
+// and Rollback refuses a table that has been written since it was last active.
+//
 // In the future this should probably push updates to the repository instead of
 // the repository pulling the table to use from the database. This could be tied
 // into a cache used by the repository, or via a message/event type of service.
 type UpdateService struct {
 	CheckEvery string
+
+	// Cron schedules updates with a cron expression instead of every CheckEvery interval,
+	// evaluated in CronTimezone, or the local time zone if it is blank.
+	Cron         string
+	CronTimezone string
+
+	CSVUrls    []string
 	BlueTable  *Table
 	GreenTable *Table
-	Db         *sql.DB
+
+	// MetadataTable records when each of the blue/green tables was last updated.
+	MetadataTable string
+
+	// ArchiveRetention is the number of previous datasets kept in dated tables named after
+	// ArchivePrefix, copied from the active table before each swap. Zero disables archival.
+	ArchiveRetention int
+	ArchivePrefix    string
+
+	// SnapshotSink, if set, is a local directory or s3://bucket/prefix url that archived datasets
+	// are exported to as gzipped CSV files, instead of being kept in archive tables.
+	SnapshotSink string
+
+	Db *sql.DB
+
+	// Store holds the blue/green tables and their update times. UseDatabase sets it to a
+	// MySQLStore on Db; setting it to a MemoryStore runs the service without a database, apart
+	// from check queries and archival.
+	Store Store
+
+	// ConnectRetries and ConnectMaxWait bound how long ConnectToDatabase keeps retrying a
+	// database that isn't reachable yet. A zero ConnectMaxWait only limits the number of retries.
+	ConnectRetries int
+	ConnectMaxWait time.Duration
+
 	Logger     *slog.Logger
+	HTTPClient *http.Client
+
+	// HTTPTrace logs how long each phase of every HTTP download took at debug level: DNS
+	// resolution, connecting, the TLS handshake, the first response byte and the transfer.
+	HTTPTrace bool
+
+	// HTTPTimeout bounds each download attempt, including reading the body, unless the url's
+	// source sets its own timeout.
+	HTTPTimeout time.Duration
+
+	// Sources holds the per-url settings of the configured sources, keyed by url.
+	Sources map[string]cfg.SourceConfig
+
+	Retries int
+
+	// NetworkRetries is the number of retries for DNS failures and refused connections, counted
+	// separately from Retries.
+	NetworkRetries int
+
+	// RetryBudget caps the retries, of either kind, of all downloads in a cycle combined. Zero is
+	// unlimited.
+	RetryBudget int
+
+	// MaxRetryAfter caps the wait asked for by the Retry-After header of a 429 or 503 response,
+	// which is used in place of the backoff. Zero ignores the header.
+	MaxRetryAfter time.Duration
+
+	Indexes   []string
+	WriteMode string
+
+	// EmptyOffenseCount controls whether empty offense counts are written as NULL or 0.
+	EmptyOffenseCount string
+
+	// EmptyStrings controls whether empty text fields are written as empty strings or NULL.
+	EmptyStrings string
+
+	// Charset and Collation are the default charset and collation of created tables.
+	Charset   string
+	Collation string
+
+	// RecreateMissingTables recreates a blue/green table that was dropped while the service runs
+	// when it is next written, rather than failing the cycle.
+	RecreateMissingTables bool
+
+	TxOptions *sql.TxOptions
+	Parse     ParseOptions
+	Columns   []Column
+
+	// MinOccurDate is the service.min-occur-date setting, resolved into Parse.MinOccurDate each
+	// time a file is parsed so an age keeps counting back from the current day.
+	MinOccurDate string
+
+	// InitialDelay is how long Run waits before the first update.
+	InitialDelay time.Duration
+
+	// ShutdownTimeout is how long an update in progress when Run's context is cancelled may keep
+	// running before it is cancelled too, so it can finish writing and swapping.
+	ShutdownTimeout time.Duration
+
+	// LogSampleRows is the number of parsed records logged at debug level each cycle, for checking
+	// the parsing at a glance. Zero logs none.
+	LogSampleRows int
+
+	// LimitURLs processes only the first LimitURLs CSV urls each cycle, for trying out a
+	// configuration quickly. A table written from fewer than all of the urls is never made active.
+	// Zero processes every url.
+	LimitURLs int
+
+	// URLFailure is URL_FAILURE_ABORT or URL_FAILURE_SKIP, deciding whether a CSV url that fails to
+	// download fails the cycle or is left out of the table. Once every url has failed, the cycle
+	// returns an error wrapping ErrAllURLsFailed before anything is written, keeping the previous
+	// table active. Aborting stops at the first failure, so it only gets there with a single url.
+	// Empty aborts.
+	URLFailure string
+
+	// URLMaxFailureAge lets a cycle leave out a CSV url that has been failing for longer than this,
+	// counted from its first failure since it last downloaded, rather than aborting as
+	// URL_FAILURE_ABORT would, so one broken file doesn't hold back the others indefinitely. The
+	// table must still pass validation. Zero never leaves a failing url out.
+	URLMaxFailureAge time.Duration
+
+	// NoSwap writes and validates the inactive table each cycle without making it active, staging
+	// it for review. Promote makes a staged table active.
+	NoSwap bool
+
+	// SwapDelay is how long a cycle waits between writing and validating the new table and making
+	// it active, so readers can finish in-flight queries against the previous table. Cancelling
+	// the cycle during the wait fails it, leaving the previous table active. Zero swaps right away.
+	SwapDelay time.Duration
+
+	// MaxParseErrorRate is the largest fraction of rows that may be parse errors before a cycle is
+	// failed without writing anything.
+	MaxParseErrorRate float64
+
+	// MinExpectedRows and Checks are the assertions ValidateTable runs against a newly written
+	// table before it is made active.
+	MinExpectedRows int
+	Checks          []cfg.CheckConfig
+
+	// UpdateOnStart runs the first update as soon as Run starts (after InitialDelay). When false,
+	// the first update waits for a full CheckEvery interval.
+	UpdateOnStart bool
+
+	// MaxAge is how old the active table may be at startup before it is reported as stale, and
+	// UpdateWhenStale runs the first update immediately when it is. Zero disables the check.
+	MaxAge          time.Duration
+	UpdateWhenStale bool
+
+	// mu guards CheckEvery, Cron, CronTimezone, CSVUrls and Sources, which may be changed by
+	// ApplyConfig while Run is active, and lastError, which is read by the health handler while
+	// Run is active.
+	mu        sync.Mutex
+	lastError *CycleError
+
+	// subscribersMu guards the subscriber channels, which are closed once Run returns, and the
+	// last published event, which is replayed to new subscribers on request.
+	subscribersMu     sync.Mutex
+	subscribers       []chan TableChangeEvent
+	subscribersClosed bool
+	lastEvent         *TableChangeEvent
+
+	// scheduleChanged signals Run to reschedule after ApplyConfig changes the interval or cron
+	// expression.
+	scheduleChanged chan struct{}
+
+	// urlFailingSince holds when each CSV url that is failing first failed since it last
+	// downloaded. It is only used by update cycles, so it is guarded by updateMu.
+	urlFailingSince map[string]time.Time
+
+	// updateMu is held for the whole download, write and swap sequence, and by Rollback, so only
+	// one of them changes the tables at a time.
+	updateMu sync.Mutex
+
+	// tablesMu guards the LastUpdated, StagedAt and WrittenAt times of BlueTable and GreenTable,
+	// which are written by update cycles, rollbacks and LoadMetadata while the health and status
+	// handlers read them through LastUpdatedTable and LastUpdated.
+	tablesMu sync.RWMutex
 }
 
+// ErrUpdateInProgress is returned by TriggerUpdate and Rollback when an update is already running.
+var ErrUpdateInProgress = errors.New("update already in progress")
+
+// ErrAllURLsFailed is returned by an update cycle in which every CSV url failed to download, as
+// distinct from urls that downloaded but held no records.
+var ErrAllURLsFailed = errors.New("every csv url failed")
+
 // Table represents one of the two blue/green tables the UpdateService will
-// update, holding the table name and its last update datetime
+// update, holding the table name and its last update datetime. The times of the UpdateService's
+// tables are guarded by its tablesMu, so read them through its methods while it may be running.
 type Table struct {
 	Name        string
 	LastUpdated time.Time
+
+	// StagedAt is when a NoSwap cycle last finished writing the table, if it hasn't been
+	// written or made active since.
+	StagedAt time.Time
+
+	// WrittenAt is when the table was last about to be written. Once it is after LastUpdated the
+	// table no longer holds the dataset it last served, whether or not the write finished.
+	WrittenAt time.Time
+}
+
+// CycleStats summarizes a successful update cycle. Partial cycles wrote Table from only some of
+// the CSV urls, because of LimitURLs, and left the previous table active. Staged cycles wrote all
+// of them but left Table inactive because of NoSwap, for Promote to make active.
+//
+// FailedURLs counts the urls left out of Table because they failed to download, either because
+// URLFailure is URL_FAILURE_SKIP or because they had been failing for longer than
+// URLMaxFailureAge. SkippedByReason breaks Skipped down by SKIP_REASONS.
+type CycleStats struct {
+	Table           string         `json:"table"`
+	Partial         bool           `json:"partial,omitempty"`
+	Staged          bool           `json:"staged,omitempty"`
+	URLs            int            `json:"urls"`
+	FailedURLs      int            `json:"failed_urls,omitempty"`
+	Records         int            `json:"records"`
+	Skipped         int            `json:"skipped"`
+	SkippedByReason map[string]int `json:"skipped_by_reason"`
+	ParseErrorRate  float64        `json:"parse_error_rate"`
+	Started         time.Time      `json:"started"`
+	Finished        time.Time      `json:"finished"`
+}
+
+// CycleError describes the most recent failed update cycle.
+type CycleError struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
 }
 
 // NewUpdateService creates a new UpdateService with the given update interval.
@@ -49,49 +286,399 @@ type Table struct {
 // The UpdateService will check for updates every updateEvery duration, and
 // will use the blue and green tables to store the data.
 func NewUpdateService(config *cfg.Config, logger *slog.Logger) *UpdateService {
+	logger = logger.WithGroup("updater")
+
+	timeout, err := time.ParseDuration(config.HTTP.Timeout)
+	if err != nil {
+		logger.Warn(
+			"invalid http timeout, using default",
+			"timeout",
+			config.HTTP.Timeout,
+			"default",
+			DEFAULT_HTTP_TIMEOUT,
+		)
+		timeout = DEFAULT_HTTP_TIMEOUT
+	}
+
+	if config.Service.SampleRows > 0 {
+		logger.Warn(
+			"sampling is enabled, tables will only hold the first rows of each csv",
+			"sample-rows",
+			config.Service.SampleRows,
+		)
+	}
+	if config.Service.LimitURLs > 0 {
+		logger.Warn(
+			"url limit is enabled, tables written from a subset of the urls won't be made active",
+			"limit-urls",
+			config.Service.LimitURLs,
+		)
+	}
+
+	var delimiter rune
+	if config.Service.Delimiter != "" {
+		delimiter, _ = utf8.DecodeRuneInString(config.Service.Delimiter)
+	}
+
+	urls, err := config.AllCSVUrls()
+	if err != nil {
+		logger.Error("unable to load csv urls file, using inline urls only", "error", err)
+		urls = config.Service.CSVUrls
+	}
+
+	columns, err := ColumnsFromConfig(config)
+	if err != nil {
+		logger.Error("invalid column configuration, using default columns", "error", err)
+		columns = DEFAULT_COLUMNS
+	}
+
+	isolation, err := config.IsolationLevel()
+	if err != nil {
+		logger.Warn("invalid isolation level, using server default", "error", err)
+	}
+
 	return &UpdateService{
-		CheckEvery: config.Service.CheckInterval,
-		BlueTable:  &Table{Name: config.Service.BlueTable},
-		GreenTable: &Table{Name: config.Service.GreenTable},
-		Logger:     logger.WithGroup("updater"),
+		CheckEvery:       checkInterval(config, logger),
+		Cron:             config.Service.Cron,
+		CronTimezone:     config.Service.CronTimezone,
+		CSVUrls:          slices.Clone(urls),
+		Sources:          sourcesByURL(config),
+		BlueTable:        &Table{Name: config.Service.BlueTable},
+		GreenTable:       &Table{Name: config.Service.GreenTable},
+		MetadataTable:    config.Service.MetadataTable,
+		ArchiveRetention: config.Service.ArchiveRetention,
+		ConnectRetries:   config.Database.ConnectRetries,
+		ConnectMaxWait: optionalDuration(
+			config.Database.ConnectMaxWait,
+			"connect-max-wait",
+			logger,
+		),
+		ArchivePrefix:  config.Service.ArchivePrefix,
+		SnapshotSink:   config.Service.SnapshotSink,
+		Logger:         logger,
+		HTTPClient:     newHTTPClient(config, logger),
+		HTTPTrace:      config.HTTP.Trace,
+		HTTPTimeout:    timeout,
+		Retries:        config.HTTP.Retries,
+		NetworkRetries: config.HTTP.NetworkRetries,
+		RetryBudget:    config.HTTP.RetryBudget,
+		MaxRetryAfter: optionalDuration(
+			config.HTTP.MaxRetryAfter,
+			"http.max-retry-after",
+			logger,
+		),
+		Indexes:           slices.Clone(config.Database.Indexes),
+		WriteMode:         config.Service.WriteMode,
+		EmptyOffenseCount: config.Database.EmptyOffenseCount,
+		EmptyStrings:      config.Database.EmptyStrings,
+		Charset:           config.Database.Charset,
+		Collation:         config.Database.Collation,
+		TxOptions:         &sql.TxOptions{Isolation: isolation},
+		Columns:           columns,
+		InitialDelay:      optionalDuration(config.Service.InitialDelay, "initial-delay", logger),
+		ShutdownTimeout: optionalDuration(
+			config.Service.ShutdownTimeout,
+			"shutdown-timeout",
+			logger,
+		),
+		UpdateOnStart:   config.Service.UpdateOnStart,
+		MaxAge:          optionalDuration(config.Service.MaxAge, "max-age", logger),
+		UpdateWhenStale: config.Service.UpdateWhenStale,
+		LogSampleRows:   config.Service.LogSampleRows,
+		LimitURLs:       config.Service.LimitURLs,
+		URLFailure:      config.Service.URLFailure,
+		URLMaxFailureAge: optionalDuration(
+			config.Service.URLMaxFailureAge,
+			"url-max-failure-age",
+			logger,
+		),
+		NoSwap:            config.Service.NoSwap,
+		SwapDelay:         optionalDuration(config.Service.SwapDelay, "swap-delay", logger),
+		MaxParseErrorRate: config.Service.MaxParseErrorRate,
+		MinExpectedRows:   config.Service.MinExpectedRows,
+		Checks:            slices.Clone(config.Service.Checks),
+		Parse: ParseOptions{
+			InternStrings:   config.Service.InternStrings,
+			SampleRows:      config.Service.SampleRows,
+			RequiredFields:  slices.Clone(config.Service.RequiredFields),
+			ColumnTolerance: config.Service.ColumnTolerance,
+			Neighborhoods:   slices.Clone(config.Service.NeighborhoodFilter),
+			Workers:         config.Service.ParseWorkers,
+			Delimiter:       delimiter,
+			NoHeader:        !config.Service.Header,
+			Encoding:        config.Service.Encoding,
+		},
+		MinOccurDate:          config.Service.MinOccurDate,
+		RecreateMissingTables: config.Database.RecreateMissingTables,
+		scheduleChanged:       make(chan struct{}, 1),
 	}
 }
 
-// LastUpdatedTable returns the name of the table that was most recently updated.
+// Run creates the blue/green tables if needed, then updates the database after InitialDelay (or
+// once the first update is due if UpdateOnStart is false) and again every CheckEvery interval, or
+// each time the Cron expression comes due, until ctx is cancelled. Updates that come due while the
+// previous one is still running are skipped. Subscriber channels are closed when Run returns.
+//
+// If the active table is older than MaxAge at startup a warning is logged, and with
+// UpdateWhenStale the first update runs immediately, skipping InitialDelay.
+//
+// Changes to the schedule made through ApplyConfig reschedule the next update, while changes to
+// the CSV urls are picked up at the start of the next update.
+//
+// An update in progress when ctx is cancelled gets ShutdownTimeout to finish before it is
+// cancelled as well, and Run returns once it has stopped.
+func (s *UpdateService) Run(ctx context.Context) error {
+	defer s.closeSubscribers()
+
+	cycleCtx, stopCycles := s.cycleContext(ctx)
+	defer stopCycles()
+
+	sched, err := s.schedule()
+	if err != nil {
+		return err
+	}
+
+	if err := s.Store.EnsureSchema(ctx, s.BlueTable.Name, s.GreenTable.Name); err != nil {
+		return fmt.Errorf("ensuring schema: %w", err)
+	}
+
+	updateNow := s.UpdateOnStart
+	delay := s.InitialDelay
+	if s.isStale(ctx) && s.UpdateWhenStale {
+		s.Logger.Info("data is stale, updating immediately")
+		updateNow = true
+		delay = 0
+	}
+
+	if delay > 0 {
+		s.Logger.Info("waiting before first update", "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			s.Logger.Info("stopping update loop")
+			return nil
+		case <-time.After(delay):
+		}
+	}
+
+	next := sched.Next(time.Now())
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	s.Logger.Info("starting update loop", "schedule", sched)
+	if updateNow {
+		s.scheduledUpdate(ctx, cycleCtx)
+	} else {
+		s.Logger.Info("waiting for the first scheduled update", "next", next)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.Logger.Info("stopping update loop")
+			return nil
+		case <-timer.C:
+			s.scheduledUpdate(ctx, cycleCtx)
+			next = nextRun(sched, next, time.Now())
+			timer.Reset(time.Until(next))
+			s.Logger.Debug("scheduled next update", "next", next)
+		case <-s.scheduleChanged:
+			changed, err := s.schedule()
+			if err != nil {
+				s.Logger.Error("unable to reschedule, keeping current schedule", "error", err)
+				continue
+			}
+			sched = changed
+			next = sched.Next(time.Now())
+			timer.Reset(time.Until(next))
+			s.Logger.Info("rescheduled updates", "schedule", sched, "next", next)
+		}
+	}
+}
+
+// TriggerUpdate runs an update cycle now, outside the schedule, and returns its outcome. If an
+// update is already running it returns ErrUpdateInProgress rather than waiting; a scheduled update
+// that comes due while a triggered one runs waits for it to finish.
+func (s *UpdateService) TriggerUpdate(ctx context.Context) (CycleStats, error) {
+	if !s.updateMu.TryLock() {
+		return CycleStats{}, ErrUpdateInProgress
+	}
+	defer s.updateMu.Unlock()
+
+	return s.update(ctx)
+}
+
+// ApplyConfig applies changes to the check interval, cron expression and CSV urls from a reloaded
+// configuration, re-reading the CSV urls file if one is configured.
+//
+// An interval, cron expression or url list that fails to load is logged and ignored, keeping the
+// current value.
+func (s *UpdateService) ApplyConfig(config *cfg.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spec, timezone := config.Service.Cron, config.Service.CronTimezone
+	if spec != s.Cron || timezone != s.CronTimezone {
+		if _, err := parseCron(spec, timezone); spec != "" && err != nil {
+			s.Logger.Error(
+				"invalid cron schedule in changed config, keeping current schedule",
+				"cron",
+				spec,
+				"cron-timezone",
+				timezone,
+				"error",
+				err,
+			)
+		} else {
+			s.Logger.Info(
+				"applied cron schedule change",
+				"old",
+				s.Cron,
+				"new",
+				spec,
+				"cron-timezone",
+				timezone,
+			)
+			s.Cron = spec
+			s.CronTimezone = timezone
+			s.rescheduled()
+		}
+	}
+
+	if interval := checkInterval(config, s.Logger); interval != s.CheckEvery {
+		if _, err := time.ParseDuration(interval); err != nil {
+			s.Logger.Error(
+				"invalid check interval in changed config, keeping current interval",
+				"interval",
+				interval,
+				"error",
+				err,
+			)
+		} else {
+			s.Logger.Info(
+				"applied check interval change",
+				"old",
+				s.CheckEvery,
+				"new",
+				interval,
+			)
+			s.CheckEvery = interval
+			s.rescheduled()
+		}
+	}
+
+	urls, err := config.AllCSVUrls()
+	if err != nil {
+		s.Logger.Error("invalid csv urls in changed config, keeping current urls", "error", err)
+	} else if !slices.Equal(urls, s.CSVUrls) {
+		s.Logger.Info("applied csv url change", "old", s.CSVUrls, "new", urls)
+		s.CSVUrls = urls
+	}
+
+	s.Sources = sourcesByURL(config)
+}
+
+// rescheduled signals Run to reschedule updates, unless a signal is already pending.
+func (s *UpdateService) rescheduled() {
+	select {
+	case s.scheduleChanged <- struct{}{}:
+	default:
+	}
+}
+
+// LastError returns the error from the most recent update cycle, or nil if it succeeded or no cycle
+// has run yet.
+func (s *UpdateService) LastError() *CycleError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lastError == nil {
+		return nil
+	}
+
+	lastError := *s.lastError
+	return &lastError
+}
+
+// inactiveTable returns the table that was least recently updated, which is the next one to write.
+func (s *UpdateService) inactiveTable() *Table {
+	s.tablesMu.RLock()
+	defer s.tablesMu.RUnlock()
+
+	return s.leastRecentlyUpdated()
+}
+
+// leastRecentlyUpdated returns the inactive table. Callers must hold tablesMu.
+func (s *UpdateService) leastRecentlyUpdated() *Table {
+	if s.BlueTable.LastUpdated.After(s.GreenTable.LastUpdated) {
+		return s.GreenTable
+	}
+
+	return s.BlueTable
+}
+
+// LastUpdatedTable returns the name of the table that was most recently updated. It is safe to
+// call while an update is running.
 //
 // This is used by the repository to determine which table to query.
 func (s *UpdateService) LastUpdatedTable() string {
+	return s.activeTable().Name
+}
+
+// LastUpdated returns the time the active table was last updated, or the zero time if neither
+// table has been updated yet. It is safe to call while an update is running.
+func (s *UpdateService) LastUpdated() time.Time {
+	s.tablesMu.RLock()
+	defer s.tablesMu.RUnlock()
+
 	if s.BlueTable.LastUpdated.After(s.GreenTable.LastUpdated) {
-		return s.BlueTable.Name
+		return s.BlueTable.LastUpdated
 	}
 
-	return s.GreenTable.Name
+	return s.GreenTable.LastUpdated
 }
 
-// ConnectToDatabase connects to the database using the given configuration.
-func (s *UpdateService) ConnectToDatabase(config *cfg.Config) {
-	dbConfig := mysql.Config{
-		User:   config.Database.Username,
-		Passwd: config.Database.Password,
-		Net:    "tcp",
-		Addr:   fmt.Sprintf("%s:%d", config.Database.Host, config.Database.Port),
-		DBName: config.Database.Name,
+// ConnectToDatabase connects to the database using the given configuration, retrying with
+// backoff up to ConnectRetries times and for at most ConnectMaxWait so the service can wait for a
+// database that is still starting. If every attempt fails it returns the last error.
+func (s *UpdateService) ConnectToDatabase(config *cfg.Config) error {
+	var deadline time.Time
+	if s.ConnectMaxWait > 0 {
+		deadline = time.Now().Add(s.ConnectMaxWait)
 	}
 
-	db, err := sql.Open("mysql", dbConfig.FormatDSN())
-	if err != nil {
-		s.Logger.Error("failed to open database connection", "error", err)
-		os.Exit(1)
+	// Only ever at debug level: the DSN still names the user, host and database.
+	if dsn, err := RedactedDSN(config); err == nil {
+		s.Logger.Debug("connecting to database", "dsn", dsn)
 	}
 
-	// Ping the database to make sure we have a real connection.
-	if err := db.Ping(); err != nil {
-		s.Logger.Error("successfully connected to database, but ping check returned an error",
-			"error", err)
-		os.Exit(1)
+	var db *sql.DB
+	for attempt := 0; ; attempt++ {
+		var err error
+		db, err = OpenDatabase(context.Background(), config, s.Logger)
+		if err == nil {
+			break
+		}
+
+		delay := backoff.Delay(attempt, CONNECT_RETRY_BASE_DELAY, CONNECT_RETRY_MAX_DELAY)
+		if attempt >= s.ConnectRetries ||
+			(!deadline.IsZero() && time.Now().Add(delay).After(deadline)) {
+			return fmt.Errorf("failed after %d attempts: %w", attempt+1, err)
+		}
+
+		s.Logger.Warn(
+			"unable to connect to database, retrying",
+			"attempt",
+			attempt+1,
+			"delay",
+			delay,
+			"error",
+			err,
+		)
+		time.Sleep(delay)
 	}
 
-	s.Db = db
+	s.UseDatabase(db)
 	s.Logger.Info(
 		"successfully connected to database",
 		"host",
@@ -99,4 +686,612 @@ func (s *UpdateService) ConnectToDatabase(config *cfg.Config) {
 		"port",
 		config.Database.Port,
 	)
+
+	return nil
+}
+
+// UseDatabase sets Db to db and Store to a MySQLStore on it, using the service's table, column and
+// write settings.
+func (s *UpdateService) UseDatabase(db *sql.DB) {
+	s.Db = db
+	s.Store = &MySQLStore{
+		Db:                db,
+		MetadataTable:     s.MetadataTable,
+		Columns:           s.Columns,
+		Indexes:           s.Indexes,
+		WriteMode:         s.WriteMode,
+		EmptyOffenseCount: s.EmptyOffenseCount,
+		EmptyStrings:      s.EmptyStrings,
+		Charset:           s.Charset,
+		Collation:         s.Collation,
+		TxOptions:         s.TxOptions,
+		Logger:            s.Logger,
+
+		RecreateMissingTables: s.RecreateMissingTables,
+	}
+}
+
+// OpenDatabase opens a connection to the configured database and pings it to make sure the
+// connection is usable. With database.log-queries, every statement run on it is logged to logger.
+func OpenDatabase(ctx context.Context, config *cfg.Config, logger *slog.Logger) (*sql.DB, error) {
+	dbConfig, err := mysqlConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return openConnection(ctx, dbConfig, config, logger)
+}
+
+// OpenReplica opens a connection to the read replica configured by database.replica-dsn and pings
+// it, as OpenDatabase does for the primary. Times are always parsed, whatever the DSN says, since
+// records are scanned into time.Time values.
+func OpenReplica(ctx context.Context, config *cfg.Config, logger *slog.Logger) (*sql.DB, error) {
+	dbConfig, err := mysql.ParseDSN(config.Database.ReplicaDSN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replica dsn: %w", err)
+	}
+	dbConfig.ParseTime = true
+
+	return openConnection(ctx, dbConfig, config, logger)
+}
+
+// RedactedDSN returns the DSN OpenDatabase connects with, with the password masked, for confirming
+// the connection settings in logs.
+func RedactedDSN(config *cfg.Config) (string, error) {
+	dbConfig, err := mysqlConfig(config)
+	if err != nil {
+		return "", err
+	}
+
+	if dbConfig.Passwd != "" {
+		dbConfig.Passwd = REDACTED
+	}
+
+	return dbConfig.FormatDSN(), nil
+}
+
+// openConnection opens a connection with dbConfig and pings it, logging every statement with
+// database.log-queries.
+func openConnection(
+	ctx context.Context,
+	dbConfig *mysql.Config,
+	config *cfg.Config,
+	logger *slog.Logger,
+) (*sql.DB, error) {
+	connector, err := mysql.NewConnector(dbConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
+	}
+	if config.Database.LogQueries {
+		connector = &logConnector{Connector: connector, logger: logger}
+	}
+
+	db := sql.OpenDB(connector)
+
+	// Ping the database to make sure we have a real connection.
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connected to database, but ping check returned an error: %w", err)
+	}
+
+	return db, nil
+}
+
+// mysqlConfig builds the driver configuration for the configured database, setting the connection
+// charset and collation.
+func mysqlConfig(config *cfg.Config) (*mysql.Config, error) {
+	password, err := config.DatabasePassword()
+	if err != nil {
+		return nil, err
+	}
+
+	dbConfig := mysql.NewConfig()
+	dbConfig.User = config.Database.Username
+	dbConfig.Passwd = password
+	dbConfig.Net = "tcp"
+	dbConfig.Addr = fmt.Sprintf("%s:%d", config.Database.Host, config.Database.Port)
+	dbConfig.DBName = config.Database.Name
+	dbConfig.ParseTime = true
+
+	if charset := config.Database.Charset; charset != "" {
+		if err := dbConfig.Apply(mysql.Charset(charset, config.Database.Collation)); err != nil {
+			return nil, err
+		}
+	}
+
+	return dbConfig, nil
+}
+
+// checkInterval returns the configured check interval, or cfg.DEFAULT_CHECK_INTERVAL if it is
+// blank, with a warning unless updates are scheduled by a cron expression instead.
+func checkInterval(config *cfg.Config, logger *slog.Logger) string {
+	if config.Service.CheckInterval != "" {
+		return config.Service.CheckInterval
+	}
+	if config.Service.Cron != "" {
+		return cfg.DEFAULT_CHECK_INTERVAL
+	}
+
+	logger.Warn(
+		"service.check-interval is not set, using default",
+		"default",
+		cfg.DEFAULT_CHECK_INTERVAL,
+	)
+
+	return cfg.DEFAULT_CHECK_INTERVAL
+}
+
+// interval returns the parsed CheckEvery duration.
+func (s *UpdateService) interval() (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	interval, err := time.ParseDuration(s.CheckEvery)
+	if err != nil {
+		return 0, fmt.Errorf("invalid check interval %q: %w", s.CheckEvery, err)
+	}
+
+	return interval, nil
+}
+
+// source returns the settings of the source for url, which are empty if url isn't a source.
+func (s *UpdateService) source(url string) cfg.SourceConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Sources[url]
+}
+
+// sourcesByURL returns the configured sources keyed by url.
+func sourcesByURL(config *cfg.Config) map[string]cfg.SourceConfig {
+	sources := make(map[string]cfg.SourceConfig, len(config.Service.Sources))
+	for _, source := range config.Service.Sources {
+		sources[source.URL] = source
+	}
+
+	return sources
+}
+
+// csvUrls returns a copy of the current CSV urls.
+func (s *UpdateService) csvUrls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.CSVUrls)
+}
+
+// cycleContext returns the context for Run's update cycles, which is cancelled ShutdownTimeout
+// after ctx is, or as soon as the returned stop function is called.
+func (s *UpdateService) cycleContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	cycleCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	stopAfter := context.AfterFunc(ctx, func() {
+		timer := time.NewTimer(s.ShutdownTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			cancel()
+		case <-cycleCtx.Done():
+		}
+	})
+
+	return cycleCtx, func() {
+		stopAfter()
+		cancel()
+	}
+}
+
+// scheduledUpdate runs one of Run's update cycles with cycleCtx, and if ctx was cancelled while it
+// ran, logs whether the cycle finished within ShutdownTimeout or was cancelled.
+func (s *UpdateService) scheduledUpdate(ctx context.Context, cycleCtx context.Context) {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	s.update(cycleCtx)
+
+	if ctx.Err() == nil {
+		return
+	}
+
+	if cycleCtx.Err() != nil {
+		s.Logger.Warn(
+			"forced shutdown, update cycle cancelled after shutdown timeout",
+			"shutdown-timeout",
+			s.ShutdownTimeout,
+		)
+	} else {
+		s.Logger.Info("clean shutdown, update cycle finished before shutdown timeout")
+	}
+}
+
+// update runs a single update cycle, recording its error, if any, as the service's last error,
+// publishes its outcome to subscribers, and returns it. Callers must hold updateMu.
+func (s *UpdateService) update(ctx context.Context) (CycleStats, error) {
+	stats, err := s.runCycle(ctx)
+	s.publishOutcome(stats, err)
+	if err != nil {
+		s.Logger.Error("update cycle failed", "error", err)
+		s.setLastError(err)
+		return stats, err
+	}
+
+	s.setLastError(nil)
+
+	return stats, nil
+}
+
+// runCycle downloads and parses every CSV url, writes the records to the inactive table, validates
+// it, and then marks it as the most recently updated table, returning a summary of the cycle. A
+// single CSV url is streamed into the table as it is parsed, rather than held in memory first.
+func (s *UpdateService) runCycle(ctx context.Context) (CycleStats, error) {
+	stats := CycleStats{Started: time.Now()}
+
+	// Pick up changes made by other processes, such as a rollback, before choosing a table.
+	if err := s.LoadMetadata(ctx); err != nil {
+		return CycleStats{}, err
+	}
+
+	ctx = withRetryBudget(ctx, s.RetryBudget)
+
+	urls := s.csvUrls()
+	partial := s.LimitURLs > 0 && len(urls) > s.LimitURLs
+	if partial {
+		s.Logger.Warn("processing a subset of the csv urls", "urls", s.LimitURLs, "of", len(urls))
+		urls = urls[:s.LimitURLs]
+	}
+
+	table := s.inactiveTable()
+	var result ParseResult
+	var written int
+	var failures []error
+	var err error
+	if s.streamable(urls) {
+		result, written, err = s.streamSource(ctx, urls[0], table)
+	} else {
+		result, failures, err = s.fetchAndWrite(ctx, urls, table)
+		written = len(result.Records)
+	}
+	if err != nil {
+		return CycleStats{}, err
+	}
+
+	// The new data isn't active until LastUpdated is set, so returning here keeps the previous
+	// table serving.
+	if err := s.ValidateTable(ctx, table.Name); err != nil {
+		return CycleStats{}, fmt.Errorf(
+			"validating %s, keeping previous table active: %w",
+			table.Name,
+			err,
+		)
+	}
+
+	stats.Table = table.Name
+	stats.URLs = len(urls)
+	stats.FailedURLs = len(failures)
+	stats.Records = written
+	stats.Skipped = result.Skipped
+	stats.SkippedByReason = result.Stats.SkippedByReason()
+	stats.ParseErrorRate = result.Stats.ErrorRate()
+
+	// A table missing the data from the skipped urls must never serve.
+	if partial {
+		s.Logger.Warn(
+			"table was written from a subset of the csv urls, keeping previous table active",
+			"table",
+			table.Name,
+			"records",
+			written,
+		)
+
+		stats.Partial = true
+		stats.Finished = time.Now()
+
+		return stats, nil
+	}
+
+	if s.NoSwap {
+		if err := s.stage(ctx, table, time.Now()); err != nil {
+			return CycleStats{}, err
+		}
+
+		s.Logger.Info(
+			"table staged for review, keeping previous table active",
+			"table",
+			table.Name,
+			"records",
+			written,
+		)
+
+		stats.Staged = true
+		stats.Finished = time.Now()
+
+		return stats, nil
+	}
+
+	if err := s.waitToSwap(ctx, table); err != nil {
+		return CycleStats{}, err
+	}
+	if err := s.activate(ctx, table, written); err != nil {
+		return CycleStats{}, err
+	}
+
+	s.Logger.Info(
+		"update cycle complete",
+		"urls",
+		len(urls),
+		"records",
+		written,
+		"table",
+		table.Name,
+		"skipped",
+		stats.SkippedByReason,
+	)
+
+	stats.Finished = time.Now()
+
+	return stats, nil
+}
+
+// fetchAndWrite downloads and parses every url, leaving out or aborting on failed urls as
+// URLFailure and URLMaxFailureAge say, then writes the records to table. It returns the combined
+// result and the failures of the urls left out.
+func (s *UpdateService) fetchAndWrite(
+	ctx context.Context,
+	urls []string,
+	table *Table,
+) (ParseResult, []error, error) {
+	var result ParseResult
+	var failures []error
+
+	for _, url := range urls {
+		parsed, err := s.fetchSource(ctx, url, false)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ParseResult{}, nil, err
+			}
+
+			failures = append(failures, err)
+			failingFor := s.urlFailed(url)
+			switch {
+			case len(failures) == len(urls):
+				err = errors.Join(failures...)
+				return ParseResult{}, nil, fmt.Errorf("%w: %w", ErrAllURLsFailed, err)
+			case s.URLFailure == URL_FAILURE_SKIP:
+				s.Logger.Warn(
+					"csv url failed, leaving it out of this cycle",
+					"url",
+					url,
+					"error",
+					err,
+				)
+			case s.URLMaxFailureAge > 0 && failingFor > s.URLMaxFailureAge:
+				s.Logger.Warn(
+					"csv url has been failing longer than url-max-failure-age, leaving it out of "+
+						"this cycle",
+					"url",
+					url,
+					"failing for",
+					failingFor,
+					"error",
+					err,
+				)
+			default:
+				return ParseResult{}, nil, err
+			}
+			continue
+		}
+		s.urlSucceeded(url)
+
+		s.logSkippedRows(url, parsed)
+		result.Merge(parsed)
+	}
+
+	s.logParseResult(result)
+
+	for i, record := range result.Records[:min(len(result.Records), s.LogSampleRows)] {
+		s.Logger.Debug("sample record", "index", i, "record", record)
+	}
+
+	if err := s.checkParseErrorRate(result); err != nil {
+		return ParseResult{}, nil, err
+	}
+
+	ingestedAt := time.Now().UTC()
+	for i := range result.Records {
+		result.Records[i].IngestedAt = ingestedAt
+	}
+
+	if err := s.unstage(ctx, table); err != nil {
+		return ParseResult{}, nil, err
+	}
+	if err := s.markWritten(ctx, table, time.Now()); err != nil {
+		return ParseResult{}, nil, err
+	}
+	if err := s.Store.WriteRecords(ctx, table.Name, result.Records); err != nil {
+		return ParseResult{}, nil, err
+	}
+
+	return result, failures, nil
+}
+
+// logParseResult logs the statistics of the rows parsed in a cycle, and counts its skipped rows.
+func (s *UpdateService) logParseResult(result ParseResult) {
+	s.Logger.Info(
+		"parse statistics",
+		"stats",
+		result.Stats,
+		"skipped",
+		result.Skipped,
+		"filtered",
+		result.Filtered,
+	)
+	countSkipped(result.Stats)
+}
+
+// logSkippedRows logs the first LOGGED_ROW_ERRORS rows skipped from url.
+func (s *UpdateService) logSkippedRows(url string, parsed ParseResult) {
+	for _, rowErr := range parsed.Errors[:min(len(parsed.Errors), LOGGED_ROW_ERRORS)] {
+		s.Logger.Warn(
+			"skipped row",
+			"url",
+			url,
+			"line",
+			rowErr.Line,
+			"error",
+			rowErr.Err,
+			"row",
+			rowErr.LoggedRow(),
+		)
+	}
+}
+
+// urlFailed records that url failed to download, returning how long it has been failing.
+func (s *UpdateService) urlFailed(url string) time.Duration {
+	now := time.Now()
+	if s.urlFailingSince == nil {
+		s.urlFailingSince = make(map[string]time.Time)
+	}
+
+	since, ok := s.urlFailingSince[url]
+	if !ok {
+		s.urlFailingSince[url] = now
+		return 0
+	}
+
+	return now.Sub(since)
+}
+
+// urlSucceeded records that url downloaded, so it is no longer failing.
+func (s *UpdateService) urlSucceeded(url string) {
+	delete(s.urlFailingSince, url)
+}
+
+// waitToSwap waits SwapDelay before table is made active, returning the context's error if ctx is
+// cancelled first.
+func (s *UpdateService) waitToSwap(ctx context.Context, table *Table) error {
+	if s.SwapDelay <= 0 {
+		return nil
+	}
+
+	s.Logger.Info("waiting before making table active", "table", table.Name, "delay", s.SwapDelay)
+
+	timer := time.NewTimer(s.SwapDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting to make %s active: %w", table.Name, ctx.Err())
+	}
+}
+
+// checkParseErrorRate returns a ParseErrorRateError if more of result's rows were parse errors
+// than MaxParseErrorRate allows.
+func (s *UpdateService) checkParseErrorRate(result ParseResult) error {
+	rate := result.Stats.ErrorRate()
+	if rate <= s.MaxParseErrorRate {
+		return nil
+	}
+
+	return &ParseErrorRateError{
+		Rate:    rate,
+		MaxRate: s.MaxParseErrorRate,
+		Rows:    result.Stats.Rows,
+		Errors:  result.Stats.Errors(),
+		Samples: slices.Clone(result.Errors[:min(len(result.Errors), PARSE_ERROR_SAMPLES)]),
+	}
+}
+
+// activate archives the active table if archival is enabled, makes table active, and publishes the
+// change to subscribers. records is the number of records in table, for the event.
+func (s *UpdateService) activate(ctx context.Context, table *Table, records int) error {
+	// Archival is best-effort: a failure is logged but doesn't hold back the new data.
+	if s.ArchiveRetention > 0 {
+		if err := s.archiveTable(ctx, s.activeTable()); err != nil {
+			s.Logger.Error("unable to archive previous dataset", "error", err)
+		}
+	}
+
+	now := time.Now()
+	if err := s.setLastUpdated(ctx, table, now); err != nil {
+		return err
+	}
+
+	s.publish(TableChangeEvent{
+		Type:      EVENT_TABLE_CHANGED,
+		Table:     table.Name,
+		UpdatedAt: now,
+		Records:   records,
+	})
+
+	return nil
+}
+
+// setLastError records err as the most recent cycle error, or clears it if err is nil.
+func (s *UpdateService) setLastError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		s.lastError = nil
+		return
+	}
+
+	s.lastError = &CycleError{Message: err.Error(), Time: time.Now()}
+}
+
+// isStale reports whether the active table was last updated more than MaxAge ago, logging a
+// warning if so. It is never stale if MaxAge is zero.
+func (s *UpdateService) isStale(ctx context.Context) bool {
+	if s.MaxAge <= 0 {
+		return false
+	}
+
+	if err := s.LoadMetadata(ctx); err != nil {
+		s.Logger.Error("unable to check data age", "error", err)
+		return false
+	}
+
+	lastUpdated := s.LastUpdated()
+	if lastUpdated.IsZero() {
+		s.Logger.Warn("data is stale, tables have never been updated", "max age", s.MaxAge)
+		return true
+	}
+
+	age := time.Since(lastUpdated)
+	if age <= s.MaxAge {
+		return false
+	}
+
+	s.Logger.Warn(
+		"data is stale",
+		"table",
+		s.LastUpdatedTable(),
+		"last updated",
+		lastUpdated,
+		"age",
+		age.Round(time.Second),
+		"max age",
+		s.MaxAge,
+	)
+
+	return true
+}
+
+// optionalDuration parses value as a duration, returning zero if it is empty, or logging a warning
+// and returning zero if it is invalid.
+func optionalDuration(value string, setting string, logger *slog.Logger) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Warn("invalid duration, using 0", "setting", setting, "value", value, "error", err)
+		return 0
+	}
+
+	return d
 }
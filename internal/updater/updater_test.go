@@ -0,0 +1,629 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cfg "github.com/lorendsnow/updater/internal/config"
+)
+
+// failingStore is a MemoryStore whose writes and swaps fail with the configured errors.
+type failingStore struct {
+	*MemoryStore
+	writeErr error
+	swapErr  error
+}
+
+func (f *failingStore) WriteRecords(ctx context.Context, table string, records []Record) error {
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+
+	return f.MemoryStore.WriteRecords(ctx, table, records)
+}
+
+func (f *failingStore) StreamRecords(
+	ctx context.Context,
+	table string,
+	records iter.Seq2[Record, error],
+) (int, error) {
+	if f.writeErr != nil {
+		return 0, f.writeErr
+	}
+
+	return f.MemoryStore.StreamRecords(ctx, table, records)
+}
+
+func (f *failingStore) Swap(ctx context.Context, table string, t time.Time) error {
+	if f.swapErr != nil {
+		return f.swapErr
+	}
+
+	return f.MemoryStore.Swap(ctx, table, t)
+}
+
+// newTestService returns an UpdateService downloading urls into store, without retries.
+func newTestService(t *testing.T, store Store, urls ...string) *UpdateService {
+	t.Helper()
+
+	s := &UpdateService{
+		CSVUrls:           urls,
+		BlueTable:         &Table{Name: "blue"},
+		GreenTable:        &Table{Name: "green"},
+		Store:             store,
+		Logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+		HTTPClient:        http.DefaultClient,
+		MaxParseErrorRate: 1,
+	}
+	if err := store.EnsureSchema(context.Background(), "blue", "green"); err != nil {
+		t.Fatalf("creating tables: %v", err)
+	}
+
+	return s
+}
+
+// serveCSV serves body as a CSV file for the duration of the test, returning its url.
+func serveCSV(t *testing.T, body string) string {
+	t.Helper()
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		io.WriteString(w, body)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(server.Close)
+
+	return server.URL + "/offenses.csv"
+}
+
+func TestRunCycleAlternatesTables(t *testing.T) {
+	store := NewMemoryStore()
+	s := newTestService(t, store, serveCSV(t, string(benchmarkCSV(3))))
+	ctx := context.Background()
+
+	var tables []string
+	for cycle := range 3 {
+		stats, err := s.runCycle(ctx)
+		if err != nil {
+			t.Fatalf("cycle %d: %v", cycle, err)
+		}
+
+		if got := s.LastUpdatedTable(); got != stats.Table {
+			t.Errorf("cycle %d: active table = %s, want %s", cycle, got, stats.Table)
+		}
+		if got := len(store.Records(stats.Table)); got != 3 {
+			t.Errorf("cycle %d: %s holds %d records, want 3", cycle, stats.Table, got)
+		}
+		tables = append(tables, stats.Table)
+	}
+
+	if tables[0] == tables[1] || tables[0] != tables[2] {
+		t.Errorf("cycles activated %v, want the tables to alternate", tables)
+	}
+
+	// A fresh service on the same store picks up the active table.
+	fresh := newTestService(t, store)
+	if err := fresh.LoadMetadata(ctx); err != nil {
+		t.Fatalf("LoadMetadata: %v", err)
+	}
+	if got := fresh.LastUpdatedTable(); got != tables[2] {
+		t.Errorf("active table after LoadMetadata = %s, want %s", got, tables[2])
+	}
+}
+
+func TestRunUpdateOnStart(t *testing.T) {
+	tests := []struct {
+		name          string
+		updateOnStart bool
+		interval      time.Duration
+		// wantAfter is the earliest the first update may start.
+		wantAfter time.Duration
+	}{
+		{name: "update on start", updateOnStart: true, interval: time.Hour},
+		{
+			name:          "wait one interval",
+			updateOnStart: false,
+			interval:      100 * time.Millisecond,
+			wantAfter:     100 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each update requests the CSV once and fails, which is all the test needs to see.
+			requested := make(chan time.Time, 10)
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case requested <- time.Now():
+				default:
+				}
+				http.NotFound(w, r)
+			}
+			server := httptest.NewServer(http.HandlerFunc(handler))
+			defer server.Close()
+
+			s := newTestService(t, NewMemoryStore(), server.URL+"/offenses.csv")
+			s.CheckEvery = tt.interval.String()
+			s.UpdateOnStart = tt.updateOnStart
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			start := time.Now()
+			go func() { done <- s.Run(ctx) }()
+
+			select {
+			case at := <-requested:
+				if elapsed := at.Sub(start); elapsed < tt.wantAfter {
+					t.Errorf("first update after %v, want at least %v", elapsed, tt.wantAfter)
+				}
+			case <-time.After(5 * time.Second):
+				t.Error("no update within 5s")
+			}
+
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("Run: %v", err)
+			}
+		})
+	}
+}
+
+func TestTriggerUpdateWhileUpdating(t *testing.T) {
+	// The first download blocks until release is closed, holding the update in progress.
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+			<-release
+		default:
+		}
+		http.NotFound(w, r)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	s := newTestService(t, NewMemoryStore(), server.URL+"/offenses.csv")
+
+	ctx := context.Background()
+	done := make(chan error)
+	go func() {
+		_, err := s.TriggerUpdate(ctx)
+		done <- err
+	}()
+	<-started
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{
+			name: "trigger",
+			call: func() error {
+				_, err := s.TriggerUpdate(ctx)
+				return err
+			},
+		},
+		{
+			name: "rollback",
+			call: func() error {
+				_, err := s.Rollback(ctx)
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, ErrUpdateInProgress) {
+				t.Errorf("error = %v, want ErrUpdateInProgress", err)
+			}
+		})
+	}
+
+	close(release)
+	if err := <-done; err == nil || errors.Is(err, ErrUpdateInProgress) {
+		t.Errorf("first update error = %v, want the download to fail", err)
+	}
+
+	// Once the first update finishes, the next one runs.
+	if _, err := s.TriggerUpdate(ctx); err == nil || errors.Is(err, ErrUpdateInProgress) {
+		t.Errorf("later update error = %v, want the download to fail", err)
+	}
+}
+
+func TestRedactedDSNCharset(t *testing.T) {
+	t.Setenv(cfg.PASSWORD_ENV, "secret")
+
+	tests := []struct {
+		name      string
+		charset   string
+		collation string
+		want      []string
+		wantNot   []string
+	}{
+		{name: "driver default", wantNot: []string{"charset=", "collation="}},
+		{name: "charset", charset: "utf8mb4", want: []string{"charset=utf8mb4"}},
+		{
+			name:      "charset and collation",
+			charset:   "utf8mb4",
+			collation: "utf8mb4_bin",
+			want:      []string{"charset=utf8mb4", "collation=utf8mb4_bin"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config cfg.Config
+			config.Database.Host = "localhost"
+			config.Database.Port = 3306
+			config.Database.Username = "updater"
+			config.Database.Name = "crime"
+			config.Database.Charset = tt.charset
+			config.Database.Collation = tt.collation
+
+			dsn, err := RedactedDSN(&config)
+			if err != nil {
+				t.Fatalf("RedactedDSN: %v", err)
+			}
+
+			if strings.Contains(dsn, "secret") {
+				t.Errorf("RedactedDSN() = %q, which holds the password", dsn)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(dsn, want) {
+					t.Errorf("RedactedDSN() = %q, want it to contain %q", dsn, want)
+				}
+			}
+			for _, unwanted := range tt.wantNot {
+				if strings.Contains(dsn, unwanted) {
+					t.Errorf("RedactedDSN() = %q, want no %q", dsn, unwanted)
+				}
+			}
+		})
+	}
+}
+
+// TestTableTimesConcurrentAccess reads the active table while it is being changed. It checks
+// nothing itself, and is meant for the race detector: go test -race.
+func TestTableTimesConcurrentAccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(ctx context.Context, s *UpdateService) error
+	}{
+		{
+			name: "update cycles",
+			change: func(ctx context.Context, s *UpdateService) error {
+				_, err := s.runCycle(ctx)
+				return err
+			},
+		},
+		{
+			name: "rollbacks",
+			change: func(ctx context.Context, s *UpdateService) error {
+				_, err := s.Rollback(ctx)
+				return err
+			},
+		},
+		{
+			name: "metadata loads",
+			change: func(ctx context.Context, s *UpdateService) error {
+				return s.LoadMetadata(ctx)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := serveCSV(t, mixedCSV(3, nil, nil))
+			s := newTestService(t, NewMemoryStore(), url)
+			ctx := context.Background()
+
+			// Two cycles first, so both tables can be rolled back to.
+			for range 2 {
+				if _, err := s.runCycle(ctx); err != nil {
+					t.Fatalf("runCycle: %v", err)
+				}
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for range 20 {
+					if err := tt.change(ctx, s); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					s.LastUpdatedTable()
+					s.LastUpdated()
+				}
+			}
+		})
+	}
+}
+
+func TestCheckInterval(t *testing.T) {
+	tests := []struct {
+		name        string
+		interval    string
+		want        string
+		wantWarning bool
+	}{
+		{name: "set", interval: "1h", want: "1h"},
+		{name: "blank", interval: "", want: cfg.DEFAULT_CHECK_INTERVAL, wantWarning: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs strings.Builder
+			logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+			var config cfg.Config
+			config.Service.CheckInterval = tt.interval
+
+			if got := checkInterval(&config, logger); got != tt.want {
+				t.Errorf("checkInterval() = %q, want %q", got, tt.want)
+			}
+			if warned := strings.Contains(logs.String(), "level=WARN"); warned != tt.wantWarning {
+				t.Errorf("warned = %t, want %t: %s", warned, tt.wantWarning, logs.String())
+			}
+		})
+	}
+}
+
+func TestRunCycleURLFailures(t *testing.T) {
+	tests := []struct {
+		name        string
+		urlFailure  string
+		failing     int
+		working     int
+		empty       bool
+		wantErr     error
+		wantRecords int
+	}{
+		{name: "abort, one failing", urlFailure: URL_FAILURE_ABORT, failing: 1, working: 1},
+		{
+			name:       "abort, single url failing",
+			urlFailure: URL_FAILURE_ABORT,
+			failing:    1,
+			wantErr:    ErrAllURLsFailed,
+		},
+		{
+			name:        "skip, one failing",
+			urlFailure:  URL_FAILURE_SKIP,
+			failing:     1,
+			working:     1,
+			wantRecords: 3,
+		},
+		{
+			name:       "skip, all failing",
+			urlFailure: URL_FAILURE_SKIP,
+			failing:    2,
+			wantErr:    ErrAllURLsFailed,
+		},
+		{name: "empty file", urlFailure: URL_FAILURE_ABORT, working: 1, empty: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			s := newTestService(t, store, serveCSV(t, mixedCSV(5, nil, nil)))
+			ctx := context.Background()
+
+			// A good cycle first, so there is a previous table to keep.
+			if _, err := s.runCycle(ctx); err != nil {
+				t.Fatalf("first cycle: %v", err)
+			}
+			previous := s.LastUpdatedTable()
+
+			failing := httptest.NewServer(http.NotFoundHandler())
+			defer failing.Close()
+
+			s.CSVUrls = nil
+			for range tt.failing {
+				s.CSVUrls = append(s.CSVUrls, failing.URL+"/offenses.csv")
+			}
+			for range tt.working {
+				rows := 3
+				if tt.empty {
+					rows = 0
+				}
+				s.CSVUrls = append(s.CSVUrls, serveCSV(t, mixedCSV(rows, nil, nil)))
+			}
+			s.URLFailure = tt.urlFailure
+
+			stats, err := s.runCycle(ctx)
+			if tt.wantErr != nil || tt.urlFailure == URL_FAILURE_ABORT && tt.failing > 0 {
+				if err == nil {
+					t.Fatal("runCycle returned no error")
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("runCycle() error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantErr == nil && errors.Is(err, ErrAllURLsFailed) {
+					t.Fatalf("runCycle() error = %v, want only some urls failed", err)
+				}
+
+				if active := s.LastUpdatedTable(); active != previous {
+					t.Errorf("active table = %s, want the previous %s", active, previous)
+				}
+				if count, _ := store.CountRows(ctx, previous); count != 5 {
+					t.Errorf("previous table holds %d records, want 5", count)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("runCycle: %v", err)
+			}
+
+			if stats.Records != tt.wantRecords || stats.FailedURLs != tt.failing {
+				t.Errorf(
+					"wrote %d records with %d failed urls, want %d with %d",
+					stats.Records,
+					stats.FailedURLs,
+					tt.wantRecords,
+					tt.failing,
+				)
+			}
+			if active := s.LastUpdatedTable(); active == previous {
+				t.Errorf("active table is still the previous %s", previous)
+			}
+		})
+	}
+}
+
+func TestRunCycleFailureKeepsActiveTable(t *testing.T) {
+	var errWrite = errors.New("write failed")
+	var errSwap = errors.New("swap failed")
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, s *UpdateService, store *failingStore)
+		wantErr func(err error) bool
+		// wantOverwritten is whether the failed cycle wrote the inactive table, so it can no longer
+		// be rolled back to.
+		wantOverwritten bool
+	}{
+		{
+			name: "download error",
+			setup: func(t *testing.T, s *UpdateService, store *failingStore) {
+				server := httptest.NewServer(http.NotFoundHandler())
+				t.Cleanup(server.Close)
+				s.CSVUrls = []string{server.URL + "/missing.csv"}
+			},
+			wantErr: func(err error) bool { return errors.Is(err, ErrAllURLsFailed) },
+		},
+		{
+			name: "parse error rate exceeded",
+			setup: func(t *testing.T, s *UpdateService, store *failingStore) {
+				// Two urls are parsed in full before anything is written.
+				s.CSVUrls = []string{
+					serveCSV(t, mixedCSV(10, []int{2, 3, 4}, nil)),
+					serveCSV(t, mixedCSV(0, nil, nil)),
+				}
+				s.MaxParseErrorRate = 0.1
+			},
+			wantErr: func(err error) bool {
+				var rateErr *ParseErrorRateError
+				return errors.As(err, &rateErr)
+			},
+		},
+		{
+			name: "write error",
+			setup: func(t *testing.T, s *UpdateService, store *failingStore) {
+				// Two urls are written with WriteRecords rather than streamed.
+				s.CSVUrls = append(s.CSVUrls, serveCSV(t, mixedCSV(0, nil, nil)))
+				store.writeErr = errWrite
+			},
+			wantErr:         func(err error) bool { return errors.Is(err, errWrite) },
+			wantOverwritten: true,
+		},
+		{
+			name: "stream error",
+			setup: func(t *testing.T, s *UpdateService, store *failingStore) {
+				store.writeErr = errWrite
+			},
+			wantErr:         func(err error) bool { return errors.Is(err, errWrite) },
+			wantOverwritten: true,
+		},
+		{
+			name: "stream parse error rate exceeded",
+			setup: func(t *testing.T, s *UpdateService, store *failingStore) {
+				s.CSVUrls = []string{serveCSV(t, mixedCSV(10, []int{2, 3, 4}, nil))}
+				s.MaxParseErrorRate = 0.1
+			},
+			wantErr: func(err error) bool {
+				var rateErr *ParseErrorRateError
+				return errors.As(err, &rateErr)
+			},
+			wantOverwritten: true,
+		},
+		{
+			name: "validation failure",
+			setup: func(t *testing.T, s *UpdateService, store *failingStore) {
+				s.MinExpectedRows = 4
+			},
+			wantErr: func(err error) bool {
+				return err != nil && strings.Contains(err.Error(), "keeping previous table active")
+			},
+			wantOverwritten: true,
+		},
+		{
+			name: "swap error",
+			setup: func(t *testing.T, s *UpdateService, store *failingStore) {
+				store.swapErr = errSwap
+			},
+			wantErr:         func(err error) bool { return errors.Is(err, errSwap) },
+			wantOverwritten: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &failingStore{MemoryStore: NewMemoryStore()}
+			s := newTestService(t, store, serveCSV(t, mixedCSV(3, nil, nil)))
+			ctx := context.Background()
+
+			// Two good cycles, so both tables hold data and the inactive one can be rolled back to.
+			for range 2 {
+				if _, err := s.runCycle(ctx); err != nil {
+					t.Fatalf("good runCycle: %v", err)
+				}
+			}
+			active := s.LastUpdatedTable()
+			lastUpdated := s.LastUpdated()
+
+			tt.setup(t, s, store)
+
+			_, err := s.runCycle(ctx)
+			if !tt.wantErr(err) {
+				t.Fatalf("runCycle error = %v, not the expected error", err)
+			}
+
+			if got := s.LastUpdatedTable(); got != active {
+				t.Errorf("active table = %s, want %s", got, active)
+			}
+			if got := s.LastUpdated(); !got.Equal(lastUpdated) {
+				t.Errorf("LastUpdated = %v, want %v", got, lastUpdated)
+			}
+
+			// The Store agrees, so other processes keep reading the same table.
+			if err := s.LoadMetadata(ctx); err != nil {
+				t.Fatalf("LoadMetadata: %v", err)
+			}
+			if got := s.LastUpdatedTable(); got != active {
+				t.Errorf("active table after LoadMetadata = %s, want %s", got, active)
+			}
+			if got := s.LastUpdated(); !got.Equal(lastUpdated) {
+				t.Errorf("LastUpdated after LoadMetadata = %v, want %v", got, lastUpdated)
+			}
+			if got := len(store.Records(active)); got != 3 {
+				t.Errorf("%s holds %d records, want 3", active, got)
+			}
+
+			store.swapErr = nil
+			_, err = s.Rollback(ctx)
+			if overwritten := errors.Is(err, ErrOverwritten); overwritten != tt.wantOverwritten {
+				t.Errorf("Rollback() error = %v, want ErrOverwritten: %t", err, tt.wantOverwritten)
+			}
+			if !tt.wantOverwritten && err != nil {
+				t.Errorf("Rollback: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+ *==================================================================================================
+ * Validation Constants
+ *==================================================================================================
+ */
+
+// TABLE_PLACEHOLDER is replaced with the quoted name of the table being validated in check queries.
+const TABLE_PLACEHOLDER = "{table}"
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// ValidateTable runs the configured assertions against a freshly written table before it is made
+// active: the table must hold at least MinExpectedRows rows, and each configured check query must
+// return a single number within the check's bounds. Every failed assertion is returned, joined
+// into a single error.
+func (s *UpdateService) ValidateTable(ctx context.Context, table string) error {
+	var errs []error
+
+	if s.MinExpectedRows > 0 {
+		count, err := s.Store.CountRows(ctx, table)
+		if err != nil {
+			return err
+		}
+
+		if count < s.MinExpectedRows {
+			errs = append(errs, fmt.Errorf(
+				"table has %d rows, expected at least %d",
+				count,
+				s.MinExpectedRows,
+			))
+		}
+	}
+
+	if len(s.Checks) > 0 && s.Db == nil {
+		return errors.Join(append(errs, fmt.Errorf("running checks: %w", ErrNoDatabase))...)
+	}
+
+	for _, check := range s.Checks {
+		query := strings.ReplaceAll(check.Query, TABLE_PLACEHOLDER, "`"+table+"`")
+
+		var value float64
+		if err := s.Db.QueryRowContext(ctx, query).Scan(&value); err != nil {
+			errs = append(errs, fmt.Errorf("check %q failed to run: %w", check.Name, err))
+			continue
+		}
+
+		if check.Min != nil && value < *check.Min {
+			errs = append(errs, fmt.Errorf(
+				"check %q returned %v, below minimum of %v",
+				check.Name,
+				value,
+				*check.Min,
+			))
+		}
+		if check.Max != nil && value > *check.Max {
+			errs = append(errs, fmt.Errorf(
+				"check %q returned %v, above maximum of %v",
+				check.Name,
+				value,
+				*check.Max,
+			))
+		}
+	}
+
+	return errors.Join(errs...)
+}
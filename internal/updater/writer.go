@@ -0,0 +1,401 @@
+package updater
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+/*
+ *==================================================================================================
+ * Write Modes
+ *==================================================================================================
+ */
+
+// WRITE_MODE_INSERT writes records with batched multi-row INSERT statements. It works against any
+// MySQL server and is the default; prefer it unless writes are a bottleneck.
+const WRITE_MODE_INSERT = "insert"
+
+// WRITE_MODE_LOAD_DATA writes records with a single LOAD DATA LOCAL INFILE statement fed from an
+// in-memory buffer. It is much faster for large datasets, but requires local_infile to be enabled
+// on the server and holds a tab-separated copy of every record in memory while loading.
+const WRITE_MODE_LOAD_DATA = "load-data"
+
+// EMPTY_COUNT_NULL stores empty offense counts as NULL, the default. EMPTY_COUNT_ZERO stores them
+// as 0 for consumers that can't handle a NULL count.
+const EMPTY_COUNT_NULL = "null"
+const EMPTY_COUNT_ZERO = "zero"
+
+// EMPTY_STRING_KEEP stores empty text fields as empty strings, the default. EMPTY_STRING_NULL
+// stores them as NULL, making the text columns nullable.
+const EMPTY_STRING_KEEP = "keep"
+const EMPTY_STRING_NULL = "null"
+
+// ER_NO_SUCH_TABLE is the MySQL error number for a table that doesn't exist.
+const ER_NO_SUCH_TABLE = 1146
+
+// ErrTableMissing is returned when writing to a table that doesn't exist.
+var ErrTableMissing = errors.New("table does not exist")
+
+// INSERT_BATCH_SIZE is the number of records written by each multi-row INSERT statement.
+const INSERT_BATCH_SIZE = 1000
+
+// MYSQL_DATE_TIME_FORMAT and MYSQL_DATE_FORMAT format times for LOAD DATA input.
+const MYSQL_DATE_TIME_FORMAT = "2006-01-02 15:04:05"
+const MYSQL_DATE_FORMAT = "2006-01-02"
+
+// LOAD_DATA_CHARSET is the charset of LOAD DATA input, which is always UTF-8 since it is built from
+// Go strings. Without it the server assumes the database's charset, mangling multibyte values when
+// that isn't utf8mb4.
+const LOAD_DATA_CHARSET = "utf8mb4"
+
+// loadDataEscaper escapes the characters LOAD DATA treats specially in field values.
+var loadDataEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+/*
+ *==================================================================================================
+ * Public Functions
+ *==================================================================================================
+ */
+
+// WriteRecords replaces the contents of table with records in a single transaction, using the
+// store's WriteMode and TxOptions. Empty offense counts are written according to
+// EmptyOffenseCount, and empty strings according to EmptyStrings. If any statement fails the
+// transaction is rolled back, leaving the table's previous contents in place.
+//
+// If table doesn't exist, because it was dropped outside the service, WriteRecords returns an
+// error wrapping ErrTableMissing, or with RecreateMissingTables recreates it and writes again.
+// Recreating is safe since the table being written isn't the one serving.
+func (m *MySQLStore) WriteRecords(ctx context.Context, table string, records []Record) error {
+	err := m.writeRecords(ctx, table, records)
+	if !isMissingTable(err) {
+		return err
+	}
+
+	if err := m.recreateMissingTable(ctx, table, err); err != nil {
+		return err
+	}
+
+	return m.writeRecords(ctx, table, records)
+}
+
+// StreamRecords replaces the contents of table with records in a single transaction, as
+// WriteRecords does, but inserts them INSERT_BATCH_SIZE at a time as they are produced, so only a
+// batch is held in memory. Records are always written with INSERT statements, whatever the
+// store's WriteMode. If records yields an error, or any statement fails, the transaction is
+// rolled back, leaving the table's previous contents in place.
+//
+// A missing table is handled as by WriteRecords when clearing the table finds it missing, before
+// any record is consumed. Once records have been consumed they can't be written again, so a table
+// dropped partway through is only reported.
+func (m *MySQLStore) StreamRecords(
+	ctx context.Context,
+	table string,
+	records iter.Seq2[Record, error],
+) (int, error) {
+	consumed := false
+	tracked := func(yield func(Record, error) bool) {
+		consumed = true
+		records(yield)
+	}
+
+	written, err := m.streamRecords(ctx, table, tracked)
+	if consumed || !isMissingTable(err) {
+		return written, err
+	}
+
+	if err := m.recreateMissingTable(ctx, table, err); err != nil {
+		return 0, err
+	}
+
+	return m.streamRecords(ctx, table, records)
+}
+
+/*
+ *==================================================================================================
+ * Private Functions
+ *==================================================================================================
+ */
+
+// writeRecords writes records to table as described for WriteRecords, without handling a missing
+// table.
+func (m *MySQLStore) writeRecords(ctx context.Context, table string, records []Record) error {
+	tx, err := m.Db.BeginTx(ctx, m.TxOptions)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// DELETE rather than TRUNCATE, since TRUNCATE implicitly commits the transaction.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM `%s`", table)); err != nil {
+		return fmt.Errorf("clearing table %s: %w", table, err)
+	}
+
+	columns := m.writeColumns()
+
+	switch m.WriteMode {
+	case WRITE_MODE_LOAD_DATA:
+		err = loadRecords(ctx, tx, table, columns, records)
+	default:
+		err = insertRecords(ctx, tx, table, columns, records)
+	}
+	if err != nil {
+		return fmt.Errorf("writing records to %s: %w", table, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing records to %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// streamRecords writes records to table as described for StreamRecords, without handling a
+// missing table.
+func (m *MySQLStore) streamRecords(
+	ctx context.Context,
+	table string,
+	records iter.Seq2[Record, error],
+) (int, error) {
+	tx, err := m.Db.BeginTx(ctx, m.TxOptions)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM `%s`", table)); err != nil {
+		return 0, fmt.Errorf("clearing table %s: %w", table, err)
+	}
+
+	columns := m.writeColumns()
+	batch := make([]Record, 0, INSERT_BATCH_SIZE)
+	var written int
+
+	flush := func() error {
+		if err := insertRecords(ctx, tx, table, columns, batch); err != nil {
+			return fmt.Errorf("writing records to %s: %w", table, err)
+		}
+		written += len(batch)
+		batch = batch[:0]
+
+		return nil
+	}
+
+	for record, err := range records {
+		if err != nil {
+			return 0, err
+		}
+
+		batch = append(batch, record)
+		if len(batch) == INSERT_BATCH_SIZE {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing records to %s: %w", table, err)
+	}
+
+	return written, nil
+}
+
+// recreateMissingTable recreates table after writing to it failed with err because it is missing,
+// or returns an error wrapping ErrTableMissing if RecreateMissingTables isn't set.
+func (m *MySQLStore) recreateMissingTable(ctx context.Context, table string, err error) error {
+	if !m.RecreateMissingTables {
+		return fmt.Errorf(
+			"%w: %s was dropped outside the service; restart the service or enable "+
+				"database.recreate-missing-tables to recreate it: %w",
+			ErrTableMissing,
+			table,
+			err,
+		)
+	}
+
+	m.Logger.Warn("table is missing, recreating it", "table", table)
+	if err := m.EnsureSchema(ctx, table); err != nil {
+		return fmt.Errorf("recreating missing table %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// isMissingTable reports whether err is MySQL's "table doesn't exist" error.
+func isMissingTable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == ER_NO_SUCH_TABLE
+}
+
+// writeColumns returns the store's columns, with the OffenseCount column writing 0 in place of
+// nil when EmptyOffenseCount is EMPTY_COUNT_ZERO, and the string columns writing NULL in place of
+// empty strings when EmptyStrings is EMPTY_STRING_NULL.
+func (m *MySQLStore) writeColumns() []Column {
+	if m.EmptyOffenseCount != EMPTY_COUNT_ZERO && m.EmptyStrings != EMPTY_STRING_NULL {
+		return m.Columns
+	}
+
+	columns := slices.Clone(m.Columns)
+	for i, c := range columns {
+		switch {
+		case c.Field == "OffenseCount" && m.EmptyOffenseCount == EMPTY_COUNT_ZERO:
+			columns[i].Value = func(r *Record) any {
+				if r.OffenseCount == nil {
+					return 0
+				}
+				return *r.OffenseCount
+			}
+		case isStringColumn(c) && m.EmptyStrings == EMPTY_STRING_NULL:
+			value := c.Value
+			columns[i].Value = func(r *Record) any {
+				if s := value(r).(string); s != "" {
+					return s
+				}
+				return nil
+			}
+		}
+	}
+
+	return columns
+}
+
+// insertRecords inserts records into table in batches of INSERT_BATCH_SIZE. Columns with a
+// WriteExpr have it written in place of a placeholder.
+func insertRecords(
+	ctx context.Context,
+	tx *sql.Tx,
+	table string,
+	columns []Column,
+	records []Record,
+) error {
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		values[i] = cmp.Or(c.WriteExpr, "?")
+	}
+	placeholder := "(" + strings.Join(values, ", ") + ")"
+
+	for start := 0; start < len(records); start += INSERT_BATCH_SIZE {
+		batch := records[start:min(start+INSERT_BATCH_SIZE, len(records))]
+
+		placeholders := make([]string, len(batch))
+		args := make([]any, 0, len(batch)*len(columns))
+		for i := range batch {
+			placeholders[i] = placeholder
+			for _, c := range columns {
+				if c.WriteExpr == "" {
+					args = append(args, c.Value(&batch[i]))
+				}
+			}
+		}
+
+		stmt := fmt.Sprintf(
+			"INSERT INTO `%s` (%s) VALUES %s",
+			table,
+			ColumnNames(columns),
+			strings.Join(placeholders, ", "),
+		)
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadRecords loads records into table with LOAD DATA LOCAL INFILE, reading from an in-memory
+// tab-separated buffer registered with the mysql driver. Columns with a WriteExpr are left out of
+// the buffer and set by the statement's SET clause.
+func loadRecords(
+	ctx context.Context,
+	tx *sql.Tx,
+	table string,
+	columns []Column,
+	records []Record,
+) error {
+	var set []string
+	for _, c := range columns {
+		if c.WriteExpr != "" {
+			set = append(set, fmt.Sprintf("`%s` = %s", c.Name, c.WriteExpr))
+		}
+	}
+	columns = slices.DeleteFunc(slices.Clone(columns), func(c Column) bool {
+		return c.WriteExpr != ""
+	})
+
+	var buf bytes.Buffer
+	fields := make([]string, len(columns))
+	for i := range records {
+		for j, c := range columns {
+			fields[j] = loadDataValue(c, c.Value(&records[i]))
+		}
+		buf.WriteString(strings.Join(fields, "\t"))
+		buf.WriteByte('\n')
+	}
+
+	handler := "updater_" + table
+	mysql.RegisterReaderHandler(handler, func() io.Reader { return &buf })
+	defer mysql.DeregisterReaderHandler(handler)
+
+	stmt := fmt.Sprintf(
+		"LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE `%s` CHARACTER SET %s (%s)",
+		handler,
+		table,
+		LOAD_DATA_CHARSET,
+		ColumnNames(columns),
+	)
+	if len(set) > 0 {
+		stmt += " SET " + strings.Join(set, ", ")
+	}
+	_, err := tx.ExecContext(ctx, stmt)
+
+	return err
+}
+
+// loadDataValue formats a column value for LOAD DATA input, using \N for NULL.
+func loadDataValue(column Column, value any) string {
+	switch v := value.(type) {
+	case nil:
+		return `\N`
+	case string:
+		return escapeLoadData(v)
+	case time.Time:
+		if strings.EqualFold(column.SQLType, "DATE") {
+			return v.Format(MYSQL_DATE_FORMAT)
+		}
+		return v.Format(MYSQL_DATE_TIME_FORMAT)
+	case *float64:
+		if v == nil {
+			return `\N`
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	case *int:
+		if v == nil {
+			return `\N`
+		}
+		return strconv.Itoa(*v)
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return escapeLoadData(fmt.Sprint(v))
+	}
+}
+
+// escapeLoadData escapes s for LOAD DATA's default field and line terminators.
+func escapeLoadData(s string) string {
+	return loadDataEscaper.Replace(s)
+}
@@ -0,0 +1,214 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// discardDriver is a database driver whose connections accept every statement without doing
+// anything, for measuring the write path without a database.
+type discardDriver struct{}
+
+func (discardDriver) Open(name string) (driver.Conn, error) { return discardConn{}, nil }
+
+// discardConnector opens discardDriver connections.
+type discardConnector struct{}
+
+func (discardConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return discardConn{}, nil
+}
+
+func (discardConnector) Driver() driver.Driver { return discardDriver{} }
+
+// discardConn is a connection, and transaction, that discards every statement.
+type discardConn struct{}
+
+func (discardConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (discardConn) Close() error { return nil }
+
+func (discardConn) Begin() (driver.Tx, error) { return discardConn{}, nil }
+
+func (discardConn) Commit() error { return nil }
+
+func (discardConn) Rollback() error { return nil }
+
+func (discardConn) ExecContext(
+	ctx context.Context,
+	query string,
+	args []driver.NamedValue,
+) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func BenchmarkWriteRecords(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	data := benchmarkCSV(benchmarkRows)
+	result, err := ParseCSV(context.Background(), bytes.NewReader(data), ParseOptions{}, logger)
+	if err != nil {
+		b.Fatal(err)
+	}
+	records := result.Records
+
+	db := sql.OpenDB(discardConnector{})
+	defer db.Close()
+
+	for _, mode := range []string{WRITE_MODE_INSERT, WRITE_MODE_LOAD_DATA} {
+		b.Run(mode, func(b *testing.B) {
+			store := &MySQLStore{Db: db, Logger: logger, WriteMode: mode}
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := store.WriteRecords(ctx, "blue", records); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteRecordsEmptyOffenseCount(t *testing.T) {
+	count := 3
+
+	tests := []struct {
+		name       string
+		emptyCount string
+		count      *int
+		wantArg    any
+		wantLoad   string
+	}{
+		{name: "default writes NULL", emptyCount: "", wantArg: nil, wantLoad: `\N`},
+		{name: "null", emptyCount: EMPTY_COUNT_NULL, wantArg: nil, wantLoad: `\N`},
+		{name: "zero", emptyCount: EMPTY_COUNT_ZERO, wantArg: 0, wantLoad: "0"},
+		{
+			name:       "null keeps counts",
+			emptyCount: EMPTY_COUNT_NULL,
+			count:      &count,
+			wantArg:    3,
+			wantLoad:   "3",
+		},
+		{
+			name:       "zero keeps counts",
+			emptyCount: EMPTY_COUNT_ZERO,
+			count:      &count,
+			wantArg:    3,
+			wantLoad:   "3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newMockService(t)
+			store := s.Store.(*MySQLStore)
+			store.EmptyOffenseCount = tt.emptyCount
+			record := Record{CaseNumber: "24-1", OffenseCount: tt.count}
+
+			args := make([]driver.Value, len(s.Columns))
+			for i, c := range s.Columns {
+				args[i] = sqlmock.AnyArg()
+				if c.Field == "OffenseCount" {
+					args[i] = tt.wantArg
+				}
+			}
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `blue`")).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `blue`")).
+				WithArgs(args...).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			err := store.WriteRecords(context.Background(), "blue", []Record{record})
+			if err != nil {
+				t.Fatalf("WriteRecords: %v", err)
+			}
+
+			// LOAD DATA reads the same column values, formatted for the data file.
+			for _, c := range store.writeColumns() {
+				if c.Field != "OffenseCount" {
+					continue
+				}
+				if got := loadDataValue(c, c.Value(&record)); got != tt.wantLoad {
+					t.Errorf("LOAD DATA value = %q, want %q", got, tt.wantLoad)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteRecordsEmptyStrings(t *testing.T) {
+	tests := []struct {
+		name         string
+		emptyStrings string
+		// wantEmpty is the argument written for an empty string, and wantLoad its LOAD DATA value.
+		wantEmpty any
+		wantLoad  string
+	}{
+		{name: "default keeps empty strings", emptyStrings: "", wantEmpty: "", wantLoad: ""},
+		{name: "keep", emptyStrings: EMPTY_STRING_KEEP, wantEmpty: "", wantLoad: ""},
+		{name: "null", emptyStrings: EMPTY_STRING_NULL, wantEmpty: nil, wantLoad: `\N`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newMockService(t)
+			store := s.Store.(*MySQLStore)
+			store.EmptyStrings = tt.emptyStrings
+			record := Record{CaseNumber: "24-1", Neighborhood: "Kerns"}
+
+			args := make([]driver.Value, len(s.Columns))
+			for i, c := range s.Columns {
+				switch c.Field {
+				case "CaseNumber":
+					args[i] = "24-1"
+				case "Neighborhood":
+					args[i] = "Kerns"
+				case "Address", "CrimeAgainst", "OffenseCategory", "OffenseType":
+					args[i] = tt.wantEmpty
+				default:
+					args[i] = sqlmock.AnyArg()
+				}
+			}
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `blue`")).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `blue`")).
+				WithArgs(args...).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			err := store.WriteRecords(context.Background(), "blue", []Record{record})
+			if err != nil {
+				t.Fatalf("WriteRecords: %v", err)
+			}
+
+			// LOAD DATA reads the same column values, formatted for the data file.
+			wantLoad := map[string]string{
+				"CaseNumber":   "24-1",
+				"Neighborhood": "Kerns",
+				"Address":      tt.wantLoad,
+			}
+			for _, c := range store.writeColumns() {
+				want, ok := wantLoad[c.Field]
+				if !ok {
+					continue
+				}
+				if got := loadDataValue(c, c.Value(&record)); got != want {
+					t.Errorf("%s LOAD DATA value = %q, want %q", c.Name, got, want)
+				}
+			}
+		})
+	}
+}